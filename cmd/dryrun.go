@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/stalexan/gomarkwiki/internal/wiki"
+)
+
+// DRY_RUN_CHANGES_STATUS is the exit status of a -dry-run that found files
+// to write or delete. A dry run with nothing to do exits with status 0.
+const DRY_RUN_CHANGES_STATUS = 2
+
+// dryRunPlan records what a -dry-run would do for each wiki.
+type dryRunPlan struct {
+	mu      sync.Mutex
+	wikis   []*wiki.Wiki
+	results map[*wiki.Wiki]*wiki.BuildResult
+}
+
+// newDryRunPlan returns a plan for wikis. It sets the OnBuild of each wiki,
+// calling any OnBuild that was already set.
+func newDryRunPlan(wikis []*wiki.Wiki) *dryRunPlan {
+	plan := &dryRunPlan{wikis: wikis, results: make(map[*wiki.Wiki]*wiki.BuildResult)}
+	for _, theWiki := range wikis {
+		onBuild := theWiki.OnBuild
+		theWiki.OnBuild = func(result *wiki.BuildResult, err error) {
+			plan.mu.Lock()
+			plan.results[theWiki] = result
+			plan.mu.Unlock()
+			if onBuild != nil {
+				onBuild(result, err)
+			}
+		}
+	}
+	return plan
+}
+
+// hasChanges returns whether any wiki has files to write or delete.
+func (plan *dryRunPlan) hasChanges() bool {
+	plan.mu.Lock()
+	defer plan.mu.Unlock()
+	for _, result := range plan.results {
+		if result.HasChanges() {
+			return true
+		}
+	}
+	return false
+}
+
+// print prints the files each wiki would create, update, copy, and delete.
+func (plan *dryRunPlan) print(w io.Writer) {
+	plan.mu.Lock()
+	defer plan.mu.Unlock()
+	for _, theWiki := range plan.wikis {
+		result := plan.results[theWiki]
+		if result == nil {
+			continue
+		}
+		if !result.HasChanges() {
+			fmt.Fprintf(w, "%s: no changes\n", theWiki.DestDir)
+			continue
+		}
+
+		fmt.Fprintf(w, "%s:\n", theWiki.DestDir)
+		created := make(map[string]bool)
+		for _, name := range result.Created {
+			created[name] = true
+		}
+		for _, name := range result.Generated {
+			if created[name] {
+				fmt.Fprintf(w, "  create  %s\n", name)
+			} else {
+				fmt.Fprintf(w, "  update  %s\n", name)
+			}
+		}
+		for _, name := range result.Copied {
+			fmt.Fprintf(w, "  copy    %s\n", name)
+		}
+		// Files created other than by generating or copying, which are the
+		// CSS files.
+		for _, name := range result.Created {
+			if !slices.Contains(result.Generated, name) && !slices.Contains(result.Copied, name) {
+				fmt.Fprintf(w, "  create  %s\n", name)
+			}
+		}
+		for _, name := range result.Deleted {
+			fmt.Fprintf(w, "  delete  %s\n", name)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/stalexan/gomarkwiki/internal/wiki"
+)
+
+// exportWiki exports the page or directory given by -export from the source
+// dir of args.dirs[0] to the file that takes the place of its dest dir, as an
+// EPUB book if the file ends with .epub, or else as HTML. The file is only
+// written once the export succeeds.
+func exportWiki(args commandLineArgs, version string) error {
+	sourceDir, file := args.dirs[0][0], args.dirs[0][1]
+	theWiki, err := wiki.NewSourceWiki(sourceDir, args.log)
+	if err != nil {
+		return err
+	}
+	configureWiki(theWiki, args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var doc bytes.Buffer
+	if strings.EqualFold(filepath.Ext(file), ".epub") {
+		err = theWiki.ExportEPUB(ctx, args.exportPath, &doc, version)
+	} else {
+		err = theWiki.ExportHTML(ctx, args.exportPath, &doc, version)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, doc.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write export to '%s': %v", file, err)
+	}
+	args.log.Verbose("Exported '%s' to '%s'", args.exportPath, file)
+	return nil
+}
@@ -5,10 +5,15 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -20,9 +25,14 @@ import (
 // version holds the gomarkwiki version, and is set at build time.
 var version string
 
+// DEFAULT_GZIP_MIN_SIZE is the default for -gzip-min-size. Smaller files
+// gain little from compression.
+const DEFAULT_GZIP_MIN_SIZE = 1024
+
 // Usage message
 const usagePart1 = `Usage: gomarkwiki [options] source_dir dest_dir
        gomarkwiki [options] -wikis wikis_file
+       gomarkwiki [options] -export path source_dir file
 
 Options:`
 
@@ -32,20 +42,52 @@ Description:
 
   To generate a single wiki, use the source_dir and dest_dir parameters. Or to
   generate multiple wikis, use the -wikis option to specify a CSV file that
-  defines one wiki per line formatted as source_dir,dest_dir.
+  defines one wiki per line formatted as source_dir,dest_dir. A dest_dir
+  ending with .zip, .tar, .tar.gz, or .tgz is generated as that archive.
+
+  To export a page, or all the pages in a directory, to a single HTML file
+  that needs no other files, or to an EPUB book if file ends with .epub, use
+  -export with the path of the page or directory relative to the content
+  directory.
 
 Examples:
   gomarkwiki /path/to/source /path/to/destination
-  gomarkwiki -wikis wikis.csv`
+  gomarkwiki /path/to/source site.tar.gz
+  gomarkwiki -wikis wikis.csv
+  gomarkwiki -export Runbooks /path/to/source runbooks.html
+  gomarkwiki -export Runbooks /path/to/source runbooks.epub`
 
 // commandLineArgs stores the arguments specified on the command line.
 type commandLineArgs struct {
-	dirs         [][2]string
-	cpuProfile   string
-	regen        bool
-	clean        bool
-	watch        bool
-	pollInterval time.Duration
+	dirs          [][2]string
+	wikisPath     string // CSV file the wikis were read from, if any
+	exportPath    string // Page or directory to export to the file dirs[0][1], if any
+	chapters      []string
+	cpuProfile    string
+	regen         bool
+	clean         bool
+	dryRun        bool
+	layout        string
+	linkStatic    string
+	fingerprint   bool
+	imageWidths   []int
+	minify        bool
+	gzip          bool
+	gzipLevel     int
+	gzipMinSize   int64
+	watch         bool
+	pollInterval  time.Duration
+	allowEnv      []string
+	subsInCode    bool
+	gitignore     bool
+	cacheDir      string
+	reportPath    string
+	hookTimeout   time.Duration
+	pluginTimeout time.Duration
+	metricsAddr   string
+	onError       string
+	log           util.Logger
+	logFile       *os.File // File given by -log-file, or nil
 }
 
 // formatVersion() returns the string displayed by the --version option.
@@ -60,12 +102,34 @@ func parseCommandLine() commandLineArgs {
 	printVersion := flag.Bool("version", false, "Print version information")
 	regen := flag.Bool("regen", false, "Regenerate all files regardless of timestamps")
 	clean := flag.Bool("clean", false, "Delete any files in dest_dir that do not have a corresponding file in source_dir")
+	dryRun := flag.Bool("dry-run", false, "Show what would be generated, copied, and deleted, without changing dest_dir. Exits with status 2 if there are changes to make")
+	layout := flag.String("layout", wiki.LayoutFlat, "Where pages go in dest_dir: flat for Foo/Bar.html, or pretty for Foo/Bar/index.html")
+	linkStatic := flag.String("link-static", wiki.LinkStaticCopy, "How static files are put in dest_dir: copy, or reflink to clone them on filesystems that support it. Falls back to copying")
+	fingerprint := flag.Bool("fingerprint", false, "Add a hash of their contents to the names of style sheets, and of CSS, JavaScript, image, and font files, rewriting links to match, so that they can be cached for good")
+	imageWidths := flag.String("image-widths", "", "Comma-separated widths, in pixels, of downscaled variants to write for PNG, JPEG, and GIF images, e.g. 480,960, which images in Markdown list in a srcset")
+	minify := flag.Bool("minify", false, "Remove comments and collapse whitespace in generated pages and style sheets, printing the bytes saved with -verbose")
+	gzip := flag.Bool("gzip", false, "Write a .gz compressed copy next to each HTML, CSS, JavaScript, SVG, and JSON file in dest_dir, for web servers that serve precompressed files")
+	gzipLevel := flag.Int("gzip-level", wiki.DefaultGzipLevel, "Compression level of -gzip, from 1 for fastest to 9 for smallest")
+	gzipMinSize := flag.Int64("gzip-min-size", DEFAULT_GZIP_MIN_SIZE, "Smallest file, in bytes, that -gzip compresses")
 	watch := flag.Bool("watch", false, "Remain running and watch for changes to regenerate files on the fly")
 	pollInterval := flag.Duration("poll-interval", 0, "Use polling (every duration, e.g. 2s) instead of fsnotify; required for inotify-blind filesystems (macOS-virtualized mounts, NFS, SMB). Requires -watch.")
+	allowEnv := flag.String("allow-env", "", "Comma-separated list of environment variables that {{env:NAME}} placeholders may expand to")
+	subsInCode := flag.Bool("subs-in-code", false, "Make substitutions inside fenced code blocks and inline code too")
+	gitignore := flag.Bool("gitignore", false, "Also ignore files matched by .gitignore files in the content directory, and .git directories")
+	cacheDir := flag.String("cache-dir", "", "Save what each page includes in dir between runs, so that pages that haven't changed aren't read again")
+	exportPath := flag.String("export", "", "Export the page or directory at path, relative to the content directory, to a single HTML file with styles inlined and images embedded, or to an EPUB book if the file, given in place of dest_dir, ends with .epub")
+	chapters := flag.String("chapters", "", "Comma-separated pages, relative to the directory given to -export, in the order they go in an EPUB book, ahead of the others")
+	reportPath := flag.String("report", "", "Write a JSON report of what was done for each wiki to file, rewritten after each regeneration in watch mode")
+	hookTimeout := flag.Duration("hook-timeout", wiki.DefaultHookTimeout, "How long each command in hooks.csv may run before it's killed")
+	pluginTimeout := flag.Duration("plugin-timeout", wiki.DefaultPluginTimeout, "How long each command in plugins.csv may take to transform a page before it's killed")
+	metricsAddr := flag.String("metrics-addr", "", "Serve metrics at /metrics and a health check at /healthz over HTTP on address, e.g. localhost:9090")
+	onError := flag.String("on-error", ON_ERROR_FAIL_FAST, "What to do when a wiki fails: fail-fast to stop all wikis, or keep-going to let the others keep running, restarting the failed wiki with backoff under -watch")
 	var wikisCsvPath string
 	flag.StringVar(&wikisCsvPath, "wikis", "", "Generate wikis specified in CSV file, with one wiki defined per line formatted as source_dir,dest_dir")
-	flag.BoolVar(&util.Verbose, "verbose", false, "Print status messages")
-	flag.BoolVar(&util.Debug, "debug", false, "Print debug messages")
+	verbose := flag.Bool("verbose", false, "Print status messages")
+	debug := flag.Bool("debug", false, "Print debug messages")
+	logFormat := flag.String("log-format", "text", "Format of messages printed: text, or json for one JSON object per line on stderr")
+	logFilePath := flag.String("log-file", "", "Append messages, warnings, and errors to file instead of printing them")
 	cpuProfile := flag.String("cpuprofile", "", "Write cpu profile to file")
 
 	// Define custom usage message.
@@ -90,6 +154,26 @@ func parseCommandLine() commandLineArgs {
 		os.Exit(0)
 	}
 
+	// Validate -log-format.
+	if *logFormat != "text" && *logFormat != "json" {
+		util.PrintFatalError(nil, "-log-format must be text or json (got '%s')", *logFormat)
+	}
+
+	// Validate -hook-timeout.
+	if *hookTimeout <= 0 {
+		util.PrintFatalError(nil, "-hook-timeout must be positive (got %s)", *hookTimeout)
+	}
+
+	// Validate -plugin-timeout.
+	if *pluginTimeout <= 0 {
+		util.PrintFatalError(nil, "-plugin-timeout must be positive (got %s)", *pluginTimeout)
+	}
+
+	// Validate -on-error.
+	if *onError != ON_ERROR_FAIL_FAST && *onError != ON_ERROR_KEEP_GOING {
+		util.PrintFatalError(nil, "-on-error must be %s or %s (got '%s')", ON_ERROR_FAIL_FAST, ON_ERROR_KEEP_GOING, *onError)
+	}
+
 	// Validate -poll-interval. Negative is always invalid; positive without
 	// -watch is a misconfiguration we surface rather than silently ignore.
 	if *pollInterval < 0 {
@@ -99,6 +183,46 @@ func parseCommandLine() commandLineArgs {
 		util.PrintFatalError(nil, "-poll-interval requires -watch")
 	}
 
+	// Validate -layout.
+	if *layout != wiki.LayoutFlat && *layout != wiki.LayoutPretty {
+		util.PrintFatalError(nil, "-layout must be %s or %s (got '%s')", wiki.LayoutFlat, wiki.LayoutPretty, *layout)
+	}
+
+	// Validate -link-static.
+	if *linkStatic != wiki.LinkStaticCopy && *linkStatic != wiki.LinkStaticReflink {
+		util.PrintFatalError(nil, "-link-static must be %s or %s (got '%s')", wiki.LinkStaticCopy, wiki.LinkStaticReflink, *linkStatic)
+	}
+
+	// Validate -gzip-level and -gzip-min-size.
+	if *gzipLevel < 1 || *gzipLevel > 9 {
+		util.PrintFatalError(nil, "-gzip-level must be from 1 to 9 (got %d)", *gzipLevel)
+	}
+	if *gzipMinSize < 0 {
+		util.PrintFatalError(nil, "-gzip-min-size must not be negative (got %d)", *gzipMinSize)
+	}
+
+	// Validate -image-widths.
+	var widths []int
+	for _, element := range parseList(*imageWidths) {
+		width, err := strconv.Atoi(element)
+		if err != nil || width <= 0 {
+			util.PrintFatalError(nil, "-image-widths must be a comma-separated list of positive widths (got '%s')", *imageWidths)
+		}
+		widths = append(widths, width)
+	}
+
+	// Validate -dry-run, which generates just once.
+	if *dryRun && *watch {
+		util.PrintFatalError(nil, "-dry-run can't be used with -watch")
+	}
+
+	// Validate -export, which writes a single file once.
+	if *exportPath != "" {
+		if wikisCsvPath != "" || *watch || *dryRun || *clean {
+			util.PrintFatalError(nil, "-export can't be used with -wikis, -watch, -dry-run, or -clean")
+		}
+	}
+
 	// What directories are specified?
 	dirs := make([][2]string, 0)
 	if wikisCsvPath != "" {
@@ -115,14 +239,80 @@ func parseCommandLine() commandLineArgs {
 		os.Exit(1)
 	}
 
+	// Validate -watch, which can't update an archive in place.
+	if *watch {
+		for _, dirPair := range dirs {
+			if wiki.IsArchivePath(dirPair[1]) {
+				util.PrintFatalError(nil, "-watch can't be used with an archive as dest_dir (got '%s')", dirPair[1])
+			}
+		}
+	}
+
+	// Create the logger, which prints to -log-file if given.
+	var logFile *os.File
+	out, errOut := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if *logFilePath != "" {
+		var err error
+		if logFile, err = os.OpenFile(*logFilePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+			util.PrintFatalError(err, "Failed to open log file '%s'", *logFilePath)
+		}
+		out, errOut = logFile, logFile
+	}
+	var log util.Logger
+	if *logFormat == "json" {
+		log = util.NewJSONLogger(errOut, *verbose, *debug)
+	} else {
+		log = util.NewLogger(out, errOut, *verbose, *debug)
+	}
+
 	return commandLineArgs{
-		dirs:         dirs,
-		cpuProfile:   *cpuProfile,
-		regen:        *regen,
-		clean:        *clean,
-		watch:        *watch,
-		pollInterval: *pollInterval,
+		dirs:          dirs,
+		wikisPath:     wikisCsvPath,
+		exportPath:    *exportPath,
+		chapters:      parseList(*chapters),
+		cpuProfile:    *cpuProfile,
+		regen:         *regen,
+		clean:         *clean,
+		dryRun:        *dryRun,
+		layout:        *layout,
+		linkStatic:    *linkStatic,
+		fingerprint:   *fingerprint,
+		imageWidths:   widths,
+		minify:        *minify,
+		gzip:          *gzip,
+		gzipLevel:     *gzipLevel,
+		gzipMinSize:   *gzipMinSize,
+		watch:         *watch,
+		pollInterval:  *pollInterval,
+		allowEnv:      parseList(*allowEnv),
+		subsInCode:    *subsInCode,
+		gitignore:     *gitignore,
+		cacheDir:      *cacheDir,
+		reportPath:    *reportPath,
+		hookTimeout:   *hookTimeout,
+		pluginTimeout: *pluginTimeout,
+		metricsAddr:   *metricsAddr,
+		onError:       *onError,
+		log:           log,
+		logFile:       logFile,
+	}
+}
+
+// fatal logs an error message with log, for err if it's not nil, and exits.
+func fatal(log util.Logger, err error, format string, args ...any) {
+	log.Error(err, format, args...)
+	os.Exit(1)
+}
+
+// parseList splits a comma-separated flag value into its non-empty elements.
+func parseList(value string) []string {
+	var list []string
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			list = append(list, element)
+		}
 	}
+	return list
 }
 
 func main() {
@@ -131,44 +321,125 @@ func main() {
 
 	// Start profiling.
 	if args.cpuProfile != "" {
-		util.PrintVerbose("Starting profiler")
+		args.log.Verbose("Starting profiler")
 		var file *os.File
 		var err error
 		if file, err = os.Create(args.cpuProfile); err != nil {
-			util.PrintFatalError(err, "Failed to create file '%s'", args.cpuProfile)
+			fatal(args.log, err, "Failed to create file '%s'", args.cpuProfile)
 		}
 		defer file.Close()
 		if err = pprof.StartCPUProfile(file); err != nil {
-			util.PrintFatalError(err, "Failed to start profiler")
+			fatal(args.log, err, "Failed to start profiler")
 		}
 		defer func() {
-			util.PrintVerbose("Stopping profiler")
+			args.log.Verbose("Stopping profiler")
 			pprof.StopCPUProfile()
 		}()
 	}
 
+	// Export a page or directory instead of generating wikis.
+	if args.exportPath != "" {
+		if err := exportWiki(args, version); err != nil {
+			fatal(args.log, err, "")
+		}
+		os.Exit(0)
+	}
+
 	// Create Wiki instances
 	var wikis []*wiki.Wiki
-	var err error
 	for _, dirPair := range args.dirs {
-		var theWiki *wiki.Wiki
-		if theWiki, err = wiki.NewWiki(dirPair[0], dirPair[1]); err != nil {
-			util.PrintFatalError(err, "")
+		theWiki, err := newWiki(dirPair, args)
+		if err != nil {
+			fatal(args.log, err, "")
 		}
-		theWiki.PollInterval = args.pollInterval
 		wikis = append(wikis, theWiki)
 	}
 
+	// Write a report after each generation.
+	var reporter *wiki.Reporter
+	if args.reportPath != "" {
+		reporter = wiki.NewReporter(args.reportPath, wikis, version, args.log)
+	}
+
+	// Serve metrics and the health check.
+	var metrics *wiki.Metrics
+	if args.metricsAddr != "" {
+		listener, err := net.Listen("tcp", args.metricsAddr)
+		if err != nil {
+			fatal(args.log, err, "Failed to listen on '%s' for metrics", args.metricsAddr)
+		}
+		metrics = wiki.NewMetrics(wikis)
+		go func() {
+			if err := http.Serve(listener, metrics.Handler()); err != nil {
+				args.log.Error(err, "failed to serve metrics")
+			}
+		}()
+		args.log.Verbose("Serving metrics on %s", listener.Addr())
+	}
+
+	// Record what a dry run would do.
+	var plan *dryRunPlan
+	if args.dryRun {
+		plan = newDryRunPlan(wikis)
+	}
+
+	// Reload the wikis file when it changes while watching.
+	var reload *reloader
+	if args.watch && args.wikisPath != "" {
+		reload = newReloader(args, reporter, metrics)
+	}
+
 	// Generate wikis
-	util.PrintVerbose("Starting %s", formatVersion())
-	if err = generateWikis(wikis, args.regen, args.clean, args.watch, version); err != nil {
-		util.PrintFatalError(err, "")
+	args.log.Verbose("Starting %s", formatVersion())
+	if err := generateWikis(wikis, args, version, reload); err != nil {
+		fatal(args.log, err, "")
+	}
+
+	// Show what a dry run would do, with a status that tells whether there's
+	// anything to do.
+	if plan != nil {
+		plan.print(os.Stdout)
+		if plan.hasChanges() {
+			os.Exit(DRY_RUN_CHANGES_STATUS)
+		}
 	}
 
 	// Success.
 	os.Exit(0)
 }
 
+// newWiki creates the wiki for dirPair, a source dir and dest dir, configured
+// by args.
+func newWiki(dirPair [2]string, args commandLineArgs) (*wiki.Wiki, error) {
+	theWiki, err := wiki.NewWiki(dirPair[0], dirPair[1], args.log)
+	if err != nil {
+		return nil, err
+	}
+	configureWiki(theWiki, args)
+	return theWiki, nil
+}
+
+// configureWiki sets the options of theWiki from args.
+func configureWiki(theWiki *wiki.Wiki, args commandLineArgs) {
+	theWiki.PollInterval = args.pollInterval
+	theWiki.EnvAllowlist = args.allowEnv
+	theWiki.SubstituteInCode = args.subsInCode
+	theWiki.UseGitignore = args.gitignore
+	theWiki.CacheDir = args.cacheDir
+	theWiki.HookTimeout = args.hookTimeout
+	theWiki.PluginTimeout = args.pluginTimeout
+	theWiki.DryRun = args.dryRun
+	theWiki.Layout = args.layout
+	theWiki.LinkStatic = args.linkStatic
+	theWiki.Fingerprint = args.fingerprint
+	theWiki.ImageWidths = args.imageWidths
+	theWiki.Minify = args.minify
+	theWiki.Gzip = args.gzip
+	theWiki.GzipLevel = args.gzipLevel
+	theWiki.GzipMinSize = args.gzipMinSize
+	theWiki.Chapters = args.chapters
+}
+
 // collectAllErrors drains the error channel and collects all errors.
 func collectAllErrors(errorChan chan error) []error {
 	var errors []error
@@ -197,12 +468,20 @@ func formatErrors(errs []error) error {
 }
 
 // generateWikis generates the wikis and then optionally watch watches for
-// changes in each wiki to regenerate files on the fly.
-func generateWikis(wikis []*wiki.Wiki, regen, clean, watch bool, version string) error {
+// changes in each wiki to regenerate files on the fly. When watching, reload,
+// if not nil, starts and stops wikis as the wikis file changes.
+//
+// With -on-error=fail-fast, the first wiki to fail stops the others. With
+// keep-going the others keep running, and when watching the failed wiki is
+// restarted after a backoff that doubles each time it fails in a row. Either
+// way the wikis that failed are listed before returning.
+func generateWikis(wikis []*wiki.Wiki, args commandLineArgs, version string, reload *reloader) error {
 	// Validate that we have wikis to generate
 	if len(wikis) == 0 {
 		return fmt.Errorf("no wikis to generate")
 	}
+	log := args.log
+	keepGoing := args.onError == ON_ERROR_KEEP_GOING
 
 	// Create context for cancellation
 	ctx, cancel := context.WithCancel(context.Background())
@@ -214,42 +493,68 @@ func generateWikis(wikis []*wiki.Wiki, regen, clean, watch bool, version string)
 	signal.Notify(termChan, os.Interrupt, syscall.SIGTERM)
 
 	var wg sync.WaitGroup
-
-	// Define worker function.
-	worker := func(wiki *wiki.Wiki) {
+	var failed failures
+	defer failed.printSummary(log)
+
+	// Define worker function. Failed wikis are restarted with keep-going
+	// when watching; otherwise a wiki's error is reported and its worker
+	// stops.
+	sup := newSupervisor(keepGoing && args.watch, &failed, log)
+	worker := func(ctx context.Context, wiki *wiki.Wiki, after <-chan struct{}, done chan struct{}) {
 		defer wg.Done()
-		// Generate wiki with context.
-		if err := wiki.Generate(ctx, regen, clean, watch, version); err != nil {
+		defer close(done)
+		err := sup.run(ctx, wiki.SourceDir, after, func(ctx context.Context) error {
+			return wiki.Generate(ctx, args.regen, args.clean, args.watch, version)
+		})
+		if err != nil {
 			select {
 			case errorChan <- err:
 			case <-ctx.Done():
 			}
-			return
 		}
 	}
 
-	// Start workers.
-	wg.Add(len(wikis))
+	// Start workers, each with its own context so that it can be stopped
+	// when its wiki is removed from the wikis file. A worker that replaces
+	// one that was stopped first waits for after to be closed.
+	running := make(map[[2]string]*runningWiki)
+	start := func(wiki *wiki.Wiki, after <-chan struct{}) {
+		wikiCtx, wikiCancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		running[wikiKey(wiki)] = &runningWiki{wiki: wiki, cancel: wikiCancel, done: done}
+		wg.Add(1)
+		go worker(wikiCtx, wiki, after, done)
+	}
 	for _, wiki := range wikis {
-		go worker(wiki)
+		start(wiki, nil)
 	}
 
 	// Watch for completions, errors, and terminate signal.
-	if watch {
-		// When watching, workers never complete normally - only wait for errors or termination
-		select {
-		case err := <-errorChan:
-			cancel()  // Cancel all workers
-			wg.Wait() // Wait for workers to finish cleanup
-			// Collect all errors that occurred
-			errors := collectAllErrors(errorChan)
-			errors = append([]error{err}, errors...) // Prepend the first error
-			return formatErrors(errors)
-		case <-termChan:
-			fmt.Println("Terminate signal received. Exiting...")
-			cancel()  // Cancel all workers
-			wg.Wait() // Wait for workers to finish cleanup
-			return nil
+	if args.watch {
+		var reloadChan <-chan struct{} // Nil, so never ready, unless reloading
+		if reload != nil {
+			reloadChan = reload.watch(ctx)
+		}
+
+		// When watching, workers never complete normally - only wait for
+		// errors or termination. Workers only report errors with fail-fast.
+		for {
+			select {
+			case err := <-errorChan:
+				cancel()  // Cancel all workers
+				wg.Wait() // Wait for workers to finish cleanup
+				// Collect all errors that occurred
+				errors := collectAllErrors(errorChan)
+				errors = append([]error{err}, errors...) // Prepend the first error
+				return formatErrors(errors)
+			case <-termChan:
+				log.Message("Terminate signal received. Exiting...")
+				cancel()  // Cancel all workers
+				wg.Wait() // Wait for workers to finish cleanup
+				return nil
+			case <-reloadChan:
+				reload.apply(running, start)
+			}
 		}
 	} else {
 		// When not watching, wait for all workers to complete
@@ -260,21 +565,25 @@ func generateWikis(wikis []*wiki.Wiki, regen, clean, watch bool, version string)
 			close(done)
 		}()
 
+		var errs []error // Errors so far, with keep-going
 		for {
 			select {
 			case <-done:
 				// All workers completed - check if any errors occurred
-				errs := collectAllErrors(errorChan)
+				errs = append(errs, collectAllErrors(errorChan)...)
 				return formatErrors(errs)
-			case firstErr := <-errorChan:
+			case err := <-errorChan:
+				if keepGoing {
+					errs = append(errs, err)
+					continue // Let the other workers finish
+				}
 				cancel()  // Cancel all workers
 				wg.Wait() // Wait for workers to finish cleanup
 				// Collect all remaining errors
-				errs := collectAllErrors(errorChan)
-				errs = append([]error{firstErr}, errs...) // Prepend the first error
+				errs = append([]error{err}, collectAllErrors(errorChan)...) // Prepend the first error
 				return formatErrors(errs)
 			case <-termChan:
-				fmt.Println("Terminate signal received. Exiting...")
+				log.Message("Terminate signal received. Exiting...")
 				cancel()  // Cancel all workers
 				wg.Wait() // Wait for workers to finish cleanup
 				return nil
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+	"github.com/stalexan/gomarkwiki/internal/wiki"
+)
+
+// WIKIS_CHECK_INTERVAL is how often the wikis file is checked for changes
+// when reloading it, unless -poll-interval is given.
+const WIKIS_CHECK_INTERVAL = 2 * time.Second
+
+// runningWiki is a wiki being generated by a worker.
+type runningWiki struct {
+	wiki   *wiki.Wiki
+	cancel context.CancelFunc // Stops the worker
+	done   chan struct{}      // Closed when the worker has stopped
+}
+
+// wikiKey returns the key that identifies a wiki by its source and dest dirs.
+func wikiKey(theWiki *wiki.Wiki) [2]string {
+	return [2]string{theWiki.SourceDir, theWiki.DestDir}
+}
+
+// reloader reloads the wikis file in watch mode, when it changes and on
+// SIGHUP, starting workers for wikis that were added and stopping those for
+// wikis that were removed. Wikis that are still in the file keep running.
+type reloader struct {
+	path     string
+	interval time.Duration
+	args     commandLineArgs
+	log      util.Logger
+	reporter *wiki.Reporter // Reports on added wikis, if not nil
+	metrics  *wiki.Metrics  // Has metrics for added wikis, if not nil
+
+	// stopping has the done channels of the workers of removed wikis, by
+	// their source and dest dirs, so that a wiki that's added back isn't
+	// started until its old worker has stopped writing to its dest.
+	stopping map[[2]string]chan struct{}
+}
+
+// newReloader returns a reloader for the wikis file given by args.
+func newReloader(args commandLineArgs, reporter *wiki.Reporter, metrics *wiki.Metrics) *reloader {
+	interval := WIKIS_CHECK_INTERVAL
+	if args.pollInterval > 0 {
+		interval = args.pollInterval
+	}
+	return &reloader{
+		path:     args.wikisPath,
+		interval: interval,
+		args:     args,
+		log:      args.log,
+		reporter: reporter,
+		metrics:  metrics,
+		stopping: make(map[[2]string]chan struct{}),
+	}
+}
+
+// watch returns a channel that's sent to when the wikis file should be
+// reloaded, until ctx is done. The file is reloaded on SIGHUP, and once a
+// change to it has stopped changing for a check interval, so that it isn't
+// read partly written.
+func (r *reloader) watch(ctx context.Context) <-chan struct{} {
+	reloadChan := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case reloadChan <- struct{}{}:
+		default: // A reload is already pending
+		}
+	}
+
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hupChan)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		loaded := r.stat() // State of the file when it was last loaded
+		seen := loaded     // State of the file at the last check
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupChan:
+				r.log.Message("SIGHUP received. Reloading '%s'", r.path)
+				loaded = r.stat()
+				seen = loaded
+				notify()
+			case <-ticker.C:
+				current := r.stat()
+				if current != seen {
+					seen = current // Changing, so wait for it to settle
+				} else if current != loaded && current.exists {
+					r.log.Verbose("Wikis file '%s' changed. Reloading", r.path)
+					loaded = current
+					notify()
+				}
+			}
+		}
+	}()
+
+	return reloadChan
+}
+
+// fileState is what's compared to see whether a file has changed.
+type fileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+// stat returns the state of the wikis file.
+func (r *reloader) stat() fileState {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, modTime: info.ModTime(), size: info.Size()}
+}
+
+// apply reloads the wikis file. It stops and removes the wikis in running
+// that are no longer in the file, and calls start for each that was added,
+// with the done channel of the worker it replaces if that worker may still
+// be stopping. If the file can't be read, the running wikis are left as they
+// are.
+func (r *reloader) apply(running map[[2]string]*runningWiki, start func(theWiki *wiki.Wiki, after <-chan struct{})) {
+	dirs, err := util.LoadStringPairs(r.path)
+	if err != nil {
+		r.log.Error(err, "failed to reload '%s', keeping the current wikis", r.path)
+		return
+	}
+	if len(dirs) == 0 {
+		r.log.Warning("No wikis in '%s', keeping the current wikis", r.path)
+		return
+	}
+
+	// Find the wikis in the file, by the absolute paths NewWiki uses.
+	wanted := make(map[[2]string][2]string)
+	for _, dirPair := range dirs {
+		sourceDir, err := filepath.Abs(dirPair[0])
+		if err != nil {
+			r.log.Error(err, "failed to resolve absolute path of source directory '%s'", dirPair[0])
+			continue
+		}
+		destDir, err := filepath.Abs(dirPair[1])
+		if err != nil {
+			r.log.Error(err, "failed to resolve absolute path of destination directory '%s'", dirPair[1])
+			continue
+		}
+		wanted[[2]string{sourceDir, destDir}] = dirPair
+	}
+
+	// Stop the wikis that were removed.
+	for key, stopped := range running {
+		if _, ok := wanted[key]; ok {
+			continue
+		}
+		r.log.Message("Stopping wiki '%s', which was removed from '%s'", key[0], r.path)
+		delete(running, key)
+		stopped.cancel()
+		r.stopping[key] = stopped.done
+		if r.reporter != nil {
+			r.reporter.Remove(stopped.wiki)
+		}
+		if r.metrics != nil {
+			r.metrics.Remove(stopped.wiki)
+		}
+	}
+
+	// Forget the workers that have stopped.
+	for key, done := range r.stopping {
+		select {
+		case <-done:
+			delete(r.stopping, key)
+		default:
+		}
+	}
+
+	// Start the wikis that were added.
+	for key, dirPair := range wanted {
+		if _, ok := running[key]; ok {
+			continue
+		}
+		if wiki.IsArchivePath(dirPair[1]) {
+			r.log.Error(nil, "not adding wiki '%s', since an archive dest '%s' can't be watched", dirPair[0], dirPair[1])
+			continue
+		}
+		theWiki, err := newWiki(dirPair, r.args)
+		if err != nil {
+			r.log.Error(err, "failed to add wiki '%s'", dirPair[0])
+			continue
+		}
+		r.log.Message("Starting wiki '%s', which was added to '%s'", theWiki.SourceDir, r.path)
+		if r.reporter != nil {
+			r.reporter.Add(theWiki)
+		}
+		if r.metrics != nil {
+			r.metrics.Add(theWiki)
+		}
+		start(theWiki, r.stopping[key])
+		delete(r.stopping, key)
+	}
+}
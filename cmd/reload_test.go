@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+	"github.com/stalexan/gomarkwiki/internal/wiki"
+)
+
+// testReloader returns a reloader for a wikis file in a temp dir, along with
+// a function that creates the source dir of a wiki named name, with its
+// content dir, and returns the line for it in the wikis file.
+func testReloader(t *testing.T) (*reloader, func(name string) string) {
+	t.Helper()
+	dir := t.TempDir()
+	args := commandLineArgs{
+		wikisPath:    filepath.Join(dir, "wikis.csv"),
+		pollInterval: 10 * time.Millisecond,
+		log:          util.NewLogger(io.Discard, io.Discard, false, false),
+	}
+	addWiki := func(name string) string {
+		sourceDir := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Join(sourceDir, "content"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return sourceDir + "," + filepath.Join(dir, name+"-out")
+	}
+	return newReloader(args, nil, nil), addWiki
+}
+
+// writeWikis writes lines to the wikis file of r.
+func writeWikis(t *testing.T, r *reloader, lines ...string) {
+	t.Helper()
+	if err := os.WriteFile(r.path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wikiLineKey returns the key of the wiki in line, a line of a wikis file
+// with absolute paths.
+func wikiLineKey(line string) [2]string {
+	sourceDir, destDir, _ := strings.Cut(line, ",")
+	return [2]string{sourceDir, destDir}
+}
+
+// startRecorder returns a start function for apply that adds the wikis it's
+// called with to running, and records the after channel each was given, by
+// source dir.
+func startRecorder(running map[[2]string]*runningWiki) (func(*wiki.Wiki, <-chan struct{}), map[string]<-chan struct{}) {
+	started := make(map[string]<-chan struct{})
+	start := func(theWiki *wiki.Wiki, after <-chan struct{}) {
+		_, cancel := context.WithCancel(context.Background())
+		running[wikiKey(theWiki)] = &runningWiki{wiki: theWiki, cancel: cancel, done: make(chan struct{})}
+		started[theWiki.SourceDir] = after
+	}
+	return start, started
+}
+
+func TestReloaderApply(t *testing.T) {
+	r, addWiki := testReloader(t)
+	a, b, c := addWiki("a"), addWiki("b"), addWiki("c")
+	running := make(map[[2]string]*runningWiki)
+
+	// All the wikis in the file are started.
+	writeWikis(t, r, a, b)
+	start, started := startRecorder(running)
+	r.apply(running, start)
+	if len(started) != 2 || len(running) != 2 {
+		t.Fatalf("started %d and running %d wikis, want 2", len(started), len(running))
+	}
+	aKey := wikiLineKey(a)
+	aWorker := running[aKey]
+
+	// Removing a and adding c stops a, starts c, and leaves b alone.
+	writeWikis(t, r, b, c)
+	start, started = startRecorder(running)
+	r.apply(running, start)
+	if _, ok := started[wikiLineKey(c)[0]]; !ok || len(started) != 1 {
+		t.Errorf("started %v, want only c", started)
+	}
+	if _, ok := running[aKey]; ok || len(running) != 2 {
+		t.Errorf("running %d wikis including a, want b and c", len(running))
+	}
+	if _, ok := r.stopping[aKey]; !ok {
+		t.Error("a isn't waited for after it was stopped")
+	}
+
+	// A wikis file that is empty or missing leaves the wikis running.
+	writeWikis(t, r, "")
+	r.apply(running, start)
+	if err := os.Remove(r.path); err != nil {
+		t.Fatal(err)
+	}
+	r.apply(running, start)
+	if len(running) != 2 || len(started) != 1 {
+		t.Errorf("running %d and started %d wikis, want the same 2 and 1", len(running), len(started))
+	}
+
+	// Adding a back while its old worker is still stopping starts it after
+	// the old worker is done.
+	writeWikis(t, r, a, b, c)
+	start, started = startRecorder(running)
+	r.apply(running, start)
+	if after, ok := started[aKey[0]]; !ok || after != (<-chan struct{})(aWorker.done) {
+		t.Errorf("a started after %v, want after its old worker %v", after, aWorker.done)
+	}
+	if len(r.stopping) != 0 {
+		t.Errorf("stopping = %v, want nothing once a is started again", r.stopping)
+	}
+
+	// Once the old worker has stopped, a is started right away.
+	writeWikis(t, r, b)
+	r.apply(running, start)
+	close(r.stopping[aKey])
+	writeWikis(t, r, a, b)
+	start, started = startRecorder(running)
+	r.apply(running, start)
+	if after, ok := started[aKey[0]]; !ok || after != nil {
+		t.Errorf("a started after %v, want right away", after)
+	}
+}
+
+func TestReloaderWatchSIGHUP(t *testing.T) {
+	r, addWiki := testReloader(t)
+	writeWikis(t, r, addWiki("a"))
+	r.interval = time.Hour // So that only SIGHUP reloads
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloadChan := r.watch(ctx)
+
+	// The signal handler is installed before watch returns, so the signal
+	// is caught rather than ending the test.
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloadChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after SIGHUP")
+	}
+}
+
+func TestReloaderWatchChange(t *testing.T) {
+	r, addWiki := testReloader(t)
+	writeWikis(t, r, addWiki("a"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloadChan := r.watch(ctx)
+
+	// A change is reloaded once it has settled.
+	time.Sleep(20 * time.Millisecond)
+	writeWikis(t, r, addWiki("a"), addWiki("b"))
+	select {
+	case <-reloadChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after the wikis file changed")
+	}
+}
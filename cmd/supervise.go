@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+// Error policies, selected with -on-error.
+const (
+	// ON_ERROR_FAIL_FAST stops all wikis when any wiki fails.
+	ON_ERROR_FAIL_FAST = "fail-fast"
+
+	// ON_ERROR_KEEP_GOING lets the other wikis keep running when a wiki
+	// fails. With -watch the failed wiki is restarted after a backoff.
+	ON_ERROR_KEEP_GOING = "keep-going"
+)
+
+// Restart backoff configuration for -on-error=keep-going with -watch.
+const (
+	// RESTART_MIN_BACKOFF is how long to wait before restarting a wiki that
+	// failed for the first time, or that ran for at least
+	// RESTART_MAX_BACKOFF before failing.
+	RESTART_MIN_BACKOFF = 1 * time.Second
+
+	// RESTART_MAX_BACKOFF caps the wait before restarting a wiki, which
+	// doubles each time it fails in a row.
+	RESTART_MAX_BACKOFF = 5 * time.Minute
+)
+
+// supervisor runs the workers of wikis, restarting those that fail with a
+// backoff when it's told to.
+type supervisor struct {
+	restart    bool          // Whether failed wikis are restarted, rather than their errors returned
+	minBackoff time.Duration // Wait before the first restart, and after a wiki ran for maxBackoff
+	maxBackoff time.Duration // Cap on the wait, which doubles each time a wiki fails in a row
+	now        func() time.Time
+	after      func(d time.Duration) <-chan time.Time
+	failed     *failures
+	log        util.Logger
+}
+
+// newSupervisor returns a supervisor with the RESTART_*_BACKOFF backoffs that
+// records failures in failed.
+func newSupervisor(restart bool, failed *failures, log util.Logger) *supervisor {
+	return &supervisor{
+		restart:    restart,
+		minBackoff: RESTART_MIN_BACKOFF,
+		maxBackoff: RESTART_MAX_BACKOFF,
+		now:        time.Now,
+		after:      time.After,
+		failed:     failed,
+		log:        log,
+	}
+}
+
+// run calls generate to generate the wiki with source dir sourceDir, once
+// after is closed if it isn't nil, so that the worker of a wiki that replaces
+// one that was stopped never writes to the same dest at once. It returns
+// generate's error, unless restarting, in which case the wiki is generated
+// again after a backoff that starts over if the wiki ran for a while before
+// failing. It returns nil once generate succeeds or ctx is done.
+func (s *supervisor) run(ctx context.Context, sourceDir string, after <-chan struct{}, generate func(ctx context.Context) error) error {
+	if after != nil {
+		select {
+		case <-after:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	backoff := s.minBackoff
+	for {
+		started := s.now()
+		err := generate(ctx)
+		if err == nil || ctx.Err() != nil {
+			return nil // Done, or cancelled so the error isn't the wiki's
+		}
+		s.failed.record(sourceDir)
+		if !s.restart {
+			return err
+		}
+
+		if s.now().Sub(started) >= s.maxBackoff {
+			backoff = s.minBackoff
+		}
+		s.log.Error(err, "wiki '%s' failed, restarting in %s", sourceDir, backoff)
+		select {
+		case <-s.after(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		backoff = min(backoff*2, s.maxBackoff)
+	}
+}
+
+// failures records the wikis that failed, for the summary printed at exit.
+// The errors themselves are logged when they happen, so the summary just
+// counts them. It's safe for concurrent use by workers.
+type failures struct {
+	mu     sync.Mutex
+	wikis  []string       // Source dirs of the wikis that failed, in the order they first failed
+	counts map[string]int // How often each wiki failed, by source dir
+}
+
+// record records that the wiki with source dir sourceDir failed.
+func (f *failures) record(sourceDir string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.counts == nil {
+		f.counts = make(map[string]int)
+	}
+	if f.counts[sourceDir] == 0 {
+		f.wikis = append(f.wikis, sourceDir)
+	}
+	f.counts[sourceDir]++
+}
+
+// printSummary prints the wikis that failed, if any.
+func (f *failures) printSummary(log util.Logger) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.wikis) == 0 {
+		return
+	}
+	log.Warning("%d wiki(s) failed:", len(f.wikis))
+	for _, sourceDir := range f.wikis {
+		log.Warning("wiki '%s' failed %d time(s)", sourceDir, f.counts[sourceDir])
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+// fakeClock is the clock of a supervisor in tests. Time only passes when a
+// generate function or backoff advances it, and backoffs end right away.
+type fakeClock struct {
+	now      time.Time
+	backoffs []time.Duration // The backoffs waited for, in order
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	c.backoffs = append(c.backoffs, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// testSupervisor returns a supervisor with a fake clock, and backoffs of 1s
+// to 8s.
+func testSupervisor(restart bool) (*supervisor, *fakeClock, *failures) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	failed := &failures{}
+	sup := newSupervisor(restart, failed, util.NewLogger(io.Discard, io.Discard, false, false))
+	sup.minBackoff = time.Second
+	sup.maxBackoff = 8 * time.Second
+	sup.now = func() time.Time { return clock.now }
+	sup.after = clock.after
+	return sup, clock, failed
+}
+
+// runs returns a generate function that returns each of errs in turn, after
+// taking the matching duration of runFor, and then nil.
+func runs(clock *fakeClock, runFor []time.Duration, errs ...error) (func(context.Context) error, *int) {
+	calls := 0
+	return func(ctx context.Context) error {
+		calls++
+		if calls > len(errs) {
+			return nil
+		}
+		if calls <= len(runFor) {
+			clock.now = clock.now.Add(runFor[calls-1])
+		}
+		return errs[calls-1]
+	}, &calls
+}
+
+func TestSuperviseFailFast(t *testing.T) {
+	sup, clock, failed := testSupervisor(false)
+	errFailed := errors.New("failed")
+	generate, calls := runs(clock, nil, errFailed, errFailed)
+	if err := sup.run(context.Background(), "wiki", nil, generate); err != errFailed {
+		t.Errorf("run returned %v, want %v", err, errFailed)
+	}
+	if *calls != 1 || len(clock.backoffs) != 0 {
+		t.Errorf("generated %d times with backoffs %v, want once with none", *calls, clock.backoffs)
+	}
+	if failed.counts["wiki"] != 1 {
+		t.Errorf("failures recorded = %d, want 1", failed.counts["wiki"])
+	}
+}
+
+func TestSuperviseKeepGoing(t *testing.T) {
+	sup, clock, failed := testSupervisor(true)
+	errFailed := errors.New("failed")
+
+	// The backoff doubles up to the cap while the wiki fails right away, and
+	// starts over once it fails after running for at least the cap.
+	runFor := []time.Duration{0, 0, 0, 0, 0, 8 * time.Second, 0}
+	generate, calls := runs(clock, runFor, errFailed, errFailed, errFailed, errFailed, errFailed, errFailed, errFailed)
+	if err := sup.run(context.Background(), "wiki", nil, generate); err != nil {
+		t.Errorf("run returned %v, want nil once the wiki succeeds", err)
+	}
+	want := []time.Duration{1, 2, 4, 8, 8, 1, 2}
+	for i := range want {
+		want[i] *= time.Second
+	}
+	if *calls != 8 || len(clock.backoffs) != len(want) {
+		t.Fatalf("generated %d times with backoffs %v, want 8 times with %v", *calls, clock.backoffs, want)
+	}
+	for i := range want {
+		if clock.backoffs[i] != want[i] {
+			t.Errorf("backoffs = %v, want %v", clock.backoffs, want)
+			break
+		}
+	}
+	if failed.counts["wiki"] != 7 {
+		t.Errorf("failures recorded = %d, want 7", failed.counts["wiki"])
+	}
+}
+
+func TestSuperviseCancelled(t *testing.T) {
+	sup, _, failed := testSupervisor(true)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// A worker waiting for the one it replaces stops without generating
+	// when it's cancelled.
+	after := make(chan struct{})
+	cancel()
+	called := false
+	err := sup.run(ctx, "wiki", after, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	if err != nil || called {
+		t.Errorf("run returned %v and generated = %v, want nil and false", err, called)
+	}
+
+	// An error from being cancelled isn't the wiki's.
+	err = sup.run(ctx, "wiki", nil, func(ctx context.Context) error {
+		return ctx.Err()
+	})
+	if err != nil || len(failed.wikis) != 0 {
+		t.Errorf("run returned %v with failures %v, want nil with none", err, failed.wikis)
+	}
+}
+
+func TestSuperviseWaitsForReplaced(t *testing.T) {
+	sup, _, _ := testSupervisor(false)
+	after := make(chan struct{})
+	generated := make(chan struct{})
+	go sup.run(context.Background(), "wiki", after, func(ctx context.Context) error {
+		close(generated)
+		return nil
+	})
+
+	select {
+	case <-generated:
+		t.Fatal("generated before the replaced worker stopped")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(after)
+	select {
+	case <-generated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not generated after the replaced worker stopped")
+	}
+}
+
+func TestFailuresSummary(t *testing.T) {
+	var failed failures
+	var out bytes.Buffer
+	log := util.NewLogger(&out, &out, false, false)
+
+	// Nothing is printed when no wiki failed.
+	failed.printSummary(log)
+	if out.Len() != 0 {
+		t.Errorf("summary with no failures = %q, want nothing", out.String())
+	}
+
+	// Wikis are listed in the order they first failed, with their counts.
+	failed.record("b")
+	failed.record("a")
+	failed.record("b")
+	failed.record("b")
+	failed.printSummary(log)
+	summary := out.String()
+	for _, want := range []string{"2 wiki(s) failed", "wiki 'b' failed 3 time(s)", "wiki 'a' failed 1 time(s)"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary %q doesn't contain %q", summary, want)
+		}
+	}
+	if strings.Index(summary, "'b'") > strings.Index(summary, "'a'") {
+		t.Errorf("summary %q doesn't list b first", summary)
+	}
+}
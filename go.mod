@@ -6,4 +6,4 @@ require github.com/yuin/goldmark v1.8.5
 
 require github.com/fsnotify/fsnotify v1.10.1
 
-require golang.org/x/sys v0.47.0 // indirect
+require golang.org/x/sys v0.47.0
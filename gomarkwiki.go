@@ -0,0 +1,446 @@
+// Package gomarkwiki generates static HTML websites from Markdown.
+//
+// It's the library behind the gomarkwiki command. A wiki is a source
+// directory that holds Markdown and other files in its content subdirectory,
+// along with optional substitution-strings.csv and ignore.txt files. Building
+// the wiki generates an HTML page in the destination directory for each
+// Markdown file, and copies the other files as is. See the gomarkwiki README
+// for the details of the source directory layout.
+//
+// The source can instead be any fs.FS, such as a zip archive or an embed.FS,
+// and the wiki can be generated to any Output, such as an in-memory map or a
+// zip or tar archive.
+//
+// A minimal build looks like:
+//
+//	result, err := gomarkwiki.Options{
+//		SourceDir: "/path/to/source",
+//		DestDir:   "/path/to/dest",
+//	}.Build(ctx)
+package gomarkwiki
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/yuin/goldmark"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+	"github.com/stalexan/gomarkwiki/internal/wiki"
+)
+
+// Logger prints status messages, warnings, and errors. Implementations must
+// be safe for concurrent use.
+type Logger interface {
+	// Message prints a message that's always shown.
+	Message(format string, args ...any)
+
+	// Verbose prints a status message that's shown in verbose mode.
+	Verbose(format string, args ...any)
+
+	// Debug prints a message that's shown in debug mode.
+	Debug(format string, args ...any)
+
+	// Warning prints a warning.
+	Warning(format string, args ...any)
+
+	// Error prints an error message, for err if it's not nil.
+	Error(err error, format string, args ...any)
+}
+
+// NewLogger returns a Logger that prints lines of text, with messages going
+// to out and warnings and errors going to errOut, the way the gomarkwiki
+// command does. Verbose messages are printed if verbose or debug is set, and
+// debug messages if debug is set.
+func NewLogger(out, errOut io.Writer, verbose, debug bool) Logger {
+	return util.NewLogger(out, errOut, verbose, debug)
+}
+
+// NewJSONLogger returns a Logger that writes messages, warnings, and errors
+// to w as JSON, one object per line, the way the gomarkwiki command does with
+// -log-format=json. Each object has the wiki and source dir it's about, and
+// the phase of generation, when known.
+func NewJSONLogger(w io.Writer, verbose, debug bool) Logger {
+	return util.NewJSONLogger(w, verbose, debug)
+}
+
+// NewSlogLogger returns a Logger that logs to handler. Verbose messages are
+// logged at a level between slog.LevelDebug and slog.LevelInfo.
+func NewSlogLogger(handler slog.Handler) Logger {
+	return util.NewSlogLogger(handler)
+}
+
+// Output is where a wiki is generated. Files are named by slash-separated
+// paths relative to the root of the output.
+type Output = wiki.Output
+
+// MemOutput is an Output that keeps files in memory.
+type MemOutput = wiki.MemOutput
+
+// ArchiveOutput is an Output that writes a zip or tar archive, with its files
+// sorted by name and given the same modification time, so that it's
+// reproducible. The time is taken from SOURCE_DATE_EPOCH if it's set.
+type ArchiveOutput = wiki.ArchiveOutput
+
+// NewDirOutput returns an Output that atomically writes files to dir,
+// printing messages with log, or discarding them if log is nil.
+func NewDirOutput(dir string, log Logger) Output {
+	if log == nil {
+		log = util.DiscardLogger()
+	}
+	return wiki.NewDirOutput(dir, log)
+}
+
+// NewMemOutput returns an empty MemOutput.
+func NewMemOutput() *MemOutput {
+	return wiki.NewMemOutput()
+}
+
+// NewZipOutput returns an ArchiveOutput that writes a zip archive to w.
+// Close must be called to write the archive.
+func NewZipOutput(w io.Writer) *ArchiveOutput {
+	return wiki.NewZipOutput(w)
+}
+
+// NewTarOutput returns an ArchiveOutput that writes a tar archive to w.
+// Close must be called to write the archive.
+func NewTarOutput(w io.Writer) *ArchiveOutput {
+	return wiki.NewTarOutput(w)
+}
+
+// NewArchiveFileOutput returns an ArchiveOutput that writes the archive at
+// path, which must be as for IsArchivePath. The archive is replaced at the
+// end of each build with one that holds just the files of that build. It's
+// what's used when DestDir is an archive.
+func NewArchiveFileOutput(path string) (*ArchiveOutput, error) {
+	return wiki.NewArchiveFileOutput(path)
+}
+
+// IsArchivePath returns whether path names an archive: a file ending with
+// .zip, .tar, .tar.gz, or .tgz.
+func IsArchivePath(path string) bool {
+	return wiki.IsArchivePath(path)
+}
+
+// Options configures a build of a wiki.
+type Options struct {
+	SourceDir string // Wiki source directory, which holds the content directory
+	DestDir   string // Directory where the wiki is generated, or an archive as for IsArchivePath
+
+	// Source, when not nil, is read instead of SourceDir. It's laid out like a
+	// source directory, with the content directory at its root.
+	Source fs.FS
+
+	// Output, when not nil, is where the wiki is generated instead of DestDir.
+	Output Output
+
+	Regen bool // Regenerate all files regardless of timestamps
+	Clean bool // Delete files in DestDir that have no corresponding source file
+	Watch bool // Keep running, regenerating files as they change, until the context is done; needs SourceDir and a DestDir that isn't an archive
+
+	// Layout is where pages go in DestDir: "flat", the default, for
+	// Foo/Bar.html, or "pretty" for Foo/Bar/index.html, with relative links
+	// rewritten to match.
+	Layout string
+
+	// LinkStatic is how static files are put in DestDir: "copy", the
+	// default, or "reflink" to clone them where the filesystem supports it.
+	// Files that can't be cloned, such as those on another filesystem, are
+	// copied.
+	LinkStatic string
+
+	// Gzip makes a gzip compressed sibling, named with .gz added, be written
+	// next to each HTML, CSS, JavaScript, SVG, and JSON file that's at least
+	// GzipMinSize bytes, for web servers that serve precompressed files.
+	// GzipLevel is the compression level, from 1 to 9, with 9 if it's zero.
+	Gzip        bool
+	GzipLevel   int
+	GzipMinSize int64
+
+	// Fingerprint adds a hash of their contents to the names of the style
+	// sheets, and of CSS, JavaScript, image, and font files, as in
+	// style.3f9a1c2b.css, so that they can be cached for good. Links to them
+	// in the header templates and in Markdown are rewritten to match. Each
+	// file is also written under its original name, for links in raw HTML and
+	// CSS, which aren't rewritten. Custom header templates link to files with
+	// {{.Asset "style.css"}}.
+	Fingerprint bool
+
+	// ImageWidths are the widths, in pixels, of the downscaled variants
+	// written for each PNG, JPEG, and GIF image that's wider and that a page
+	// shows, named like photo-480w.png. Images in Markdown get a srcset
+	// listing the variants, along with width, height, and loading="lazy"
+	// attributes.
+	ImageWidths []int
+
+	// Minify removes comments and collapses whitespace in generated pages
+	// and in the style sheets written to DestDir, leaving the content of pre,
+	// textarea, and code elements as is.
+	Minify bool
+
+	// Chapters lists the pages of an ExportEPUB, by their paths relative to
+	// the exported directory and with or without their extensions, in the
+	// order they go in the book. Pages that aren't listed follow them.
+	Chapters []string
+
+	// DryRun makes the build go through every step, including Clean, without
+	// writing or deleting anything, so that the Result lists what would have
+	// been done. Hooks aren't run, and it can't be combined with Watch.
+	DryRun bool
+
+	// PollInterval, when non-zero, makes Watch poll for changes at this
+	// interval instead of using file system notifications.
+	PollInterval time.Duration
+
+	// HeaderTemplate and GitHubHeaderTemplate, when not nil, replace the
+	// templates that generate the start of each HTML page, for pages with
+	// default and GitHub styles. Templates are given .Title, the page title;
+	// .Version, the Version below; .RootRelPath, the relative path from the
+	// page to the root of DestDir, such as "../"; and .Asset, which gives the
+	// link to a file in DestDir by its unfingerprinted name, as in
+	// {{.Asset "style.css"}}.
+	HeaderTemplate       *template.Template
+	GitHubHeaderTemplate *template.Template
+
+	// Extensions are goldmark extensions to use in addition to the standard
+	// ones, which include GitHub Flavored Markdown.
+	Extensions []goldmark.Extender
+
+	// EnvAllowlist names the environment variables that {{env:NAME}}
+	// placeholders may expand to.
+	EnvAllowlist []string
+
+	// SubstituteInCode enables substitutions inside fenced code blocks and
+	// inline code.
+	SubstituteInCode bool
+
+	// UseGitignore makes the build honor .gitignore files in the content
+	// directory, and ignore .git directories.
+	UseGitignore bool
+
+	// CacheDir, when not empty, is a directory where what each page depends
+	// on is saved between builds, so that pages that haven't changed aren't
+	// read again. It's only used with SourceDir and DestDir, without Source
+	// or Output.
+	CacheDir string
+
+	// HookTimeout is how long each command in the hooks.csv of SourceDir may
+	// run before it's killed, or 5 minutes if it's zero. Hooks are only run
+	// when Source and Output are both nil.
+	HookTimeout time.Duration
+
+	// PluginTimeout is how long each command in the plugins.csv of SourceDir
+	// may take to transform a page before it's killed, or 30 seconds if it's
+	// zero. Like hooks, plugins are only run when Source and Output are both
+	// nil.
+	PluginTimeout time.Duration
+
+	// Version is written to the generator meta tag of each page.
+	Version string
+
+	// Logger receives status messages, warnings, and errors. When nil,
+	// they're discarded; errors are still reported in the Result.
+	Logger Logger
+
+	// OnBuild, when not nil, is called after each build with its result. In
+	// watch mode that includes each rebuild after a change.
+	OnBuild func(result *Result, err error)
+}
+
+// Result describes what a build did. Paths are slash-separated and relative
+// to DestDir.
+type Result struct {
+	Generated []string    // Pages generated from Markdown
+	Copied    []string    // Static files copied from the content directory
+	Skipped   []string    // Pages and static files left alone because they were up to date
+	Deleted   []string    // Files deleted from DestDir by Clean
+	Errors    []FileError // Errors processing individual source files
+
+	// DryRun is whether nothing was written or deleted, in which case the
+	// lists above are of what would have been. Created lists the files that
+	// would have been written that don't exist yet.
+	DryRun  bool
+	Created []string
+
+	Collisions []Collision   // Source files skipped because another claimed their dest path
+	Duration   time.Duration // How long the build took
+}
+
+// Collision is a source file that was skipped because it would have generated
+// the same file as another. Source paths are relative to the content
+// directory.
+type Collision struct {
+	Path      string // Dest path
+	Source    string // Source file skipped
+	ClaimedBy string // Source file that generated Path
+}
+
+// FileError is an error that occurred while processing a single file.
+type FileError struct {
+	Path string // Full path of the source file
+	Err  error
+}
+
+// Error returns the error message, including the path.
+func (e FileError) Error() string {
+	return wiki.FileError{Path: e.Path, Err: e.Err}.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e FileError) Unwrap() error {
+	return e.Err
+}
+
+// newResult converts the result of a build from the wiki package.
+func newResult(buildResult *wiki.BuildResult) *Result {
+	result := &Result{
+		Generated: buildResult.Generated,
+		Copied:    buildResult.Copied,
+		Skipped:   buildResult.Skipped,
+		Deleted:   buildResult.Deleted,
+		DryRun:    buildResult.DryRun,
+		Created:   buildResult.Created,
+		Duration:  buildResult.Duration,
+	}
+	for _, collision := range buildResult.Collisions {
+		result.Collisions = append(result.Collisions, Collision(collision))
+	}
+	for _, fileErr := range buildResult.Errors {
+		result.Errors = append(result.Errors, FileError(fileErr))
+	}
+	return result
+}
+
+// Build builds the wiki, and returns what was done. A build that fails for
+// some files but not others is a success, with the failures listed in
+// Result.Errors. In watch mode Build runs until ctx is done, and returns the
+// result of the last build along with ctx's error.
+func (opts Options) Build(ctx context.Context) (*Result, error) {
+	log := opts.Logger
+	if log == nil {
+		log = util.DiscardLogger()
+	}
+
+	theWiki, err := opts.newWiki(log)
+	if err != nil {
+		return nil, err
+	}
+	opts.configure(theWiki)
+
+	var last *Result
+	theWiki.OnBuild = func(buildResult *wiki.BuildResult, buildErr error) {
+		last = newResult(buildResult)
+		if opts.OnBuild != nil {
+			opts.OnBuild(last, buildErr)
+		}
+	}
+
+	err = theWiki.Generate(ctx, opts.Regen, opts.Clean, opts.Watch, opts.Version)
+	return last, err
+}
+
+// ExportHTML renders the page, or all the pages under the directory, at path
+// into a single HTML document written to w, such as to email a page or a
+// section to people without access to the wiki. Path is slash-separated and
+// relative to the content directory, with "" or "." for the whole wiki, and
+// pages can be named without their extension. The document needs no other
+// files: style sheets are inlined, images are embedded as data URIs, and
+// links between the exported pages become links within the document.
+// DestDir, Output, and the options that only apply to files in DestDir are
+// ignored.
+func (opts Options) ExportHTML(ctx context.Context, path string, w io.Writer) error {
+	theWiki, err := opts.newExportWiki()
+	if err != nil {
+		return err
+	}
+	return theWiki.ExportHTML(ctx, path, w, opts.Version)
+}
+
+// ExportEPUB builds an EPUB 3 book from the page, or all the pages under the
+// directory, at path, given as for ExportHTML, and writes it to w, such as to
+// read runbooks on an e-reader. Each page is a chapter, ordered as listed in
+// Chapters, then by the weight in their front matter, and then
+// alphabetically. The navigation document lists the chapters and their
+// headings, images are packaged in the book, and links between the exported
+// pages point at their chapters.
+func (opts Options) ExportEPUB(ctx context.Context, path string, w io.Writer) error {
+	theWiki, err := opts.newExportWiki()
+	if err != nil {
+		return err
+	}
+	return theWiki.ExportEPUB(ctx, path, w, opts.Version)
+}
+
+// newExportWiki constructs the wiki to export, which is read from Source or
+// SourceDir.
+func (opts Options) newExportWiki() (*wiki.Wiki, error) {
+	log := opts.Logger
+	if log == nil {
+		log = util.DiscardLogger()
+	}
+
+	var theWiki *wiki.Wiki
+	var err error
+	if opts.Source != nil {
+		theWiki, err = wiki.NewWikiFS(opts.Source, wiki.NewMemOutput(), log)
+	} else {
+		theWiki, err = wiki.NewSourceWiki(opts.SourceDir, log)
+	}
+	if err != nil {
+		return nil, err
+	}
+	opts.configure(theWiki)
+	return theWiki, nil
+}
+
+// configure sets the options of theWiki from opts.
+func (opts Options) configure(theWiki *wiki.Wiki) {
+	theWiki.PollInterval = opts.PollInterval
+	theWiki.HeaderTemplate = opts.HeaderTemplate
+	theWiki.GitHubHeaderTemplate = opts.GitHubHeaderTemplate
+	theWiki.Extensions = opts.Extensions
+	theWiki.EnvAllowlist = opts.EnvAllowlist
+	theWiki.SubstituteInCode = opts.SubstituteInCode
+	theWiki.UseGitignore = opts.UseGitignore
+	theWiki.CacheDir = opts.CacheDir
+	theWiki.HookTimeout = opts.HookTimeout
+	theWiki.PluginTimeout = opts.PluginTimeout
+	theWiki.DryRun = opts.DryRun
+	theWiki.Layout = opts.Layout
+	theWiki.LinkStatic = opts.LinkStatic
+	theWiki.Fingerprint = opts.Fingerprint
+	theWiki.ImageWidths = opts.ImageWidths
+	theWiki.Minify = opts.Minify
+	theWiki.Gzip = opts.Gzip
+	theWiki.GzipLevel = opts.GzipLevel
+	theWiki.GzipMinSize = opts.GzipMinSize
+	theWiki.Chapters = opts.Chapters
+}
+
+// newWiki constructs the wiki to build, printing messages with log.
+func (opts Options) newWiki(log Logger) (*wiki.Wiki, error) {
+	if opts.Source == nil && opts.Output == nil {
+		return wiki.NewWiki(opts.SourceDir, opts.DestDir, log)
+	}
+
+	source := opts.Source
+	if source == nil {
+		source = os.DirFS(opts.SourceDir)
+	}
+	out := opts.Output
+	if out == nil && wiki.IsArchivePath(opts.DestDir) {
+		archive, err := wiki.NewArchiveFileOutput(opts.DestDir)
+		if err != nil {
+			return nil, err
+		}
+		out = archive
+	} else if out == nil {
+		out = wiki.NewDirOutput(opts.DestDir, log)
+	}
+	return wiki.NewWikiFS(source, out, log)
+}
@@ -0,0 +1,147 @@
+package gomarkwiki
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// writeFiles writes files, given as paths relative to dir, to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for relPath, content := range files {
+		path := filepath.Join(dir, relPath)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := t.TempDir()
+	writeFiles(t, sourceDir, map[string]string{
+		"content/index.md":    "# Index\n\nNote[^1]\n\n[^1]: Footnote",
+		"content/image.png":   "png",
+		"content/Sub/Page.md": "# Page",
+	})
+	writeFiles(t, destDir, map[string]string{"stale.html": "stale"})
+
+	var out bytes.Buffer
+	header := template.Must(template.New("header").Parse(
+		"<html><head><title>{{.Title}}</title></head><body data-root=\"{{.RootRelPath}}\">\n"))
+	var builds int
+	opts := Options{
+		SourceDir:      sourceDir,
+		DestDir:        destDir,
+		Clean:          true,
+		HeaderTemplate: header,
+		Extensions:     []goldmark.Extender{extension.Footnote},
+		Logger:         NewLogger(&out, &out, true, false),
+		Version:        "test",
+		OnBuild:        func(*Result, error) { builds++ },
+	}
+
+	result, err := opts.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if builds != 1 {
+		t.Errorf("OnBuild called %d times, want 1", builds)
+	}
+
+	slices.Sort(result.Generated)
+	if want := []string{"Sub/Page.html", "index.html"}; !slices.Equal(result.Generated, want) {
+		t.Errorf("Generated = %v, want %v", result.Generated, want)
+	}
+	if !slices.Contains(result.Copied, "image.png") {
+		t.Errorf("Copied = %v, want image.png", result.Copied)
+	}
+	if !slices.Equal(result.Deleted, []string{"stale.html"}) {
+		t.Errorf("Deleted = %v, want [stale.html]", result.Deleted)
+	}
+	if len(result.Errors) != 0 {
+		t.Errorf("Errors = %v, want none", result.Errors)
+	}
+	if out.Len() == 0 {
+		t.Errorf("verbose logger received no messages")
+	}
+
+	page, err := os.ReadFile(filepath.Join(destDir, "Sub", "Page.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), `<title>Page</title></head><body data-root="../">`) {
+		t.Errorf("Sub/Page.html does not use the custom header template:\n%s", page)
+	}
+	index, err := os.ReadFile(filepath.Join(destDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(index), "footnote") {
+		t.Errorf("index.html was not converted with the footnote extension:\n%s", index)
+	}
+
+	// A second build skips what's up to date.
+	result, err = opts.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(result.Generated) != 0 || len(result.Skipped) == 0 {
+		t.Errorf("second build generated %v and skipped %v", result.Generated, result.Skipped)
+	}
+}
+
+func TestBuildInvalidSourceDir(t *testing.T) {
+	_, err := Options{SourceDir: filepath.Join(t.TempDir(), "missing"), DestDir: t.TempDir()}.Build(context.Background())
+	if err == nil {
+		t.Errorf("Build succeeded with a missing source dir")
+	}
+}
+
+func TestBuildFromFS(t *testing.T) {
+	source := fstest.MapFS{
+		"content/index.md": {Data: []byte("# Index")},
+		"content/Sub/a.md": {Data: []byte("# A")},
+	}
+	out := NewMemOutput()
+	result, err := Options{Source: source, Output: out}.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(result.Generated) != 2 {
+		t.Errorf("Generated = %v, want 2 pages", result.Generated)
+	}
+	if data, err := out.ReadFile("Sub/a.html"); err != nil || !strings.Contains(string(data), "<title>a</title>") {
+		t.Errorf("ReadFile(Sub/a.html) = %q, %v", data, err)
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	sourceDir := t.TempDir()
+	writeFiles(t, sourceDir, map[string]string{
+		"content/index.md":      "# Index",
+		"content/Runbooks/a.md": "# A\n\nSee [b](b.md).",
+		"content/Runbooks/b.md": "# B",
+	})
+	var doc bytes.Buffer
+	if err := (Options{SourceDir: sourceDir, Version: "v1"}).ExportHTML(context.Background(), "Runbooks", &doc); err != nil {
+		t.Fatalf("ExportHTML failed: %v", err)
+	}
+	html := doc.String()
+	if !strings.Contains(html, `<a href="#page-runbooks-b">b</a>`) || strings.Contains(html, "<h1 id=\"page-index--index\">") {
+		t.Errorf("unexpected export:\n%s", html)
+	}
+}
@@ -0,0 +1,200 @@
+// Package util implements utility routines for printing messages, warnings, and errors.
+package util
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// Logger prints status messages, warnings, and errors.
+type Logger interface {
+	// Message prints a message that's always shown.
+	Message(format string, args ...any)
+
+	// Verbose prints a status message that's shown in verbose mode.
+	Verbose(format string, args ...any)
+
+	// Debug prints a message that's shown in debug mode.
+	Debug(format string, args ...any)
+
+	// Warning prints a warning.
+	Warning(format string, args ...any)
+
+	// Error prints an error message, for err if it's not nil.
+	Error(err error, format string, args ...any)
+}
+
+// LevelVerbose is the level of verbose messages, between slog.LevelDebug and
+// slog.LevelInfo. Messages are logged at slog.LevelInfo, and debug messages,
+// warnings, and errors at the slog levels of the same names.
+const LevelVerbose = slog.Level(-2)
+
+// ErrorKey is the key of the attribute that holds the error of an error
+// message.
+const ErrorKey = "error"
+
+// slogLogger is a Logger that logs with log/slog.
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a Logger that logs to handler. Which messages are
+// logged is up to handler.
+func NewSlogLogger(handler slog.Handler) Logger {
+	return slogLogger{slog.New(handler)}
+}
+
+// NewLogger returns a Logger that prints messages to out, and warnings and
+// errors to errOut, as lines of text. Verbose messages are printed if verbose
+// or debug is set, and debug messages if debug is set.
+func NewLogger(out, errOut io.Writer, verbose, debug bool) Logger {
+	return NewSlogLogger(&textHandler{
+		shared: &textOutput{out: out, errOut: errOut},
+		level:  minLevel(verbose, debug),
+	})
+}
+
+// NewJSONLogger returns a Logger that writes messages, warnings, and errors
+// to w as JSON, one object per line. Verbose messages are written if verbose
+// or debug is set, and debug messages if debug is set.
+func NewJSONLogger(w io.Writer, verbose, debug bool) Logger {
+	return NewSlogLogger(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: minLevel(verbose, debug),
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.LevelKey && len(groups) == 0 && attr.Value.Any() == LevelVerbose {
+				attr.Value = slog.StringValue("VERBOSE")
+			}
+			return attr
+		},
+	}))
+}
+
+// DefaultLogger returns a Logger that prints messages to stdout, and warnings
+// and errors to stderr, without verbose or debug messages.
+func DefaultLogger() Logger {
+	return NewLogger(os.Stdout, os.Stderr, false, false)
+}
+
+// DiscardLogger returns a Logger that prints nothing.
+func DiscardLogger() Logger {
+	return NewSlogLogger(slog.DiscardHandler)
+}
+
+// With returns a Logger that adds the attributes args, given as for
+// slog.Logger.With, to each message logged with log. Loggers that don't log
+// with log/slog are returned as is.
+func With(log Logger, args ...any) Logger {
+	if l, ok := log.(slogLogger); ok {
+		return slogLogger{l.logger.With(args...)}
+	}
+	return log
+}
+
+// minLevel returns the lowest level logged given the verbose and debug settings.
+func minLevel(verbose, debug bool) slog.Level {
+	switch {
+	case debug:
+		return slog.LevelDebug
+	case verbose:
+		return LevelVerbose
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func (l slogLogger) log(level slog.Level, format string, args []any, attrs ...any) {
+	ctx := context.Background()
+	if l.logger.Enabled(ctx, level) {
+		l.logger.Log(ctx, level, formatMessage(format, args), attrs...)
+	}
+}
+
+// Message logs a message at slog.LevelInfo.
+func (l slogLogger) Message(format string, args ...any) {
+	l.log(slog.LevelInfo, format, args)
+}
+
+// Verbose logs a message at LevelVerbose.
+func (l slogLogger) Verbose(format string, args ...any) {
+	l.log(LevelVerbose, format, args)
+}
+
+// Debug logs a message at slog.LevelDebug.
+func (l slogLogger) Debug(format string, args ...any) {
+	l.log(slog.LevelDebug, format, args)
+}
+
+// Warning logs a message at slog.LevelWarn.
+func (l slogLogger) Warning(format string, args ...any) {
+	l.log(slog.LevelWarn, format, args)
+}
+
+// Error logs a message at slog.LevelError, with err as the attribute ErrorKey.
+func (l slogLogger) Error(err error, format string, args ...any) {
+	if err != nil {
+		l.log(slog.LevelError, format, args, ErrorKey, err)
+	} else {
+		l.log(slog.LevelError, format, args)
+	}
+}
+
+// textHandler is a slog.Handler that prints lines of text, with messages
+// going to out and warnings and errors going to errOut. Attributes other than
+// errors aren't printed.
+type textHandler struct {
+	shared *textOutput
+	level  slog.Level
+}
+
+// textOutput is where a textHandler and those derived from it print.
+type textOutput struct {
+	mu     sync.Mutex // Serializes writes, since wikis are generated concurrently
+	out    io.Writer
+	errOut io.Writer
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, record slog.Record) error {
+	var line string
+	w := h.shared.out
+	switch {
+	case record.Level >= slog.LevelError:
+		var err error
+		record.Attrs(func(attr slog.Attr) bool {
+			if attr.Key == ErrorKey {
+				err, _ = attr.Value.Any().(error)
+				return false
+			}
+			return true
+		})
+		line = formatErrorMessage(err, "%s", []any{record.Message})
+		if record.Message == "" {
+			line = formatErrorMessage(err, "", nil)
+		}
+		w = h.shared.errOut
+	case record.Level >= slog.LevelWarn:
+		line = "WARNING: " + record.Message
+		w = h.shared.errOut
+	default:
+		line = record.Message
+	}
+
+	h.shared.mu.Lock()
+	defer h.shared.mu.Unlock()
+	_, err := io.WriteString(w, line+"\n")
+	return err
+}
+
+func (h *textHandler) WithAttrs(_ []slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *textHandler) WithGroup(_ string) slog.Handler {
+	return h
+}
@@ -0,0 +1,55 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTextLogger(t *testing.T) {
+	var out, errOut bytes.Buffer
+	log := With(NewLogger(&out, &errOut, true, false), "wiki", "example")
+	log.Message("Hello %s", "world")
+	log.Verbose("Verbose")
+	log.Debug("Debug")
+	log.Warning("Careful with '%s'", "x")
+	log.Error(errors.New("boom"), "failed to process '%s'", "y")
+	log.Error(errors.New("bare"), "")
+
+	if got, want := out.String(), "Hello world\nVerbose\n"; got != want {
+		t.Errorf("out = %q, want %q", got, want)
+	}
+	want := "WARNING: Careful with 'x'\nERROR: failed to process 'y': boom\nERROR: bare\n"
+	if got := errOut.String(); got != want {
+		t.Errorf("errOut = %q, want %q", got, want)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := With(NewJSONLogger(&buf, true, false), "wiki", "example")
+	log.Verbose("Generating '%s'", "a.html")
+	log.Debug("Debug")
+	log.Error(errors.New("boom"), "failed")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	var records []map[string]any
+	for _, line := range lines {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if records[0]["level"] != "VERBOSE" || records[0]["msg"] != "Generating 'a.html'" || records[0]["wiki"] != "example" {
+		t.Errorf("verbose record = %v", records[0])
+	}
+	if records[1]["level"] != "ERROR" || records[1]["msg"] != "failed" || records[1][ErrorKey] != "boom" {
+		t.Errorf("error record = %v", records[1])
+	}
+}
@@ -6,12 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 )
 
-var Verbose bool
-var Debug bool
-
 // Resource limits for CSV file processing
 const (
 	// MaxCSVFileSize is the maximum size in bytes for a CSV file that can be processed
@@ -28,30 +26,6 @@ func formatMessage(format string, args []any) string {
 	return fmt.Sprintf(format, args...)
 }
 
-// PrintMessage prints a message to stdout.
-func PrintMessage(format string, args ...any) {
-	fmt.Println(formatMessage(format, args))
-}
-
-// PrintVerbose prints a message to stdout if either the Verbose or Debug flag is set.
-func PrintVerbose(format string, args ...any) {
-	if Verbose || Debug {
-		fmt.Println(formatMessage(format, args))
-	}
-}
-
-// PrintDebug prints a message to stdout if the Debug flag is set.
-func PrintDebug(format string, args ...any) {
-	if Debug {
-		fmt.Println(formatMessage(format, args))
-	}
-}
-
-// PrintWarning prints a warning to stderr.
-func PrintWarning(format string, args ...any) {
-	fmt.Fprintf(os.Stderr, "WARNING: %s\n", formatMessage(format, args))
-}
-
 func formatErrorMessage(err error, format string, args []any) string {
 	message := "ERROR"
 	if format != "" {
@@ -63,11 +37,6 @@ func formatErrorMessage(err error, format string, args []any) string {
 	return message
 }
 
-// PrintError prints a error message to stderr.
-func PrintError(err error, format string, args ...any) {
-	fmt.Fprintf(os.Stderr, "%s\n", formatErrorMessage(err, format, args))
-}
-
 // PrintFatalError prints a error message to stderr and exits.
 func PrintFatalError(err error, format string, args ...any) {
 	fmt.Fprintf(os.Stderr, "%s\n", formatErrorMessage(err, format, args))
@@ -75,7 +44,7 @@ func PrintFatalError(err error, format string, args ...any) {
 }
 
 // LoadStringPairs loads string pairs from a CSV file, where each line is two
-// comma separated strings.
+// comma separated strings. Returns nil if there's no such file.
 func LoadStringPairs(csvPath string) ([][2]string, error) {
 	// Open file.
 	var file *os.File
@@ -90,6 +59,27 @@ func LoadStringPairs(csvPath string) ([][2]string, error) {
 	}
 	defer file.Close()
 
+	return readStringPairs(file, csvPath)
+}
+
+// LoadStringPairsFS is like LoadStringPairs, but loads the CSV file name from
+// fsys. csvPath is the path of the file shown in error messages.
+func LoadStringPairsFS(fsys fs.FS, name, csvPath string) ([][2]string, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("unable to open '%s': %v", csvPath, err)
+		}
+		// There is no string pairs file.
+		return nil, nil
+	}
+	defer file.Close()
+
+	return readStringPairs(file, csvPath)
+}
+
+// readStringPairs reads string pairs from file, the CSV file at csvPath.
+func readStringPairs(file fs.File, csvPath string) ([][2]string, error) {
 	// Check file size before reading to prevent resource exhaustion
 	fileInfo, err := file.Stat()
 	if err != nil {
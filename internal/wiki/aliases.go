@@ -0,0 +1,147 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// redirectTemplateText is the text of the HTML template for the redirect
+// stubs generated for page aliases.
+const redirectTemplateText = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8" />
+<meta name=generator content="gomarkwiki {{.Version}}">
+<title>{{.Title}}</title>
+<link rel="canonical" href="{{.URL}}" />
+<meta http-equiv="refresh" content="0; url={{.URL}}" />
+<meta name="robots" content="noindex" />
+</head>
+<body>
+<p>This page has moved to <a href="{{.URL}}">{{.Title}}</a>.</p>
+</body>
+</html>
+`
+
+var redirectTemplate = template.Must(template.New("redirect").Parse(redirectTemplateText))
+
+// redirectData holds the values used to instantiate a redirect stub from
+// redirectTemplate. URL is the page redirected to, relative to the stub.
+type redirectData struct {
+	Title   string
+	Version string
+	URL     string
+}
+
+// pageAliases are the aliases of a page, found while walking the content dir.
+type pageAliases struct {
+	pagePath       string   // Full path of the markdown file
+	relContentPath string   // Path of the markdown file relative to the content dir
+	relDestPath    string   // Dest path of the page
+	aliases        []string // Old paths of the page, as given in its front matter
+}
+
+// aliasDestPath returns the dest path of the redirect stub for alias, an old
+// path of a page relative to the content dir. An alias can name the old
+// markdown file, as in Old/Name.md, its page, as in Old/Name.html, or a
+// directory, as in Old/Name/, and can be written with or without a leading
+// /. Aliases without an extension are placed according to the layout, like
+// pages.
+func (wiki Wiki) aliasDestPath(alias string) (string, error) {
+	cleaned := path.Clean("/" + strings.TrimSpace(alias))
+	if cleaned == "/" || strings.Contains(alias, "\\") {
+		return "", fmt.Errorf("invalid alias '%s'", alias)
+	}
+	relPath := filepath.FromSlash(strings.TrimPrefix(cleaned, "/"))
+
+	switch {
+	case strings.HasSuffix(alias, "/"):
+		return filepath.Join(relPath, "index.html"), nil
+	case strings.ToLower(filepath.Ext(relPath)) == ".html":
+		return relPath, nil
+	case isPathMarkdown(relPath):
+		return wiki.pageDestPath(removeFileExtension(relPath)), nil
+	case filepath.Ext(relPath) == "":
+		return wiki.pageDestPath(relPath), nil
+	}
+	return "", fmt.Errorf("alias '%s' isn't a page, markdown file, or directory", alias)
+}
+
+// generateRedirects generates the redirect stubs for the aliases of pages.
+// claimed and dirs are the dest paths claimed by source files, and their
+// directories, as used by findDestCollision; an alias that collides with one
+// is skipped with a warning. The dest paths of the stubs are added to
+// relDestPaths, so that they're kept by clean.
+func (wiki Wiki) generateRedirects(ctx context.Context, pages []pageAliases, claimed, dirs map[string]string, relDestPaths map[string]bool, regen bool, version string) []error {
+	var errs []error
+	for _, page := range pages {
+		for _, alias := range page.aliases {
+			if ctx.Err() != nil {
+				return append(errs, ctx.Err())
+			}
+
+			stubPath, err := wiki.aliasDestPath(alias)
+			if err != nil {
+				wiki.log.Warning("Skipping alias of '%s': %v", page.relContentPath, err)
+				continue
+			}
+			if existingSource, collision := findDestCollision(stubPath, claimed, dirs); collision {
+				wiki.log.Warning("Skipping alias '%s' of '%s': would generate '%s' which is already claimed by '%s'", alias, page.relContentPath, stubPath, existingSource)
+				wiki.result.addCollision(stubPath, page.relContentPath, existingSource)
+				continue
+			}
+			claimDestPath(stubPath, page.relContentPath, claimed, dirs)
+			relDestPaths[stubPath] = true
+
+			if err := wiki.generateRedirect(ctx, page, stubPath, regen, version); err != nil {
+				wiki.log.Error(err, "failed to generate redirect for alias '%s' of '%s'", alias, page.pagePath)
+				wiki.result.addError(page.pagePath, err)
+				errs = append(errs, fmt.Errorf("failed to generate redirect for alias '%s' of '%s': %w", alias, page.pagePath, err))
+			}
+		}
+	}
+	return errs
+}
+
+// generateRedirect writes the redirect stub at stubPath to the page. The stub
+// is skipped if it's newer than the page, since the page would have been
+// regenerated if its aliases had changed.
+func (wiki Wiki) generateRedirect(ctx context.Context, page pageAliases, stubPath string, regen bool, version string) error {
+	stubName := filepath.ToSlash(stubPath)
+	if !regen {
+		if pageInfo, err := wiki.out.Stat(filepath.ToSlash(page.relDestPath)); err == nil && sourceIsOlder(pageInfo, wiki.out, stubName) {
+			wiki.result.addSkipped(stubPath)
+			return nil
+		}
+	}
+	wiki.log.Verbose("Generating redirect '%s'", wiki.destPath(stubPath))
+
+	// Link to the page relative to the stub, linking to the directory of
+	// index pages.
+	target := filepath.ToSlash(page.relDestPath)
+	isDir := false
+	if wiki.Layout == LayoutPretty && path.Base(target) == "index.html" {
+		target = path.Dir(target)
+		isDir = true
+	}
+	url := relativeURLPath(path.Dir(stubName), target)
+	if isDir && !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+
+	var stub strings.Builder
+	title := filepath.Base(removeFileExtension(page.relContentPath))
+	if err := redirectTemplate.Execute(&stub, redirectData{Title: title, Version: version, URL: url}); err != nil {
+		return fmt.Errorf("failed to create redirect '%s': %v", wiki.destPath(stubPath), err)
+	}
+	if err := wiki.out.WriteFile(ctx, stubName, strings.NewReader(stub.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write redirect '%s': %v", wiki.destPath(stubPath), err)
+	}
+	wiki.result.addGenerated(stubPath)
+	return nil
+}
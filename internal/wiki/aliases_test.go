@@ -0,0 +1,149 @@
+package wiki
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAliasDestPath(t *testing.T) {
+	flat := Wiki{}
+	pretty := Wiki{Layout: LayoutPretty}
+	tests := []struct {
+		alias      string
+		wantFlat   string
+		wantPretty string
+	}{
+		{"Old", "Old.html", "Old/index.html"},
+		{"/Dir/Old.md", "Dir/Old.html", "Dir/Old/index.html"},
+		{"Dir/Old.html", "Dir/Old.html", "Dir/Old.html"},
+		{"Dir/Old/", "Dir/Old/index.html", "Dir/Old/index.html"},
+		{"../../Escape", "Escape.html", "Escape/index.html"},
+		{"/", "", ""},
+		{"image.png", "", ""},
+	}
+	for _, tt := range tests {
+		for _, c := range []struct {
+			wiki Wiki
+			want string
+		}{{flat, tt.wantFlat}, {pretty, tt.wantPretty}} {
+			got, err := c.wiki.aliasDestPath(tt.alias)
+			if c.want == "" {
+				if err == nil {
+					t.Errorf("aliasDestPath(%q) with layout %q = %q, want error", tt.alias, c.wiki.Layout, got)
+				}
+				continue
+			}
+			if err != nil || got != filepath.FromSlash(c.want) {
+				t.Errorf("aliasDestPath(%q) with layout %q = %q, %v; want %q", tt.alias, c.wiki.Layout, got, err, c.want)
+			}
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"Old.md": "# Old",
+	})
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// Rename Old.md to Topics/New.md, keeping its modification time as mv does.
+	past := time.Now().Add(-time.Hour)
+	if err := os.Remove(filepath.Join(theWiki.ContentDir, "Old.md")); err != nil {
+		t.Fatal(err)
+	}
+	newPath := filepath.Join(theWiki.ContentDir, "Topics", "New.md")
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newPath, []byte("---\naliases: [/Old, Archive/Older.html, Taken]\n---\n# New"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(newPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(theWiki.ContentDir, "Topics", "Taken.md"), []byte("# Taken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var result *BuildResult
+	theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// The stubs replace the old page, and survive clean.
+	for relPath, wantURL := range map[string]string{
+		"Old.html":           "Topics/New.html",
+		"Archive/Older.html": "../Topics/New.html",
+	} {
+		stub, err := os.ReadFile(filepath.Join(destDir, relPath))
+		if err != nil {
+			t.Fatalf("redirect %s not generated: %v", relPath, err)
+		}
+		for _, want := range []string{
+			`<meta http-equiv="refresh" content="0; url=` + wantURL + `" />`,
+			`<link rel="canonical" href="` + wantURL + `" />`,
+		} {
+			if !strings.Contains(string(stub), want) {
+				t.Errorf("%s doesn't contain %q:\n%s", relPath, want, stub)
+			}
+		}
+	}
+
+	// The alias Taken, relative to the content dir, doesn't collide with
+	// Topics/Taken.md, but an alias that did would be skipped.
+	if _, err := os.Stat(filepath.Join(destDir, "Taken.html")); err != nil {
+		t.Errorf("redirect Taken.html not generated: %v", err)
+	}
+	if len(result.Collisions) != 0 {
+		t.Errorf("unexpected collisions: %+v", result.Collisions)
+	}
+
+	// Up to date stubs are skipped.
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(result.Generated) != 0 {
+		t.Errorf("Generated = %v, want nothing", result.Generated)
+	}
+}
+
+func TestAliasCollision(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"A.md":     "---\naliases:\n  - B\n  - Shared\n---\n# A",
+		"B.md":     "# B",
+		"C.md":     "---\naliases: [Shared]\n---\n# C",
+		"index.md": "# Index",
+	})
+	theWiki.Layout = LayoutPretty
+	var result *BuildResult
+	theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// B is a page, and Shared was claimed by A first.
+	if len(result.Collisions) != 2 {
+		t.Errorf("Collisions = %+v, want 2", result.Collisions)
+	}
+	stub, err := os.ReadFile(filepath.Join(destDir, "Shared", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `url=../A/"`; !strings.Contains(string(stub), want) {
+		t.Errorf("Shared/index.html doesn't redirect to A:\n%s", stub)
+	}
+	page, err := os.ReadFile(filepath.Join(destDir, "B", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(page), "refresh") {
+		t.Errorf("alias replaced page B:\n%s", page)
+	}
+}
@@ -3,9 +3,9 @@ package wiki
 
 import (
 	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
 	"path/filepath"
 	"strings"
 	"unicode"
@@ -24,6 +24,12 @@ const (
 	// MaxRecursionDepth is the maximum directory recursion depth allowed
 	MaxRecursionDepth = 1000 // 1000 levels
 
+	// MaxImagePixels is the maximum number of pixels, width times height, in an image that downscaled variants are generated for
+	MaxImagePixels = 50 * 1000 * 1000 // 50 megapixels
+
+	// MaxIncludeDepth is the maximum nesting depth of include directives
+	MaxIncludeDepth = 100 // 100 levels
+
 	// MaxProcessingErrors is the maximum number of processing errors to collect before stopping
 	// This prevents OOM when encountering large numbers of permission errors or other failures
 	MaxProcessingErrors = 1000
@@ -78,17 +84,34 @@ func (wiki *Wiki) loadSubstitutionStrings() error {
 	// Always set the path so the watcher can detect file creation/modification
 	wiki.subsPath = filepath.Clean(candidateSubsPath)
 
+	subStrings, err := wiki.readSubstitutionFile(candidateSubsPath)
+	if err != nil {
+		return err
+	}
+	wiki.subStrings = subStrings
+
+	return nil
+}
+
+// readSubstitutionFile reads and validates the substitution strings in the
+// CSV source file at subsPath, and returns the value of each placeholder by
+// name. Returns nil if there's no such file.
+func (wiki Wiki) readSubstitutionFile(subsPath string) (map[string]string, error) {
+	name, err := wiki.sourceName(subsPath)
+	if err != nil {
+		return nil, err
+	}
 	var pairs [][2]string
-	var err error
-	if pairs, err = util.LoadStringPairs(candidateSubsPath); err != nil {
-		return fmt.Errorf("failed to load substitution strings from '%s': %v", candidateSubsPath, err)
+	if pairs, err = util.LoadStringPairsFS(wiki.source, name, subsPath); err != nil {
+		return nil, fmt.Errorf("failed to load substitution strings from '%s': %v", subsPath, err)
 	}
 	if len(pairs) == 0 {
 		// There's either no substitution strings file or the file is empty.
-		return nil
+		return nil, nil
 	}
 
 	// Save substitutions.
+	subStrings := make(map[string]string, len(pairs))
 	seenPlaceholders := make(map[string]int)
 	for i, pair := range pairs {
 		originalPlaceholder := pair[0]
@@ -96,52 +119,68 @@ func (wiki *Wiki) loadSubstitutionStrings() error {
 
 		// Validate placeholder
 		if len(originalPlaceholder) > 0 && len(placeholder) == 0 {
-			return fmt.Errorf("placeholder at line %d contains only whitespace", i+1)
+			return nil, fmt.Errorf("placeholder at line %d of '%s' contains only whitespace", i+1, subsPath)
 		}
 		if err := validatePlaceholder(placeholder); err != nil {
-			return fmt.Errorf("invalid placeholder at line %d: %v", i+1, err)
+			return nil, fmt.Errorf("invalid placeholder at line %d of '%s': %v", i+1, subsPath, err)
 		}
 
 		// Check for duplicates
 		if existingLine, exists := seenPlaceholders[placeholder]; exists {
-			return fmt.Errorf("duplicate placeholder %q found at line %d (first seen at line %d)", placeholder, i+1, existingLine)
+			return nil, fmt.Errorf("duplicate placeholder %q found at line %d of '%s' (first seen at line %d)", placeholder, i+1, subsPath, existingLine)
 		}
 		seenPlaceholders[placeholder] = i + 1
 
-		placeholder = fmt.Sprintf("{{%s}}", placeholder)
-		substitution := pair[1]
-		wiki.subStrings = append(wiki.subStrings, [2]string{placeholder, substitution})
+		subStrings[placeholder] = pair[1]
 	}
 
-	return nil
+	return subStrings, nil
 }
 
-// makeSubstitutions makes string substitutions in data.
-func (wiki Wiki) makeSubstitutions(data []byte) []byte {
-	for _, pair := range wiki.subStrings {
-		data = bytes.ReplaceAll(data, []byte(pair[0]), []byte(pair[1]))
-	}
-	return data
-}
-
-// loadIgnoreExpressions loads gitignore-style patterns that define which files to ignore.
+// loadIgnoreExpressions loads gitignore-style patterns that define which
+// files to ignore, from ignore.txt and from the per-directory configuration
+// files in the content dir.
 func (wiki *Wiki) loadIgnoreExpressions() error {
 	// Start with no ignore patterns.
 	wiki.ignoreMatcher = nil
 
-	// Open ignore file, if there is one.
+	// Read ignore file, if there is one.
 	const ignoreFileName = "ignore.txt"
 	ignorePath := filepath.Join(wiki.SourceDir, ignoreFileName)
 	wiki.ignorePath = filepath.Clean(ignorePath)
-	var file *os.File
-	var err error
-	if file, err = os.Open(ignorePath); err != nil {
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("unable to open '%s': %v", ignorePath, err)
-		} else {
-			// There is no ignore file.
-			return nil
+	lines, err := wiki.readIgnoreFile(ignorePath)
+	if err != nil {
+		return err
+	}
+
+	// Git's own directory is never content when .gitignore files are honored.
+	if wiki.UseGitignore {
+		lines = append([]string{".git/"}, lines...)
+	}
+	wiki.gitignoreLoaded = wiki.UseGitignore
+
+	// Create the ignore matcher
+	matcher, err := NewIgnoreMatcher(lines)
+	if err != nil {
+		return fmt.Errorf("error parsing ignore patterns in '%s': %v", ignorePath, err)
+	}
+	wiki.ignoreMatcher = matcher
+
+	// Which directories are ignored can change which per-directory files
+	// apply, so reload all of them.
+	return wiki.loadDirConfigs(".")
+}
+
+// readIgnoreFile reads the lines of the ignore source file at ignorePath.
+// Returns nil if there's no such file.
+func (wiki Wiki) readIgnoreFile(ignorePath string) ([]string, error) {
+	file, err := wiki.openSource(ignorePath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("unable to open '%s': %v", ignorePath, err)
 		}
+		// There is no ignore file.
+		return nil, nil
 	}
 	defer file.Close()
 
@@ -152,23 +191,20 @@ func (wiki *Wiki) loadIgnoreExpressions() error {
 		lines = append(lines, scanner.Text())
 	}
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading '%s': %v", ignorePath, err)
-	}
-
-	// Create the ignore matcher
-	matcher, err := NewIgnoreMatcher(lines)
-	if err != nil {
-		return fmt.Errorf("error parsing ignore patterns in '%s': %v", ignorePath, err)
+		return nil, fmt.Errorf("error reading '%s': %v", ignorePath, err)
 	}
-	wiki.ignoreMatcher = matcher
-
-	return nil
+	return lines, nil
 }
 
 // ignoreFile returns true if the file at path should be ignored.
 // path should be the full path to the file/directory.
 // isDir indicates whether path is a directory.
 func (wiki Wiki) ignoreFile(path string, isDir bool) bool {
+	// Per-directory configuration files are never part of the generated wiki.
+	if !isDir && wiki.isConfigFile(path) {
+		return true
+	}
+
 	if wiki.ignoreMatcher == nil {
 		return false
 	}
@@ -0,0 +1,394 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// placeholderRegexp matches substitution string placeholders such as {{SITE}}.
+var placeholderRegexp = regexp.MustCompile(`\{\{([\p{L}\p{N}_-]+)\}\}`)
+
+// pageDependencies records what the HTML generated for a page depends on,
+// besides the page itself.
+type pageDependencies struct {
+	modTime      time.Time // Modification time of the page when its dependencies were recorded
+	size         int64     // Size of the page when its dependencies were recorded
+	includes     []string  // Full paths of the files the page includes, sorted
+	placeholders []string  // Names of the placeholders the page uses, sorted
+	aliases      []string  // Old paths of the page, from its front matter
+	images       []string  // Slash-separated content paths of the resizable images the page shows, sorted
+	hasImages    bool      // Whether images was found, which is only done when ImageWidths is set
+}
+
+// dependencyGraph tracks what each page depends on, so that a change to an
+// included file or a substitution string regenerates just the pages affected.
+// The dependencies of pages can be saved to a file between runs, so that
+// pages that haven't changed aren't read again to find them.
+type dependencyGraph struct {
+	mu       sync.Mutex
+	pages    map[string]pageDependencies // Keyed by full path of the page
+	known    map[string]bool             // Full paths of all pages found by the last walk
+	savePath string                      // File the dependencies were loaded from, and are saved to
+	changed  bool                        // Whether pages has changed since it was loaded or saved
+}
+
+// newDependencyGraph constructs an empty dependencyGraph.
+func newDependencyGraph() *dependencyGraph {
+	return &dependencyGraph{
+		pages: make(map[string]pageDependencies),
+		known: make(map[string]bool),
+	}
+}
+
+// record saves the dependencies found for the page at pagePath, with the
+// modification time and size from pageInfo.
+func (graph *dependencyGraph) record(pagePath string, pageInfo os.FileInfo, deps pageDependencies) pageDependencies {
+	deps.modTime = pageInfo.ModTime()
+	deps.size = pageInfo.Size()
+	if graph == nil {
+		return deps
+	}
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+	graph.pages[pagePath] = deps
+	graph.changed = true
+	return deps
+}
+
+// lookup returns the recorded dependencies for the page at pagePath, if
+// they're still valid for pageInfo.
+func (graph *dependencyGraph) lookup(pagePath string, pageInfo os.FileInfo) (pageDependencies, bool) {
+	if graph == nil {
+		return pageDependencies{}, false
+	}
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+	deps, ok := graph.pages[pagePath]
+	if !ok || !deps.modTime.Equal(pageInfo.ModTime()) || deps.size != pageInfo.Size() {
+		return pageDependencies{}, false
+	}
+	return deps, true
+}
+
+// setPages records the pages found by a walk, and drops the dependencies of
+// any other pages, so that deleted pages don't keep their included files
+// under watch.
+func (graph *dependencyGraph) setPages(pagePaths map[string]bool) {
+	if graph == nil {
+		return
+	}
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+	for pagePath := range graph.pages {
+		if !pagePaths[pagePath] {
+			delete(graph.pages, pagePath)
+			graph.changed = true
+		}
+	}
+	graph.known = pagePaths
+}
+
+// dependenciesFileVersion is the version of the format dependencies are
+// saved in. Saved dependencies of another version are ignored.
+const dependenciesFileVersion = 1
+
+// dependenciesFile is the format dependencies are saved in, as JSON.
+type dependenciesFile struct {
+	Version int                  `json:"version"`
+	Pages   map[string]savedPage `json:"pages"` // Keyed by full path of the page
+}
+
+// savedPage is the saved form of pageDependencies.
+type savedPage struct {
+	ModTime      time.Time `json:"modTime"`
+	Size         int64     `json:"size"`
+	Includes     []string  `json:"includes,omitempty"`
+	Placeholders []string  `json:"placeholders,omitempty"`
+	Aliases      []string  `json:"aliases,omitempty"`
+	Images       []string  `json:"images,omitempty"`
+	HasImages    bool      `json:"hasImages,omitempty"`
+}
+
+// dependenciesPath returns the path of the file in cacheDir that the
+// dependencies of the pages of the wiki generated from sourceDir to destDir
+// are saved in.
+func dependenciesPath(cacheDir, sourceDir, destDir string) string {
+	sum := sha256.Sum256([]byte(sourceDir + "\x00" + destDir))
+	return filepath.Join(cacheDir, "deps-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// load reads the dependencies saved at path, unless they were already
+// loaded from there. Dependencies already recorded are kept. A missing file,
+// or one saved in another version of its format, is taken to be empty.
+func (graph *dependencyGraph) load(path string) error {
+	if graph == nil {
+		return nil
+	}
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+	if graph.savePath == path {
+		return nil
+	}
+	graph.savePath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read page dependencies '%s': %v", path, err)
+	}
+	var file dependenciesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse page dependencies '%s': %v", path, err)
+	}
+	if file.Version != dependenciesFileVersion {
+		return nil
+	}
+	for pagePath, page := range file.Pages {
+		if _, ok := graph.pages[pagePath]; !ok {
+			graph.pages[pagePath] = pageDependencies{
+				modTime:      page.ModTime,
+				size:         page.Size,
+				includes:     page.Includes,
+				placeholders: page.Placeholders,
+				aliases:      page.Aliases,
+				images:       page.Images,
+				hasImages:    page.HasImages,
+			}
+		}
+	}
+	return nil
+}
+
+// save writes the dependencies to the file they were loaded from, if they've
+// changed since. The file is replaced atomically, so that it's never left
+// partially written.
+func (graph *dependencyGraph) save() (err error) {
+	if graph == nil {
+		return nil
+	}
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+	if graph.savePath == "" || !graph.changed {
+		return nil
+	}
+
+	file := dependenciesFile{Version: dependenciesFileVersion, Pages: make(map[string]savedPage, len(graph.pages))}
+	for pagePath, deps := range graph.pages {
+		file.Pages[pagePath] = savedPage{
+			ModTime:      deps.modTime,
+			Size:         deps.size,
+			Includes:     deps.includes,
+			Placeholders: deps.placeholders,
+			Aliases:      deps.aliases,
+			Images:       deps.images,
+			HasImages:    deps.hasImages,
+		}
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("failed to encode page dependencies: %v", err)
+	}
+
+	dir := filepath.Dir(graph.savePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory '%s': %v", dir, err)
+	}
+	tempFile, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file in '%s': %v", dir, err)
+	}
+	tempPath := tempFile.Name()
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
+	if _, err := tempFile.Write(data); err != nil {
+		return fmt.Errorf("failed to write temp file '%s': %v", tempPath, err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file '%s': %v", tempPath, err)
+	}
+	if err := os.Rename(tempPath, graph.savePath); err != nil {
+		return fmt.Errorf("failed to rename temp file '%s' to '%s': %v", tempPath, graph.savePath, err)
+	}
+	graph.changed = false
+	return nil
+}
+
+// knownPages returns the full paths of all pages found by the last walk, sorted.
+func (graph *dependencyGraph) knownPages() []string {
+	if graph == nil {
+		return nil
+	}
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+	pagePaths := make([]string, 0, len(graph.known))
+	for pagePath := range graph.known {
+		pagePaths = append(pagePaths, pagePath)
+	}
+	sort.Strings(pagePaths)
+	return pagePaths
+}
+
+// includedFiles returns the full paths of every file included by any page, sorted.
+func (graph *dependencyGraph) includedFiles() []string {
+	if graph == nil {
+		return nil
+	}
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+	seen := make(map[string]bool)
+	var files []string
+	for _, deps := range graph.pages {
+		for _, file := range deps.includes {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// findPlaceholders returns the names of the placeholders found in data, sorted.
+func findPlaceholders(data []byte) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, match := range placeholderRegexp.FindAllSubmatch(data, -1) {
+		name := string(match[1])
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pageDependenciesFor returns the dependencies of the page at pagePath,
+// reading the page to find them if they haven't been recorded since it last
+// changed, or if the images it shows are needed and weren't found.
+func (wiki Wiki) pageDependenciesFor(pagePath string, pageInfo os.FileInfo) (pageDependencies, error) {
+	if deps, ok := wiki.deps.lookup(pagePath, pageInfo); ok && (deps.hasImages || wiki.images == nil) {
+		return deps, nil
+	}
+
+	data, err := wiki.readSource(pagePath)
+	if err != nil {
+		return pageDependencies{}, err
+	}
+	fm, data := wiki.splitPageFrontMatter(pagePath, data)
+	_, data = checkForStyleDirective(data)
+	data, includes, err := wiki.expandIncludes(data, pagePath)
+	if err != nil {
+		return pageDependencies{}, err
+	}
+	relPath, err := filepath.Rel(wiki.ContentDir, pagePath)
+	if err != nil {
+		return pageDependencies{}, err
+	}
+	return wiki.deps.record(pagePath, pageInfo, wiki.findDependencies(relPath, fm, data, includes)), nil
+}
+
+// findDependencies returns the dependencies of the page with content path
+// relContentPath, given its front matter fm, its markdown data with includes
+// expanded, and the files it includes.
+func (wiki Wiki) findDependencies(relContentPath string, fm frontMatter, data []byte, includes []string) pageDependencies {
+	deps := pageDependencies{
+		includes:     includes,
+		placeholders: findPlaceholders(data),
+		aliases:      fm.List("aliases"),
+	}
+	if wiki.images != nil {
+		deps.images = wiki.findImages(relContentPath, data)
+		deps.hasImages = true
+	}
+	return deps
+}
+
+// includesChangedSince returns true if the page at pagePath includes a file
+// that's newer than the dest file outName, or that can no longer be read.
+// pageInfo is the current info for the page, used to decide whether its
+// recorded dependencies are still valid.
+func (wiki Wiki) includesChangedSince(pagePath string, pageInfo os.FileInfo, outName string) bool {
+	deps, err := wiki.pageDependenciesFor(pagePath, pageInfo)
+	if err != nil {
+		// Any error is reported when the page is regenerated.
+		return true
+	}
+	if len(deps.includes) == 0 {
+		return false
+	}
+
+	outInfo, err := wiki.out.Stat(outName)
+	if err != nil {
+		return true
+	}
+	for _, file := range deps.includes {
+		info, err := wiki.statSource(file)
+		if err != nil || !info.ModTime().Before(outInfo.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+// changedPlaceholders returns the names of the placeholders that were added,
+// changed, or removed between the substitution strings before and after.
+func changedPlaceholders(before, after map[string]string) map[string]bool {
+	changed := make(map[string]bool)
+	for name, value := range before {
+		if afterValue, ok := after[name]; !ok || afterValue != value {
+			changed[name] = true
+		}
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			changed[name] = true
+		}
+	}
+	return changed
+}
+
+// pagesUsingPlaceholders returns the full paths of the pages that use any of
+// the placeholders named in names. Pages that can't be read are included, so
+// that the error is reported when they're regenerated.
+func (wiki Wiki) pagesUsingPlaceholders(names map[string]bool) map[string]bool {
+	pagePaths := make(map[string]bool)
+	if len(names) == 0 {
+		return pagePaths
+	}
+	for _, pagePath := range wiki.deps.knownPages() {
+		info, err := wiki.statSource(pagePath)
+		if err != nil {
+			continue // The page was deleted; the next walk will notice.
+		}
+		deps, err := wiki.pageDependenciesFor(pagePath, info)
+		if err != nil {
+			pagePaths[pagePath] = true
+			continue
+		}
+		for _, name := range deps.placeholders {
+			if names[name] {
+				pagePaths[pagePath] = true
+				break
+			}
+		}
+	}
+	return pagePaths
+}
@@ -0,0 +1,166 @@
+package wiki
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindPlaceholders(t *testing.T) {
+	got := findPlaceholders([]byte("{{B}} and {{A}} and {{B}}, {{not valid}}, {{include: x.md}}, {{ü-1}}"))
+	want := []string{"A", "B", "ü-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findPlaceholders() = %v, want %v", got, want)
+	}
+}
+
+func TestChangedPlaceholders(t *testing.T) {
+	before := map[string]string{"SAME": "1", "CHANGED": "old", "REMOVED": "x"}
+	after := map[string]string{"SAME": "1", "CHANGED": "new", "ADDED": "y"}
+
+	got := changedPlaceholders(before, after)
+	want := map[string]bool{"CHANGED": true, "REMOVED": true, "ADDED": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changedPlaceholders() = %v, want %v", got, want)
+	}
+
+	if got := changedPlaceholders(before, before); len(got) != 0 {
+		t.Errorf("changedPlaceholders() with no change = %v, want none", got)
+	}
+}
+
+// TestSubsChangeRegeneratesOnlyAffectedPages verifies that in watch mode a
+// change to one substitution string regenerates just the pages that use it.
+func TestSubsChangeRegeneratesOnlyAffectedPages(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":                    "# Index\n{{SITE}}",
+		"Year.md":                     "# Year\n{{YEAR}}",
+		"Shared/Footer.md":            "Footer {{YEAR}}",
+		"Footer.md":                   "# Footer\n{{include: Shared/Footer.md}}",
+		"Plain.md":                    "# Plain",
+		"../ignore.txt":               "Shared/\n",
+		"../substitution-strings.csv": "SITE,example.com\nYEAR,2024\n",
+	})
+	theWiki.PollInterval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- theWiki.Generate(ctx, true, false, true, "test")
+	}()
+	defer func() {
+		cancel()
+		<-errChan
+	}()
+
+	yearHTML := filepath.Join(destDir, "Year.html")
+	footerHTML := filepath.Join(destDir, "Footer.html")
+	if _, ok := waitForFileContent(footerHTML, "2024", 2*time.Second); !ok {
+		t.Fatalf("initial generation did not produce %s", footerHTML)
+	}
+	mtimes := map[string]time.Time{}
+	for _, name := range []string{"index.html", "Plain.html"} {
+		info, err := os.Stat(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mtimes[name] = info.ModTime()
+	}
+
+	// Change YEAR only.
+	subsPath := filepath.Join(theWiki.SourceDir, "substitution-strings.csv")
+	if err := os.WriteFile(subsPath, []byte("SITE,example.com\nYEAR,2025\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := waitForFileContent(yearHTML, "2025", 5*time.Second); !ok {
+		t.Fatalf("Year.html was not regenerated; got:\n%s", got)
+	}
+	if got, ok := waitForFileContent(footerHTML, "2025", 5*time.Second); !ok {
+		t.Fatalf("Footer.html, which uses YEAR through an include, was not regenerated; got:\n%s", got)
+	}
+
+	// Pages that don't use YEAR are left alone.
+	for name, mtime := range mtimes {
+		info, err := os.Stat(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("%s should not have been regenerated", name)
+		}
+	}
+	index, err := os.ReadFile(filepath.Join(destDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(index), "example.com") {
+		t.Errorf("index.html lost its substitution:\n%s", index)
+	}
+}
+
+// readCountingFS is an fs.FS that counts how many times each file is read.
+type readCountingFS struct {
+	fs.FS
+	reads map[string]int
+}
+
+// ReadFile reads the file name, counting the read.
+func (f readCountingFS) ReadFile(name string) ([]byte, error) {
+	f.reads[name]++
+	return fs.ReadFile(f.FS, name)
+}
+
+// TestSavedDependencies verifies that a later run uses the dependencies
+// saved by an earlier one, instead of reading pages that haven't changed.
+func TestSavedDependencies(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"Page.md":       "# Page\n{{include: Part.md}}",
+		"Part.md":       "part v1",
+		"../ignore.txt": "Part.md\n",
+	})
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"Page.md", "Part.md"} {
+		if err := os.Chtimes(filepath.Join(theWiki.ContentDir, name), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cacheDir := t.TempDir()
+	generate := func() (*BuildResult, map[string]int) {
+		t.Helper()
+		laterRun, err := NewWiki(theWiki.SourceDir, destDir, nil)
+		if err != nil {
+			t.Fatalf("NewWiki failed: %v", err)
+		}
+		laterRun.CacheDir = cacheDir
+		reads := map[string]int{}
+		laterRun.source = readCountingFS{laterRun.source, reads}
+		var result *BuildResult
+		laterRun.OnBuild = func(r *BuildResult, err error) { result = r }
+		if err := laterRun.Generate(context.Background(), false, false, false, "test"); err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		return result, reads
+	}
+	generate()
+
+	// Nothing changed, so the page isn't read.
+	result, reads := generate()
+	if len(result.Generated) != 0 || reads["content/Page.md"] != 0 {
+		t.Errorf("Generated = %v, Page.md read %d times, want nothing", result.Generated, reads["content/Page.md"])
+	}
+
+	// A change to the included file still regenerates the page.
+	if err := os.WriteFile(filepath.Join(theWiki.ContentDir, "Part.md"), []byte("part v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	generate()
+	if page, err := os.ReadFile(filepath.Join(destDir, "Page.html")); err != nil || !strings.Contains(string(page), "part v2") {
+		t.Errorf("Page.html wasn't regenerated with the new Part.md: %v\n%s", err, page)
+	}
+}
@@ -0,0 +1,270 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+)
+
+// Per-directory configuration files. These can be placed in any directory
+// of the content dir, and apply to that directory and its subdirectories.
+// Files in deeper directories take precedence over those in shallower ones,
+// and over substitution-strings.csv and ignore.txt.
+const (
+	dirSubsFileName   = ".gomarkwiki-subs.csv" // Substitution strings, in the format of substitution-strings.csv
+	dirIgnoreFileName = ".gomarkwiki-ignore"   // Ignore patterns, in the format of ignore.txt
+)
+
+// gitignoreFileName is the name of Git's ignore files, which are loaded
+// along with the per-directory ignore files when Wiki.UseGitignore is set.
+const gitignoreFileName = ".gitignore"
+
+// isDirConfigFile returns true if path names a per-directory configuration file.
+func isDirConfigFile(path string) bool {
+	name := filepath.Base(path)
+	return name == dirSubsFileName || name == dirIgnoreFileName
+}
+
+// isConfigFile returns true if path names a per-directory configuration
+// file, or a .gitignore file when those are being honored.
+func (wiki Wiki) isConfigFile(path string) bool {
+	return isDirConfigFile(path) || (wiki.UseGitignore && filepath.Base(path) == gitignoreFileName)
+}
+
+// relScopeDir returns the slash-separated path of dir relative to the
+// content dir, with "." for the content dir itself.
+func (wiki Wiki) relScopeDir(dir string) (string, error) {
+	relDir, err := filepath.Rel(wiki.ContentDir, dir)
+	if err != nil {
+		return "", err
+	}
+	return cleanScopeDir(relDir), nil
+}
+
+// loadDirConfigs loads the per-directory configuration files found in relDir,
+// given relative to the content dir, and its subdirectories. Any loaded
+// before from there are replaced. Directories that are ignored are skipped,
+// along with their configuration files.
+func (wiki *Wiki) loadDirConfigs(relDir string) error {
+	relDir = cleanScopeDir(relDir)
+
+	// Start with the configuration from outside relDir.
+	matcher := wiki.ignoreMatcher.WithoutScopesUnder(relDir)
+	dirSubStrings := make(map[string]map[string]string)
+	for dir, subStrings := range wiki.dirSubStrings {
+		if !scopeContains(relDir, dir) {
+			dirSubStrings[dir] = subStrings
+		}
+	}
+
+	// Walk relDir, loading configuration files as they're found. Directories
+	// are visited before their contents, so that a directory's ignore file
+	// applies to its subdirectories as they're walked.
+	root := filepath.Join(wiki.ContentDir, filepath.FromSlash(relDir))
+	err := wiki.walkSource(root, func(dirPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if dirPath == root && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll // The directory was removed.
+			}
+			wiki.log.Warning("Failed to access '%s' while loading directory configuration: %v", dirPath, err)
+			return nil
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+
+		// Check recursion depth
+		currentDepth := sourceDepth(root, dirPath)
+		if currentDepth > MaxRecursionDepth {
+			return fmt.Errorf("directory recursion depth exceeded at '%s' (depth %d, max %d)", dirPath, currentDepth, MaxRecursionDepth)
+		}
+
+		dir, err := wiki.relScopeDir(dirPath)
+		if err != nil {
+			return err
+		}
+		if dir != "." && matcher.Matches(dir, true) {
+			return fs.SkipDir
+		}
+
+		// Load ignore patterns, from .gitignore first if it's being honored,
+		// so that .gomarkwiki-ignore can override it.
+		ignoreFileNames := []string{dirIgnoreFileName}
+		if wiki.UseGitignore {
+			ignoreFileNames = []string{gitignoreFileName, dirIgnoreFileName}
+		}
+		for _, ignoreFileName := range ignoreFileNames {
+			ignorePath := filepath.Join(dirPath, ignoreFileName)
+			lines, err := wiki.readIgnoreFile(ignorePath)
+			if err != nil {
+				return err
+			}
+			if err := matcher.AddScope(dir, lines); err != nil {
+				return fmt.Errorf("error parsing ignore patterns in '%s': %v", ignorePath, err)
+			}
+		}
+
+		// Load substitution strings.
+		subStrings, err := wiki.readSubstitutionFile(filepath.Join(dirPath, dirSubsFileName))
+		if err != nil {
+			return err
+		}
+		if len(subStrings) > 0 {
+			dirSubStrings[dir] = subStrings
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	wiki.ignoreMatcher = matcher
+	wiki.dirSubStrings = dirSubStrings
+	return nil
+}
+
+// scopedSubStrings returns the substitution strings that apply to the page at
+// relPath, slash-separated and relative to the content dir, from the deepest
+// directory to the shallowest, ending with those from
+// substitution-strings.csv.
+func (wiki Wiki) scopedSubStrings(relPath string) []map[string]string {
+	var scopes []map[string]string
+	if relPath != "" {
+		for dir := path.Dir(relPath); ; dir = path.Dir(dir) {
+			if subStrings, ok := wiki.dirSubStrings[dir]; ok {
+				scopes = append(scopes, subStrings)
+			}
+			if dir == "." {
+				break
+			}
+		}
+	}
+	return append(scopes, wiki.subStrings)
+}
+
+// changedDirConfigs returns the directories, relative to the content dir, of
+// the per-directory configuration files that were added, changed, or removed
+// between the snapshots before and after.
+func (wiki Wiki) changedDirConfigs(before, after []fileSnapshot) []string {
+	configs := func(snapshot []fileSnapshot) map[string]fileSnapshot {
+		result := make(map[string]fileSnapshot)
+		for _, file := range snapshot {
+			if !file.isDir && wiki.isConfigFile(file.name) {
+				result[file.name] = file
+			}
+		}
+		return result
+	}
+	beforeConfigs, afterConfigs := configs(before), configs(after)
+
+	changed := make(map[string]bool)
+	check := func(name string) {
+		if beforeConfigs[name] != afterConfigs[name] {
+			if dir, err := wiki.relScopeDir(filepath.Dir(name)); err == nil {
+				changed[dir] = true
+			}
+		}
+	}
+	for name := range beforeConfigs {
+		check(name)
+	}
+	for name := range afterConfigs {
+		check(name)
+	}
+
+	// Keep just the outermost directories, since reloading a directory
+	// reloads its subdirectories too.
+	var dirs []string
+	for dir := range changed {
+		covered := false
+		for other := range changed {
+			if other != dir && scopeContains(other, dir) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// changedScopedPlaceholders returns the names of the placeholders that were
+// added, changed, or removed in each directory's substitution strings
+// between before and after, keyed by directory.
+func changedScopedPlaceholders(before, after map[string]map[string]string) map[string]map[string]bool {
+	changed := make(map[string]map[string]bool)
+	for dir := range before {
+		if names := changedPlaceholders(before[dir], after[dir]); len(names) > 0 {
+			changed[dir] = names
+		}
+	}
+	for dir := range after {
+		if _, seen := before[dir]; seen {
+			continue
+		}
+		if names := changedPlaceholders(nil, after[dir]); len(names) > 0 {
+			changed[dir] = names
+		}
+	}
+	return changed
+}
+
+// reloadDirConfigs reloads the per-directory configuration files in each of
+// dirs and their subdirectories. It returns the full paths of the pages that
+// use placeholders that were added, changed, or removed, and whether the
+// ignore patterns changed.
+func (wiki *Wiki) reloadDirConfigs(dirs []string) (map[string]bool, bool, error) {
+	previousMatcher := wiki.ignoreMatcher
+	previousDirSubStrings := wiki.dirSubStrings
+	for _, dir := range dirs {
+		wiki.log.Verbose("Reloading directory configuration in '%s'", filepath.Join(wiki.ContentDir, filepath.FromSlash(dir)))
+		if err := wiki.loadDirConfigs(dir); err != nil {
+			wiki.ignoreMatcher = previousMatcher
+			wiki.dirSubStrings = previousDirSubStrings
+			return nil, false, err
+		}
+	}
+
+	// Find the pages affected by substitution changes, in the scope of
+	// each directory whose substitutions changed.
+	stalePages := make(map[string]bool)
+	for dir, names := range changedScopedPlaceholders(previousDirSubStrings, wiki.dirSubStrings) {
+		for pagePath := range wiki.pagesUsingPlaceholders(names) {
+			if relPath, err := filepath.Rel(wiki.ContentDir, pagePath); err == nil && scopeContains(dir, filepath.ToSlash(relPath)) {
+				stalePages[pagePath] = true
+			}
+		}
+	}
+
+	return stalePages, !ignoreMatchersEqual(previousMatcher, wiki.ignoreMatcher), nil
+}
+
+// ignoreMatchersEqual returns true if a and b have the same patterns.
+func ignoreMatchersEqual(a, b *IgnoreMatcher) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if !ignorePatternsEqual(a.patterns, b.patterns) || len(a.scopes) != len(b.scopes) {
+		return false
+	}
+	for i := range a.scopes {
+		if a.scopes[i].dir != b.scopes[i].dir || !ignorePatternsEqual(a.scopes[i].patterns, b.scopes[i].patterns) {
+			return false
+		}
+	}
+	return true
+}
+
+// ignorePatternsEqual returns true if a and b hold the same patterns in the same order.
+func ignorePatternsEqual(a, b []*IgnorePattern) bool {
+	return slices.EqualFunc(a, b, func(p1, p2 *IgnorePattern) bool {
+		return p1.original == p2.original
+	})
+}
@@ -0,0 +1,282 @@
+package wiki
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIgnoreMatcherScopes(t *testing.T) {
+	matcher, err := NewIgnoreMatcher([]string{"*.log", "/Top.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := matcher.AddScope("team/sub", []string{"keep.log"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := matcher.AddScope("team", []string{"!keep.log", "drafts/", "/Top.md"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"debug.log", false, true},
+		{"keep.log", false, true},
+		{"team/keep.log", false, false},       // Negated by team
+		{"team/other/keep.log", false, false}, // Negation applies to the whole subtree
+		{"team/sub/keep.log", false, true},    // Ignored again deeper down
+		{"team/debug.log", false, true},       // Root patterns still apply
+		{"team/drafts", true, true},           // Directory pattern relative to team
+		{"drafts", true, false},               // Team patterns don't apply outside team
+		{"team/Top.md", false, true},          // Anchored to team
+		{"team/other/Top.md", false, false},   // Anchored to team, so not deeper
+		{"teammate/keep.log", false, true},    // Not inside team
+		{"team", true, false},                 // A scope doesn't apply to its own directory
+		{filepath.Join("team", "keep.log"), false, false},
+	}
+	for _, tt := range tests {
+		if got := matcher.Matches(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Matches(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	// Removing the team scopes leaves the root patterns.
+	without := matcher.WithoutScopesUnder("team")
+	if !without.Matches("team/keep.log", false) || without.Matches("team/drafts", true) {
+		t.Errorf("WithoutScopesUnder(\"team\") kept team patterns")
+	}
+	if !matcher.Matches("team/drafts", true) {
+		t.Errorf("WithoutScopesUnder modified the original matcher")
+	}
+}
+
+// TestDirConfigsApplyToSubtree verifies that per-directory configuration
+// files apply to their subtree, and aren't copied to the dest dir.
+func TestDirConfigsApplyToSubtree(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":                     "# Index\n{{TEAM}} {{SITE}}",
+		"Team/Page.md":                 "# Page\n{{TEAM}} {{SITE}}",
+		"Team/Sub/Page.md":             "# Sub\n{{TEAM}} {{SITE}}",
+		"Team/Drafts/Draft.md":         "# Draft",
+		"Team/notes.tmp":               "tmp",
+		"Team/" + dirSubsFileName:      "TEAM,Team A\n",
+		"Team/Sub/" + dirSubsFileName:  "TEAM,Team A1\nSITE,sub.example.com\n",
+		"Team/" + dirIgnoreFileName:    "Drafts/\n*.tmp\n",
+		"Hidden/" + dirIgnoreFileName:  "*.md\n",
+		"Hidden/Page.md":               "# Hidden",
+		"../ignore.txt":                "Ignored/\n",
+		"Ignored/" + dirIgnoreFileName: "!*.md\n",
+		"Ignored/Page.md":              "# Ignored",
+		"../substitution-strings.csv":  "SITE,example.com\nTEAM,none\n",
+	})
+
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for relPath, want := range map[string]string{
+		"index.html":         "none example.com",
+		"Team/Page.html":     "Team A example.com",
+		"Team/Sub/Page.html": "Team A1 sub.example.com",
+	} {
+		got, err := os.ReadFile(filepath.Join(destDir, relPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(got), want) {
+			t.Errorf("%s does not contain %q:\n%s", relPath, want, got)
+		}
+	}
+
+	for _, relPath := range []string{
+		"Team/Drafts/Draft.html",
+		"Team/notes.tmp",
+		"Hidden/Page.html",
+		"Ignored/Page.html",
+		"Team/" + dirSubsFileName,
+		"Team/" + dirIgnoreFileName,
+	} {
+		if _, err := os.Stat(filepath.Join(destDir, relPath)); err == nil {
+			t.Errorf("%s should not have been generated", relPath)
+		}
+	}
+}
+
+// TestDirSubsChangeRegeneratesScope verifies that in watch mode a change to a
+// per-directory substitution file regenerates just the pages in its subtree
+// that use the placeholders that changed.
+func TestDirSubsChangeRegeneratesScope(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":                    "# Index\n{{TEAM}}",
+		"Team/Page.md":                "# Page\n{{TEAM}}",
+		"Team/Plain.md":               "# Plain",
+		"Team/" + dirSubsFileName:     "TEAM,Team A\n",
+		"../substitution-strings.csv": "TEAM,none\n",
+	})
+	theWiki.PollInterval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- theWiki.Generate(ctx, true, false, true, "test")
+	}()
+	defer func() {
+		cancel()
+		<-errChan
+	}()
+
+	pageHTML := filepath.Join(destDir, "Team", "Page.html")
+	if _, ok := waitForFileContent(pageHTML, "Team A", 2*time.Second); !ok {
+		t.Fatalf("initial generation did not produce %s", pageHTML)
+	}
+	mtimes := map[string]time.Time{}
+	for _, name := range []string{"index.html", "Team/Plain.html"} {
+		info, err := os.Stat(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mtimes[name] = info.ModTime()
+	}
+
+	subsPath := filepath.Join(theWiki.ContentDir, "Team", dirSubsFileName)
+	if err := os.WriteFile(subsPath, []byte("TEAM,Team B\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := waitForFileContent(pageHTML, "Team B", 5*time.Second); !ok {
+		t.Fatalf("Team/Page.html was not regenerated; got:\n%s", got)
+	}
+
+	for name, mtime := range mtimes {
+		info, err := os.Stat(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("%s should not have been regenerated", name)
+		}
+	}
+
+	// A new ignore file takes effect for its directory.
+	if err := os.WriteFile(filepath.Join(theWiki.ContentDir, "Team", dirIgnoreFileName), []byte("New.md\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(theWiki.ContentDir, "Team", "New.md"), []byte("# New"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(theWiki.ContentDir, "Other.md"), []byte("# Other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !waitForFile(filepath.Join(destDir, "Other.html"), 5*time.Second) {
+		t.Fatalf("Other.html was not generated")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "Team", "New.html")); err == nil {
+		t.Errorf("Team/New.html should have been ignored")
+	}
+}
+
+// TestGitignoreHonored verifies that .gitignore files are honored when
+// UseGitignore is set, by both the generator and the watcher's snapshots.
+func TestGitignoreHonored(t *testing.T) {
+	files := map[string]string{
+		"index.md":                 "# Index",
+		".git/config":              "[core]",
+		".gitignore":               "*.secret\nbuild/\n",
+		"build/out.md":             "# Build output",
+		"a.secret":                 "secret",
+		"Sub/keep.secret":          "kept",
+		"Sub/.gitignore":           "!keep.secret\nlocal.md\n",
+		"Sub/local.md":             "# Local",
+		"Sub/" + dirIgnoreFileName: "!local.md\n",
+	}
+
+	// Without UseGitignore, .gitignore files are ordinary content.
+	theWiki, destDir := includeTestWiki(t, files)
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, relPath := range []string{"a.secret", ".gitignore", ".git/config", "build/out.html"} {
+		if _, err := os.Stat(filepath.Join(destDir, relPath)); err != nil {
+			t.Errorf("%s should have been generated without UseGitignore: %v", relPath, err)
+		}
+	}
+
+	// With UseGitignore.
+	theWiki, destDir = includeTestWiki(t, files)
+	theWiki.UseGitignore = true
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, relPath := range []string{"index.html", "Sub/keep.secret", "Sub/local.html"} {
+		if _, err := os.Stat(filepath.Join(destDir, relPath)); err != nil {
+			t.Errorf("%s should have been generated: %v", relPath, err)
+		}
+	}
+	for _, relPath := range []string{"a.secret", ".gitignore", "Sub/.gitignore", ".git", "build"} {
+		if _, err := os.Stat(filepath.Join(destDir, relPath)); err == nil {
+			t.Errorf("%s should have been ignored", relPath)
+		}
+	}
+
+	// The watcher's snapshot leaves out the same files, but keeps the
+	// .gitignore files themselves so that changes to them are seen.
+	snapshot, err := takeFilesSnapshot(context.Background(), theWiki.log, theWiki.ContentDir, theWiki.ignoreMatcher, theWiki.UseGitignore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, file := range snapshot {
+		relPath, _ := filepath.Rel(theWiki.ContentDir, file.name)
+		names[filepath.ToSlash(relPath)] = true
+	}
+	for _, relPath := range []string{"index.md", "Sub/keep.secret", "Sub/local.md", ".gitignore", "Sub/.gitignore"} {
+		if !names[relPath] {
+			t.Errorf("snapshot is missing %s", relPath)
+		}
+	}
+	for _, relPath := range []string{"a.secret", ".git", ".git/config", "build", "build/out.md"} {
+		if names[relPath] {
+			t.Errorf("snapshot should not include %s", relPath)
+		}
+	}
+}
+
+// TestGitignoreNotHonoredSnapshot verifies that without UseGitignore a
+// .gitignore file is ordinary content, left out of the watcher's snapshots
+// when it's ignored.
+func TestGitignoreNotHonoredSnapshot(t *testing.T) {
+	theWiki, _ := includeTestWiki(t, map[string]string{
+		"index.md":               "# Index",
+		".gitignore":             "*.secret\n",
+		dirIgnoreFileName:        ".gitignore\n",
+		"Sub/.gitignore":         "*.secret\n",
+		"Sub/" + dirSubsFileName: "A,1\n",
+	})
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	snapshot, err := takeFilesSnapshot(context.Background(), theWiki.log, theWiki.ContentDir, theWiki.ignoreMatcher, theWiki.UseGitignore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, file := range snapshot {
+		relPath, _ := filepath.Rel(theWiki.ContentDir, file.name)
+		names[filepath.ToSlash(relPath)] = true
+	}
+	for _, relPath := range []string{".gitignore", "Sub/.gitignore"} {
+		if names[relPath] {
+			t.Errorf("snapshot should not include %s", relPath)
+		}
+	}
+	for _, relPath := range []string{"index.md", dirIgnoreFileName, "Sub/" + dirSubsFileName} {
+		if !names[relPath] {
+			t.Errorf("snapshot is missing %s", relPath)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+// dryRunCleaner is implemented by outputs that can tell what Clean would
+// delete without deleting it.
+type dryRunCleaner interface {
+	// dryRunClean returns the names of the files Clean would delete.
+	dryRunClean(ctx context.Context, keep func(name string) bool) ([]string, error)
+}
+
+// dryRunOutput wraps the Output of a wiki for a dry run. Files are read but
+// not written, and cleaning only works out what would be deleted.
+type dryRunOutput struct {
+	out    Output
+	result *BuildResult // Where files that would be created are recorded
+	log    util.Logger
+}
+
+// Stat returns info on the file name in the wrapped output.
+func (o *dryRunOutput) Stat(name string) (fs.FileInfo, error) {
+	return o.out.Stat(name)
+}
+
+// WriteFile reads r, and records name as created if it doesn't exist in the
+// wrapped output.
+func (o *dryRunOutput) WriteFile(ctx context.Context, name string, r io.Reader, mode fs.FileMode) error {
+	if _, err := readAll(ctx, r); err != nil {
+		return err
+	}
+	if _, err := o.out.Stat(name); errors.Is(err, fs.ErrNotExist) {
+		o.result.addCreated(name)
+	}
+	return nil
+}
+
+// removeFile records name as deleted if it exists in the wrapped output,
+// without deleting it.
+func (o *dryRunOutput) removeFile(name string) error {
+	if _, err := o.out.Stat(name); err == nil {
+		o.result.addDeleted(name)
+	}
+	return nil
+}
+
+// Clean returns the files that the wrapped output would delete. Nothing is
+// returned if the wrapped output can't tell.
+func (o *dryRunOutput) Clean(ctx context.Context, keep func(name string) bool) ([]string, error) {
+	cleaner, ok := o.out.(dryRunCleaner)
+	if !ok {
+		o.log.Warning("Output doesn't support dry runs, so files that would be deleted aren't listed")
+		return nil, nil
+	}
+	return cleaner.dryRunClean(ctx, keep)
+}
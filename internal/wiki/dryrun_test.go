@@ -0,0 +1,149 @@
+package wiki
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+func TestDryRun(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":   "# Index",
+		"Foo/Bar.md": "# Bar",
+	})
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// Change a page, add one, and leave a stale file in a directory of its own.
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(destDir, "index.html"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(theWiki.ContentDir, "New.md"), []byte("# New"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	staleDir := filepath.Join(destDir, "Old", "Deeper")
+	if err := os.MkdirAll(staleDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(staleDir, "Stale.html"), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := snapshotDir(t, destDir)
+
+	var result *BuildResult
+	theWiki.DryRun = true
+	theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+
+	if after := snapshotDir(t, destDir); !reflect.DeepEqual(before, after) {
+		t.Errorf("dry run changed dest dir:\nbefore %v\nafter  %v", before, after)
+	}
+	if !result.DryRun || !result.HasChanges() {
+		t.Errorf("DryRun = %v, HasChanges = %v, want both true", result.DryRun, result.HasChanges())
+	}
+	if want := []string{"New.html", "index.html"}; !reflect.DeepEqual(slices.Sorted(slices.Values(result.Generated)), want) {
+		t.Errorf("Generated = %v, want %v", result.Generated, want)
+	}
+	if want := []string{"New.html"}; !reflect.DeepEqual(result.Created, want) {
+		t.Errorf("Created = %v, want %v", result.Created, want)
+	}
+	if want := []string{"Old/Deeper/Stale.html"}; !reflect.DeepEqual(result.Deleted, want) {
+		t.Errorf("Deleted = %v, want %v", result.Deleted, want)
+	}
+
+	// A dry run after a real build has nothing to do.
+	theWiki.DryRun = false
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "Old")); !os.IsNotExist(err) {
+		t.Errorf("empty directory not deleted by clean: %v", err)
+	}
+	theWiki.DryRun = true
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if result.HasChanges() {
+		t.Errorf("dry run after build has changes: %+v", result)
+	}
+}
+
+func TestDryRunMemOutput(t *testing.T) {
+	out := NewMemOutput()
+	if err := out.WriteFile(context.Background(), "Stale.html", strings.NewReader("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	theWiki, err := NewWikiFS(testSourceFS(), out, util.DiscardLogger())
+	if err != nil {
+		t.Fatalf("NewWikiFS failed: %v", err)
+	}
+	theWiki.DryRun = true
+	var result *BuildResult
+	theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if want := []string{"Stale.html"}; !reflect.DeepEqual(out.Names(), want) {
+		t.Errorf("output has %v after dry run, want %v", out.Names(), want)
+	}
+	if want := []string{"Stale.html"}; !reflect.DeepEqual(result.Deleted, want) {
+		t.Errorf("Deleted = %v, want %v", result.Deleted, want)
+	}
+}
+
+func TestDryRunNewDestDir(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md": "# Index\n\n" + strings.Repeat("Some text. ", 200),
+	})
+	theWiki.DryRun = true
+	theWiki.Gzip = true
+	var result *BuildResult
+	theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+
+	// The dest dir isn't created, and the .gz siblings that would be written
+	// are listed along with the files they're next to.
+	if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+		t.Errorf("dry run created dest dir: %v", err)
+	}
+	for _, want := range []string{"index.html", "index.html.gz", "github-style.css.gz", "style.css.gz"} {
+		if !slices.Contains(result.Created, want) {
+			t.Errorf("Created = %v, want it to include %s", result.Created, want)
+		}
+	}
+}
+
+// snapshotDir returns the paths in dir, with the modification times of
+// files.
+func snapshotDir(t *testing.T, dir string) map[string]time.Time {
+	t.Helper()
+	snapshot := make(map[string]time.Time)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			snapshot[path] = time.Time{}
+		} else {
+			snapshot[path] = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
@@ -0,0 +1,362 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"archive/zip"
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"html"
+	"io"
+	"maps"
+	"mime"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	xhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// epubNavDepth is the deepest level of the headings listed in the navigation
+// document of an EPUB export.
+const epubNavDepth = 3
+
+// epubChapter is a page of an EPUB export.
+type epubChapter struct {
+	page     exportPage
+	rendered renderedPage
+	headings []exportHeading
+	file     string // Name of its XHTML file in the book
+	weight   *int   // Weight from front matter, if it has one
+}
+
+// epubContainerText is the META-INF/container.xml of an EPUB, which points
+// at its package document.
+const epubContainerText = `<?xml version="1.0" encoding="utf-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml" />
+</rootfiles>
+</container>
+`
+
+// epubDocumentHeader is the start of each XHTML document in an EPUB, given
+// the document title and the link elements for its style sheets.
+const epubDocumentHeader = `<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="en" xml:lang="en">
+<head>
+<meta charset="utf-8" />
+<title>%s</title>
+%s</head>
+<body>
+`
+
+// ExportEPUB builds an EPUB 3 book from the page, or the pages under the
+// directory, at relPath, a slash-separated path relative to the content dir,
+// and writes it to w. relPath is as described for ExportHTML. Each page is a
+// chapter, ordered first as listed in Chapters, then by the weight in their
+// front matter, lowest first, and then alphabetically, with the index page of
+// each directory first. The navigation document lists the chapters along
+// with their headings. Images in the content dir are packaged in the book,
+// and links between the exported pages point at their chapters. Pages are
+// rendered as XHTML, with the default style sheet and local.css, so raw HTML
+// in them needs to be well-formed.
+func (wiki *Wiki) ExportEPUB(ctx context.Context, relPath string, w io.Writer, version string) error {
+	defer wiki.startExport()()
+
+	pages, title, err := wiki.findExportPages(relPath)
+	if err != nil {
+		return err
+	}
+	files := make(map[string]string, len(pages)) // Chapter files, by slash-separated content path without extension
+	for _, page := range pages {
+		files[removeFileExtension(page.relPath)] = page.anchor + ".xhtml"
+	}
+
+	// Render the chapters, and find the images they use.
+	converter := newMarkdown(wiki.Extensions, xhtml.WithXHTML())
+	images := map[string]bool{} // Images in the book, by slash-separated content path
+	var chapters []epubChapter
+	for _, page := range pages {
+		rewriter := &exportRewriter{
+			wiki:  wiki,
+			links: &linkRewriter{sourcePath: page.relPath},
+			pageLink: func(target, fragment string) (string, bool) {
+				file, ok := files[target]
+				if ok && fragment != "" {
+					return file + "#" + fragment, true
+				}
+				return file, ok
+			},
+			image: func(target string) (string, error) {
+				if _, err := wiki.statSource(filepath.Join(wiki.ContentDir, filepath.FromSlash(target))); err != nil {
+					return "", err
+				}
+				images[target] = true
+				return urlPathEscape(epubImagePath(target)), nil
+			},
+		}
+		rendered, err := wiki.renderExportPage(ctx, page, converter, rewriter, version)
+		if err != nil {
+			return err
+		}
+		chapter := epubChapter{page: page, rendered: rendered, headings: rewriter.headings, file: files[removeFileExtension(page.relPath)]}
+		if weight := rendered.frontMatter.String("weight"); weight != "" {
+			if n, err := strconv.Atoi(weight); err != nil {
+				wiki.log.Warning("Ignoring weight '%s' of '%s', which isn't a whole number", weight, page.contentPath)
+			} else {
+				chapter.weight = &n
+			}
+		}
+		chapters = append(chapters, chapter)
+	}
+	if len(chapters) == 1 {
+		title = chapters[0].rendered.title
+	}
+	wiki.orderChapters(chapters)
+
+	// Write the book. The mimetype file comes first, uncompressed, so that
+	// the book can be recognized by its first bytes.
+	zw := zip.NewWriter(w)
+	writeFile := func(name string, method uint16, data string) error {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: wiki.buildTime})
+		if err == nil {
+			_, err = io.WriteString(fw, data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write '%s' to EPUB export of '%s': %v", name, relPath, err)
+		}
+		return nil
+	}
+	if err := writeFile("mimetype", zip.Store, "application/epub+zip"); err != nil {
+		return err
+	}
+	if err := writeFile("META-INF/container.xml", zip.Deflate, epubContainerText); err != nil {
+		return err
+	}
+
+	// Style sheets.
+	styles := []string{"style.css"}
+	css, err := wiki.embeddedCSS("style.css")
+	if err != nil {
+		return err
+	}
+	if err := writeFile("OEBPS/style.css", zip.Deflate, string(css)); err != nil {
+		return err
+	}
+	if localCSS, err := wiki.readSource(filepath.Join(wiki.ContentDir, "local.css")); err == nil {
+		styles = append(styles, "local.css")
+		if err := writeFile("OEBPS/local.css", zip.Deflate, string(localCSS)); err != nil {
+			return err
+		}
+	}
+	var styleLinks strings.Builder
+	for _, style := range styles {
+		fmt.Fprintf(&styleLinks, "<link href=\"%s\" rel=\"stylesheet\" type=\"text/css\" />\n", style)
+	}
+
+	// Chapters, and the navigation document.
+	for _, chapter := range chapters {
+		doc := fmt.Sprintf(epubDocumentHeader, html.EscapeString(chapter.rendered.title), styleLinks.String()) + chapter.rendered.body + "</body>\n</html>\n"
+		if err := writeFile("OEBPS/"+chapter.file, zip.Deflate, doc); err != nil {
+			return err
+		}
+	}
+	if err := writeFile("OEBPS/nav.xhtml", zip.Deflate, epubNav(title, chapters)); err != nil {
+		return err
+	}
+
+	// Images.
+	imageTargets := slices.Sorted(maps.Keys(images))
+	for _, target := range imageTargets {
+		data, err := wiki.readSource(filepath.Join(wiki.ContentDir, filepath.FromSlash(target)))
+		if err != nil {
+			return fmt.Errorf("failed to read image '%s' for EPUB export of '%s': %v", target, relPath, err)
+		}
+		if err := writeFile("OEBPS/"+epubImagePath(target), zip.Deflate, string(data)); err != nil {
+			return err
+		}
+	}
+
+	// The package document, which lists the files and the reading order.
+	if err := writeFile("OEBPS/content.opf", zip.Deflate, epubPackage(title, wiki.buildTime, styles, chapters, imageTargets)); err != nil {
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish EPUB export of '%s': %v", relPath, err)
+	}
+	return nil
+}
+
+// orderChapters sorts chapters into reading order: first the pages in
+// wiki.Chapters, in the order they're listed, then those with weights, lowest
+// first, and then the others. Pages that are otherwise equal are in
+// alphabetical order, with index pages first in their directory.
+func (wiki Wiki) orderChapters(chapters []epubChapter) {
+	listed := make(map[string]int, len(wiki.Chapters)) // Position in wiki.Chapters, by name without extension
+	for i, name := range wiki.Chapters {
+		name = strings.Trim(path.Clean("/"+filepath.ToSlash(name)), "/")
+		if isPathMarkdown(name) {
+			name = removeFileExtension(name)
+		}
+		if !slices.ContainsFunc(chapters, func(chapter epubChapter) bool { return chapter.page.name == name }) {
+			wiki.log.Warning("Chapter '%s' isn't one of the pages exported", wiki.Chapters[i])
+			continue
+		}
+		if _, ok := listed[name]; !ok {
+			listed[name] = i
+		}
+	}
+
+	slices.SortStableFunc(chapters, func(a, b epubChapter) int {
+		aPos, aListed := listed[a.page.name]
+		bPos, bListed := listed[b.page.name]
+		switch {
+		case aListed && bListed:
+			return cmp.Compare(aPos, bPos)
+		case aListed != bListed:
+			if aListed {
+				return -1
+			}
+			return 1
+		case a.weight != nil && b.weight != nil && *a.weight != *b.weight:
+			return cmp.Compare(*a.weight, *b.weight)
+		case (a.weight != nil) != (b.weight != nil):
+			if a.weight != nil {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(cmp.Compare(chapterSortKey(a.page.name), chapterSortKey(b.page.name)), cmp.Compare(a.page.name, b.page.name))
+	})
+}
+
+// chapterSortKey returns the key that sorts the page with name, its path
+// without extension, alphabetically, with index pages before the other pages
+// in their directory.
+func chapterSortKey(name string) string {
+	key := strings.ToLower(name)
+	if path.Base(key) == "index" {
+		key = strings.TrimSuffix(key, "index")
+	}
+	return key
+}
+
+// chapterTitle returns the title of chapter in the navigation document, and
+// the headings listed under it: its first heading, if that's at level 1 and
+// comes first, or else its page title.
+func chapterTitle(chapter epubChapter) (string, []exportHeading) {
+	var headings []exportHeading
+	for _, heading := range chapter.headings {
+		if heading.level <= epubNavDepth && heading.text != "" {
+			headings = append(headings, heading)
+		}
+	}
+	if len(headings) > 0 && headings[0].level == 1 && chapter.headings[0] == headings[0] {
+		return headings[0].text, headings[1:]
+	}
+	return chapter.rendered.title, headings
+}
+
+// epubNav returns the navigation document of a book with title and chapters.
+func epubNav(title string, chapters []epubChapter) string {
+	var nav strings.Builder
+	fmt.Fprintf(&nav, epubDocumentHeader, html.EscapeString(title), "")
+	nav.WriteString("<nav epub:type=\"toc\" id=\"toc\">\n")
+	fmt.Fprintf(&nav, "<h1>%s</h1>\n<ol>\n", html.EscapeString(title))
+	for _, chapter := range chapters {
+		chapterTitle, headings := chapterTitle(chapter)
+		fmt.Fprintf(&nav, "<li><a href=\"%s\">%s</a>", chapter.file, html.EscapeString(chapterTitle))
+		if len(headings) > 0 {
+			nav.WriteString("\n")
+			writeNavList(&nav, chapter.file, headings)
+		}
+		nav.WriteString("</li>\n")
+	}
+	nav.WriteString("</ol>\n</nav>\n</body>\n</html>\n")
+	return nav.String()
+}
+
+// writeNavList writes headings of the chapter in file as a nested list, with
+// the deeper headings that follow a heading listed under it.
+func writeNavList(nav *strings.Builder, file string, headings []exportHeading) {
+	nav.WriteString("<ol>\n")
+	for i := 0; i < len(headings); {
+		heading := headings[i]
+		end := i + 1
+		for end < len(headings) && headings[end].level > heading.level {
+			end++
+		}
+		fmt.Fprintf(nav, "<li><a href=\"%s#%s\">%s</a>", file, html.EscapeString(heading.id), html.EscapeString(heading.text))
+		if end > i+1 {
+			nav.WriteString("\n")
+			writeNavList(nav, file, headings[i+1:end])
+		}
+		nav.WriteString("</li>\n")
+		i = end
+	}
+	nav.WriteString("</ol>\n")
+}
+
+// epubImagePath returns the path in an EPUB, relative to its package
+// document, of the image with the slash-separated content path target.
+func epubImagePath(target string) string {
+	return "images/" + target
+}
+
+// epubPackage returns the package document of a book with title, modified at
+// modTime, with the style sheets styles, chapters in reading order, and the
+// images with the content paths imageTargets.
+func epubPackage(title string, modTime time.Time, styles []string, chapters []epubChapter, imageTargets []string) string {
+	var opf strings.Builder
+	opf.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="en">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(&opf, "<dc:identifier id=\"book-id\">%s</dc:identifier>\n", epubIdentifier(title, chapters))
+	fmt.Fprintf(&opf, "<dc:title>%s</dc:title>\n", html.EscapeString(title))
+	opf.WriteString("<dc:language>en</dc:language>\n")
+	fmt.Fprintf(&opf, "<meta property=\"dcterms:modified\">%s</meta>\n", modTime.UTC().Format("2006-01-02T15:04:05Z"))
+	opf.WriteString("</metadata>\n<manifest>\n")
+	opf.WriteString("<item id=\"nav\" href=\"nav.xhtml\" media-type=\"application/xhtml+xml\" properties=\"nav\" />\n")
+	for i, style := range styles {
+		fmt.Fprintf(&opf, "<item id=\"style-%d\" href=\"%s\" media-type=\"text/css\" />\n", i+1, style)
+	}
+	for _, chapter := range chapters {
+		fmt.Fprintf(&opf, "<item id=\"%s\" href=\"%s\" media-type=\"application/xhtml+xml\" />\n", chapter.page.anchor, chapter.file)
+	}
+	for i, target := range imageTargets {
+		mediaType := mime.TypeByExtension(strings.ToLower(path.Ext(target)))
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+		fmt.Fprintf(&opf, "<item id=\"image-%d\" href=\"%s\" media-type=\"%s\" />\n", i+1, html.EscapeString(urlPathEscape(epubImagePath(target))), mediaType)
+	}
+	opf.WriteString("</manifest>\n<spine>\n")
+	for _, chapter := range chapters {
+		fmt.Fprintf(&opf, "<itemref idref=\"%s\" />\n", chapter.page.anchor)
+	}
+	opf.WriteString("</spine>\n</package>\n")
+	return opf.String()
+}
+
+// epubIdentifier returns the unique identifier of a book with title and
+// chapters, a name-based UUID, so that exporting the same pages again gives
+// the same identifier.
+func epubIdentifier(title string, chapters []epubChapter) string {
+	hash := sha1.New()
+	io.WriteString(hash, title)
+	for _, chapter := range chapters {
+		io.WriteString(hash, "\n"+chapter.page.relPath)
+	}
+	sum := hash.Sum(nil)
+	sum[6] = sum[6]&0x0f | 0x50 // Version 5
+	sum[8] = sum[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
@@ -0,0 +1,119 @@
+package wiki
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// readEPUB exports relPath of theWiki as an EPUB, and returns its files by
+// name, checking that it starts with an uncompressed mimetype file and that
+// its XML files are well-formed.
+func readEPUB(t *testing.T, theWiki *Wiki, relPath string) map[string]string {
+	t.Helper()
+	var book bytes.Buffer
+	if err := theWiki.ExportEPUB(context.Background(), relPath, &book, "v1"); err != nil {
+		t.Fatalf("ExportEPUB failed: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(book.Bytes()), int64(book.Len()))
+	if err != nil {
+		t.Fatalf("export isn't a zip archive: %v", err)
+	}
+	if first := zr.File[0]; first.Name != "mimetype" || first.Method != zip.Store {
+		t.Errorf("first file is %q with method %d, want stored mimetype", first.Name, first.Method)
+	}
+
+	files := map[string]string{}
+	for _, file := range zr.File {
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = string(data)
+
+		if strings.HasSuffix(file.Name, ".xhtml") || strings.HasSuffix(file.Name, ".xml") || strings.HasSuffix(file.Name, ".opf") {
+			decoder := xml.NewDecoder(bytes.NewReader(data))
+			for {
+				if _, err := decoder.Token(); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Errorf("%s isn't well-formed: %v", file.Name, err)
+					break
+				}
+			}
+		}
+	}
+	if files["mimetype"] != "application/epub+zip" {
+		t.Errorf("mimetype = %q", files["mimetype"])
+	}
+	return files
+}
+
+func TestExportEPUB(t *testing.T) {
+	theWiki := exportTestWiki(t, map[string]string{
+		"Book/index.md":    "# Introduction\n\nStart with [setup](Setup.md#step-two).<br />\n",
+		"Book/Setup.md":    "---\nweight: 2\n---\n# Setup\n\n## Step two\n\n### Detail\n\n## Step three\n\n![Logo](../img/logo.png)\n",
+		"Book/Usage.md":    "---\nweight: 1\n---\n# Usage\n",
+		"Book/Appendix.md": "# Appendix\n\nBack to the [intro](index.md).\n",
+		"Book/Zebra.md":    "## Part\n\n# Zebra\n",
+		"img/logo.png":     "PNG",
+		"Other.md":         "# Other\n",
+	})
+	theWiki.Chapters = []string{"Zebra.md", "Missing"}
+
+	files := readEPUB(t, theWiki, "Book")
+
+	// Chapters are ordered by the list, then by weight, then alphabetically
+	// with index pages first.
+	spine := regexp.MustCompile(`<itemref idref="([^"]+)" />`).FindAllStringSubmatch(files["OEBPS/content.opf"], -1)
+	var order []string
+	for _, itemref := range spine {
+		order = append(order, itemref[1])
+	}
+	want := []string{"page-book-zebra", "page-book-usage", "page-book-setup", "page-book-index", "page-book-appendix"}
+	if strings.Join(order, " ") != strings.Join(want, " ") {
+		t.Errorf("spine = %v, want %v", order, want)
+	}
+
+	// The navigation document lists the headings under their chapters,
+	// with the first heading as the title if it's at level 1.
+	nav := files["OEBPS/nav.xhtml"]
+	for _, want := range []string{
+		"<title>Book</title>",
+		`<li><a href="page-book-setup.xhtml">Setup</a>`,
+		"<li><a href=\"page-book-setup.xhtml#step-two\">Step two</a>\n<ol>\n<li><a href=\"page-book-setup.xhtml#detail\">Detail</a></li>\n</ol>\n</li>\n",
+		"<li><a href=\"page-book-zebra.xhtml\">Zebra</a>\n<ol>\n<li><a href=\"page-book-zebra.xhtml#part\">Part</a></li>\n<li><a href=\"page-book-zebra.xhtml#zebra\">Zebra</a></li>\n</ol>\n",
+	} {
+		if !strings.Contains(nav, want) {
+			t.Errorf("nav doesn't contain %q:\n%s", want, nav)
+		}
+	}
+
+	// Links between pages point at chapters, and images are packaged.
+	if intro := files["OEBPS/page-book-index.xhtml"]; !strings.Contains(intro, `<a href="page-book-setup.xhtml#step-two">setup</a>`) || !strings.Contains(intro, "<br />") {
+		t.Errorf("unexpected intro chapter:\n%s", intro)
+	}
+	if setup := files["OEBPS/page-book-setup.xhtml"]; !strings.Contains(setup, `<img src="images/img/logo.png" alt="Logo" />`) {
+		t.Errorf("image not rewritten:\n%s", setup)
+	}
+	if files["OEBPS/images/img/logo.png"] != "PNG" {
+		t.Errorf("image not packaged")
+	}
+	if !strings.Contains(files["OEBPS/content.opf"], `href="images/img/logo.png" media-type="image/png"`) {
+		t.Errorf("image not in manifest:\n%s", files["OEBPS/content.opf"])
+	}
+	if _, ok := files["OEBPS/page-other.xhtml"]; ok {
+		t.Errorf("page outside the exported directory is in the book")
+	}
+}
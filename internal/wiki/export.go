@@ -0,0 +1,436 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+)
+
+// exportPage is a markdown page included in an export.
+type exportPage struct {
+	contentPath string // Full path of the markdown file
+	relPath     string // Slash-separated content path of the markdown file
+	anchor      string // ID of the page in the exported document
+	name        string // Name in the table of contents: the path without extension relative to the exported directory
+}
+
+// ExportHTML renders the page, or the pages under the directory, at relPath,
+// a slash-separated path relative to the content dir, into a single HTML
+// document written to w. An empty relPath or "." exports the whole wiki, and
+// a page can be named without its extension. The document needs no other
+// files: the style sheets linked by the header template are inlined, images
+// are embedded as data URIs, and links between the exported pages are turned
+// into links to anchors in the document. Pages keep the order they're
+// generated in, with the index page of a directory first, and all of them
+// use the style of the first.
+func (wiki *Wiki) ExportHTML(ctx context.Context, relPath string, w io.Writer, version string) error {
+	defer wiki.startExport()()
+
+	pages, title, err := wiki.findExportPages(relPath)
+	if err != nil {
+		return err
+	}
+	anchors := make(map[string]string, len(pages)) // Page anchors, by slash-separated content path without extension
+	for _, page := range pages {
+		anchors[removeFileExtension(page.relPath)] = page.anchor
+	}
+
+	// Render the pages.
+	var rendered []renderedPage
+	for _, page := range pages {
+		rewriter := &exportRewriter{
+			wiki:   wiki,
+			links:  &linkRewriter{sourcePath: page.relPath},
+			prefix: page.anchor + "--",
+			pageLink: func(target, fragment string) (string, bool) {
+				anchor, ok := anchors[target]
+				if ok && fragment != "" {
+					return "#" + anchor + "--" + fragment, true
+				}
+				return "#" + anchor, ok
+			},
+			image: func(target string) (string, error) {
+				return wiki.dataURI(filepath.Join(wiki.ContentDir, filepath.FromSlash(target)))
+			},
+		}
+		out, err := wiki.renderExportPage(ctx, page, wiki.markdown(), rewriter, version)
+		if err != nil {
+			return err
+		}
+		rendered = append(rendered, out)
+	}
+	if len(pages) == 1 {
+		title = rendered[0].title
+	}
+
+	// Write the document, starting with the header template with the files
+	// it links to inlined.
+	gitHubStyle := rendered[0].useGitHubStyle
+	header := &strings.Builder{}
+	if err := wiki.headerTemplate(gitHubStyle).Execute(header, wiki.newTemplateData(title, version, "")); err != nil {
+		return fmt.Errorf("failed to create HTML header for export of '%s': %v", relPath, err)
+	}
+	doc := &strings.Builder{}
+	doc.WriteString(wiki.inlineHeaderLinks(header.String()))
+	if len(pages) > 1 {
+		doc.WriteString("<nav>\n<ul>\n")
+		for _, page := range pages {
+			fmt.Fprintf(doc, "<li><a href=\"#%s\">%s</a></li>\n", page.anchor, html.EscapeString(page.name))
+		}
+		doc.WriteString("</ul>\n</nav>\n")
+	}
+	for i, page := range pages {
+		fmt.Fprintf(doc, "<section id=\"%s\">\n%s</section>\n", page.anchor, rendered[i].body)
+	}
+	doc.WriteString(htmlFooter(gitHubStyle))
+
+	if _, err := io.WriteString(w, doc.String()); err != nil {
+		return fmt.Errorf("failed to write export of '%s': %v", relPath, err)
+	}
+	return nil
+}
+
+// startExport prepares the wiki for an export, and returns the function to
+// call once it's done.
+func (wiki *Wiki) startExport() func() {
+	wiki.buildTime = wiki.buildDate(time.Now())
+	wiki.converter = newMarkdown(wiki.Extensions)
+	if _, err := wiki.loadPlugins(); err != nil {
+		wiki.log.Error(err, "not running plugins")
+		wiki.plugins.clear()
+	}
+	return wiki.closePlugins
+}
+
+// renderExportPage renders page for an export with converter, rewriting it
+// with rewriter.
+func (wiki Wiki) renderExportPage(ctx context.Context, page exportPage, converter goldmark.Markdown, rewriter *exportRewriter, version string) (renderedPage, error) {
+	if ctx.Err() != nil {
+		return renderedPage{}, ctx.Err()
+	}
+	wiki.log.Verbose("Exporting '%s'", page.contentPath)
+	info, err := wiki.statSource(page.contentPath)
+	if err != nil {
+		return renderedPage{}, fmt.Errorf("failed to stat markdown file '%s': %v", page.contentPath, err)
+	}
+	if info.Size() > MaxMarkdownFileSize {
+		return renderedPage{}, fmt.Errorf("markdown file '%s' is too large (%d bytes, max %d bytes)", page.contentPath, info.Size(), MaxMarkdownFileSize)
+	}
+	data, err := wiki.readSource(page.contentPath)
+	if err != nil {
+		return renderedPage{}, fmt.Errorf("failed to read markdown file '%s': %v", page.contentPath, err)
+	}
+	return wiki.renderPage(ctx, page.contentPath, filepath.FromSlash(page.relPath), info, data, version, func(markdown []byte, w io.Writer) error {
+		pc := parser.NewContext()
+		pc.Set(exportRewriterKey, rewriter)
+		return converter.Convert(markdown, w, parser.WithContext(pc))
+	})
+}
+
+// findExportPages returns the pages to export for relPath, as described for
+// ExportHTML, along with the title of the export.
+func (wiki Wiki) findExportPages(relPath string) ([]exportPage, string, error) {
+	relPath = path.Clean("/" + filepath.ToSlash(relPath))[1:]
+	contentPath := filepath.Join(wiki.ContentDir, filepath.FromSlash(relPath))
+
+	// A single page, named with or without its extension.
+	info, err := wiki.statSource(contentPath)
+	if err != nil || !info.IsDir() {
+		candidates := []string{relPath}
+		if !isPathMarkdown(relPath) {
+			candidates = nil
+			for _, ext := range markdownExts {
+				candidates = append(candidates, relPath+ext)
+			}
+		}
+		for _, candidate := range candidates {
+			candidatePath := filepath.Join(wiki.ContentDir, filepath.FromSlash(candidate))
+			if info, err := wiki.statSource(candidatePath); err == nil && !info.IsDir() && !wiki.ignoreFile(candidatePath, false) {
+				name := path.Base(removeFileExtension(candidate))
+				return []exportPage{{candidatePath, candidate, exportAnchor(candidate, map[string]bool{}), name}}, name, nil
+			}
+		}
+		return nil, "", fmt.Errorf("no page or directory '%s' in '%s'", relPath, wiki.ContentDir)
+	}
+
+	// All pages under a directory, with the index page of each directory
+	// first.
+	var pages []exportPage
+	anchors := map[string]bool{}
+	dirStarts := map[string]int{} // Where the pages of each directory start
+	err = wiki.walkSource(contentPath, func(pagePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if wiki.ignoreFile(pagePath, info.IsDir()) {
+			if info.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			dirStarts[pagePath] = len(pages)
+			return nil
+		}
+		name, _ := wiki.sourceName(pagePath)
+		if !isPathMarkdown(pagePath) || !isReadableFile(wiki.log, wiki.source, name, info, pagePath) {
+			return nil
+		}
+		relContentPath, err := filepath.Rel(wiki.ContentDir, pagePath)
+		if err != nil {
+			return err
+		}
+		relContentPath = filepath.ToSlash(relContentPath)
+		shortName := strings.TrimPrefix(removeFileExtension(relContentPath), relPath+"/")
+		page := exportPage{pagePath, relContentPath, exportAnchor(relContentPath, anchors), shortName}
+		if path.Base(removeFileExtension(relContentPath)) == "index" {
+			pages = slices.Insert(pages, dirStarts[filepath.Dir(pagePath)], page)
+		} else {
+			pages = append(pages, page)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find pages in '%s': %v", contentPath, err)
+	}
+	if len(pages) == 0 {
+		return nil, "", fmt.Errorf("no pages in '%s'", contentPath)
+	}
+
+	title := path.Base(relPath)
+	if relPath == "" {
+		if title = filepath.Base(wiki.SourceDir); title == "." {
+			title = "wiki"
+		}
+	}
+	return pages, title, nil
+}
+
+// exportAnchor returns the ID in an exported document of the page with the
+// slash-separated content path relPath, such as page-foo-bar for Foo/Bar.md,
+// which is made unique among those in used. IDs of pages never have two
+// dashes in a row, so that the IDs of headings, which are prefixed with the
+// ID of their page and two dashes, can't clash with them or each other.
+func exportAnchor(relPath string, used map[string]bool) string {
+	var slug strings.Builder
+	for _, r := range removeFileExtension(relPath) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			slug.WriteRune(unicode.ToLower(r))
+		} else if s := slug.String(); s != "" && !strings.HasSuffix(s, "-") {
+			slug.WriteByte('-')
+		}
+	}
+	base := strings.TrimSuffix("page-"+strings.TrimSuffix(slug.String(), "-"), "-")
+	anchor := base
+	for i := 2; used[anchor]; i++ {
+		anchor = fmt.Sprintf("%s-%d", base, i)
+	}
+	used[anchor] = true
+	return anchor
+}
+
+// exportRewriterKey is the parser context key for the exportRewriter of the
+// page being exported.
+var exportRewriterKey = parser.NewContextKey()
+
+// exportRewriter rewrites a page for an export. IDs in the page, and the
+// fragments of links within it, are prefixed, so that they can be kept unique
+// in a document with other pages. Links to exported pages are rewritten with
+// pageLink, and images in the content dir with image. The headings of the
+// page are recorded along the way.
+type exportRewriter struct {
+	wiki     *Wiki
+	links    *linkRewriter                                // For finding the content paths of link targets
+	prefix   string                                       // Prefix of the IDs in the page
+	pageLink func(target, fragment string) (string, bool) // Link to the exported page with the slash-separated content path target, without extension, if it's exported
+	image    func(target string) (string, error)          // Destination of the image with the slash-separated content path target
+	headings []exportHeading                              // Headings found in the page
+}
+
+// exportHeading is a heading of an exported page.
+type exportHeading struct {
+	level int
+	id    string // ID, with the page's prefix
+	text  string
+}
+
+// transform rewrites the IDs, links, and images in doc, whose source is
+// source.
+func (r *exportRewriter) transform(doc *ast.Document, source []byte) {
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var id string
+		if value, ok := node.AttributeString("id"); ok {
+			switch value := value.(type) {
+			case []byte:
+				id = r.prefix + string(value)
+				node.SetAttributeString("id", []byte(id))
+			case string:
+				id = r.prefix + value
+				node.SetAttributeString("id", id)
+			}
+		}
+		switch n := node.(type) {
+		case *ast.Heading:
+			r.headings = append(r.headings, exportHeading{n.Level, id, headingText(n, source)})
+		case *ast.Link:
+			n.Destination = []byte(r.rewriteLink(string(n.Destination)))
+		case *ast.Image:
+			n.Destination = []byte(r.rewriteImage(string(n.Destination)))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// headingText returns the text of the heading n, whose source is source,
+// without any markup.
+func headingText(n ast.Node, source []byte) string {
+	var text strings.Builder
+	_ = ast.Walk(n, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			switch node := node.(type) {
+			case *ast.Text:
+				text.Write(node.Value(source))
+				if node.SoftLineBreak() || node.HardLineBreak() {
+					text.WriteByte(' ')
+				}
+			case *ast.String:
+				text.Write(node.Value)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(text.String())
+}
+
+// rewriteLink returns the link destination dest rewritten for the export.
+// Links within the page and to exported pages are rewritten, and others are
+// returned as is.
+func (r *exportRewriter) rewriteLink(dest string) string {
+	link, err := url.Parse(dest)
+	if err != nil {
+		return dest
+	}
+	if link.Scheme == "" && link.Host == "" && link.Path == "" && link.Fragment != "" {
+		return "#" + r.prefix + link.Fragment
+	}
+	target, ok := r.links.linkTarget(link)
+	if !ok {
+		return dest
+	}
+	ext := strings.ToLower(path.Ext(target))
+	if !isPathMarkdown(target) && ext != ".html" {
+		return dest
+	}
+	if rewritten, ok := r.pageLink(removeFileExtension(target), link.Fragment); ok {
+		return rewritten
+	}
+	return dest
+}
+
+// rewriteImage returns the image destination dest rewritten for the export,
+// if it's a file in the content dir.
+func (r *exportRewriter) rewriteImage(dest string) string {
+	target, ok := r.links.target(dest)
+	if !ok {
+		return dest
+	}
+	rewritten, err := r.image(target)
+	if err != nil {
+		r.wiki.log.Warning("Not exporting image '%s' in '%s': %v", dest, r.links.sourcePath, err)
+		return dest
+	}
+	return rewritten
+}
+
+// dataURI returns the source file at sourcePath as a data URI.
+func (wiki Wiki) dataURI(sourcePath string) (string, error) {
+	data, err := wiki.readSource(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	mediaType := mime.TypeByExtension(strings.ToLower(filepath.Ext(sourcePath)))
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// headerLinkPattern matches the link elements in a page header, and
+// headerAttrPattern the attributes in them.
+var (
+	headerLinkPattern = regexp.MustCompile(`<link\b[^>]*>\n?`)
+	headerAttrPattern = regexp.MustCompile(`\b(href|rel)="([^"]*)"`)
+)
+
+// inlineHeaderLinks returns the page header header, with the files in the
+// dest dir that it links to inlined: style sheets in style elements, and
+// others, such as the favicon, as data URIs. Links to files that don't exist
+// are removed.
+func (wiki Wiki) inlineHeaderLinks(header string) string {
+	return headerLinkPattern.ReplaceAllStringFunc(header, func(element string) string {
+		var rawHref, rel string
+		for _, attr := range headerAttrPattern.FindAllStringSubmatch(element, -1) {
+			if attr[1] == "href" {
+				rawHref = attr[2]
+			} else {
+				rel = attr[2]
+			}
+		}
+		link, err := url.Parse(html.UnescapeString(rawHref))
+		if err != nil || link.Scheme != "" || link.Host != "" || link.Path == "" || strings.HasPrefix(link.Path, "/") {
+			return element // Not a file in the dest dir
+		}
+		name := path.Clean(link.Path)
+
+		// Style sheets are either embedded or copied from the content dir.
+		if rel == "stylesheet" {
+			var css []byte
+			if slices.Contains(cssFiles, name) {
+				css, err = wiki.embeddedCSS(name)
+			} else {
+				css, err = wiki.readSource(filepath.Join(wiki.ContentDir, filepath.FromSlash(name)))
+			}
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					wiki.log.Warning("Not inlining style sheet '%s': %v", name, err)
+				}
+				return ""
+			}
+			return "<style>\n" + string(css) + "\n</style>\n"
+		}
+
+		uri, err := wiki.dataURI(filepath.Join(wiki.ContentDir, filepath.FromSlash(name)))
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				wiki.log.Warning("Not embedding '%s': %v", name, err)
+			}
+			return ""
+		}
+		return strings.Replace(element, `href="`+rawHref+`"`, `href="`+uri+`"`, 1)
+	})
+}
@@ -0,0 +1,117 @@
+package wiki
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+// exportTestWiki returns a wiki read from files, given by content path.
+func exportTestWiki(t *testing.T, files map[string]string) *Wiki {
+	t.Helper()
+	source := fstest.MapFS{}
+	for relPath, content := range files {
+		source["content/"+relPath] = &fstest.MapFile{Data: []byte(content)}
+	}
+	theWiki, err := NewWikiFS(source, NewMemOutput(), util.DiscardLogger())
+	if err != nil {
+		t.Fatalf("NewWikiFS failed: %v", err)
+	}
+	return theWiki
+}
+
+func TestExportAnchor(t *testing.T) {
+	used := map[string]bool{}
+	for _, tc := range []struct{ relPath, want string }{
+		{"index.md", "page-index"},
+		{"Docs/Set up--Guide.md", "page-docs-set-up-guide"},
+		{"docs/set-up guide.md", "page-docs-set-up-guide-2"},
+		{"_.md", "page"},
+	} {
+		if got := exportAnchor(tc.relPath, used); got != tc.want {
+			t.Errorf("exportAnchor(%q) = %q, want %q", tc.relPath, got, tc.want)
+		}
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	theWiki := exportTestWiki(t, map[string]string{
+		"index.md":      "# Home\n\nSee [step two](Docs/Setup.md#step-two), [docs](Docs/), and [top](#home).\n",
+		"Docs/Setup.md": "# Setup\n\n## Step two\n\n![Logo](../logo.png) Back [home](../index.html), or [away](https://example.com/).\n",
+		"Docs/index.md": "# Docs\n",
+		"logo.png":      "PNG",
+		"local.css":     "p { color: red; }",
+	})
+
+	var doc strings.Builder
+	if err := theWiki.ExportHTML(context.Background(), "", &doc, "v1"); err != nil {
+		t.Fatalf("ExportHTML failed: %v", err)
+	}
+	html := doc.String()
+	for _, want := range []string{
+		"<title>wiki</title>",
+		"p { color: red; }",
+		`<li><a href="#page-docs-setup">Docs/Setup</a></li>`,
+		`<section id="page-docs-setup">`,
+		`<h2 id="page-docs-setup--step-two">Step two</h2>`,
+		`<a href="#page-docs-setup--step-two">step two</a>`,
+		`<a href="#page-index--home">top</a>`,
+		`<a href="#page-index">home</a>`,
+		`<a href="https://example.com/">away</a>`,
+		`<img src="data:image/png;base64,UE5H" alt="Logo">`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("export doesn't contain %q:\n%s", want, html)
+		}
+	}
+
+	// Links to directories aren't pages, and aren't rewritten.
+	if !strings.Contains(html, `<a href="Docs/">docs</a>`) {
+		t.Errorf("link to directory was rewritten:\n%s", html)
+	}
+
+	// Style sheets are inlined rather than linked.
+	if strings.Contains(html, "<link") {
+		t.Errorf("export links to files:\n%s", html)
+	}
+
+	// Index pages come first in their directory.
+	home, docs, setup := strings.Index(html, `id="page-index"`), strings.Index(html, `id="page-docs-index"`), strings.Index(html, `id="page-docs-setup"`)
+	if !(home < docs && docs < setup) {
+		t.Errorf("pages out of order: index at %d, Docs/index at %d, Docs/Setup at %d", home, docs, setup)
+	}
+}
+
+func TestExportHTMLPage(t *testing.T) {
+	theWiki := exportTestWiki(t, map[string]string{
+		"Docs/Setup.md": "#[style(github)]\n# Setup\n\nSee [home](../index.md).\n",
+		"index.md":      "# Home\n",
+	})
+
+	// Pages can be named without their extension. Links to pages that
+	// aren't exported are left as is.
+	var doc strings.Builder
+	if err := theWiki.ExportHTML(context.Background(), "Docs/Setup", &doc, "v1"); err != nil {
+		t.Fatalf("ExportHTML failed: %v", err)
+	}
+	html := doc.String()
+	for _, want := range []string{
+		"<title>Setup</title>",
+		`<article class="markdown-body">`,
+		`<a href="../index.md">home</a>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("export doesn't contain %q:\n%s", want, html)
+		}
+	}
+	if strings.Contains(html, "<nav>") {
+		t.Errorf("export of a single page has a table of contents:\n%s", html)
+	}
+
+	if err := theWiki.ExportHTML(context.Background(), "Missing", &doc, "v1"); err == nil {
+		t.Error("ExportHTML of a missing page succeeded")
+	}
+}
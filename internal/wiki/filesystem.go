@@ -4,6 +4,7 @@ package wiki
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -14,76 +15,78 @@ import (
 	"github.com/stalexan/gomarkwiki/internal/util"
 )
 
-// warnIfSymlinkToDir checks if path is a symlink to a directory and prints a warning if so.
-// Returns true if it is a symlink to a directory, false otherwise.
+// warnIfSymlinkToDir checks if path, the file called name in fsys, is a symlink to a
+// directory and prints a warning if so. Returns true if it is a symlink to a directory,
+// false otherwise.
 //
 // Security note: Skipping symlinks to directories is important for two reasons:
 // 1. Prevents symlink loops that could cause infinite recursion
 // 2. Prevents bypassing the MaxRecursionDepth limit via symlinks to deep directory trees
-// filepath.Walk uses os.Lstat internally, so it never follows directory symlinks automatically.
-func warnIfSymlinkToDir(info fs.FileInfo, path string) bool {
+// fs.WalkDir never follows directory symlinks automatically.
+func warnIfSymlinkToDir(log util.Logger, fsys fs.FS, name string, info fs.FileInfo, path string) bool {
 	// Check if this is a symlink
-	// Note: filepath.Walk uses os.Lstat internally, so symlinks will have os.ModeSymlink set.
+	// Note: fs.WalkDir reports the info of the link itself, so symlinks will have os.ModeSymlink set.
 	if info.Mode()&os.ModeSymlink == 0 {
 		return false
 	}
 
 	// Check if the symlink target is a directory
-	targetInfo, err := os.Stat(path)
+	targetInfo, err := fs.Stat(fsys, name)
 	if err != nil {
 		// Can't determine target - provide helpful warning messages
 		if os.IsNotExist(err) {
-			util.PrintWarning("Skipping broken symlink '%s' (target does not exist)", path)
+			log.Warning("Skipping broken symlink '%s' (target does not exist)", path)
 		} else if os.IsPermission(err) {
-			util.PrintWarning("Skipping symlink '%s' (permission denied reading target)", path)
+			log.Warning("Skipping symlink '%s' (permission denied reading target)", path)
 		} else {
-			util.PrintWarning("Skipping symlink '%s' (error reading target: %v)", path, err)
+			log.Warning("Skipping symlink '%s' (error reading target: %v)", path, err)
 		}
 		return true // Treat unreadable symlinks as "should skip"
 	}
 
 	if targetInfo.IsDir() {
-		util.PrintWarning("Skipping symlink to directory '%s' (symlinks to directories are not followed)", path)
+		log.Warning("Skipping symlink to directory '%s' (symlinks to directories are not followed)", path)
 		return true
 	}
 
 	return false
 }
 
-// isReadableFile checks to see whether path is a regular file and readable.
-func isReadableFile(info fs.FileInfo, path string) bool {
+// isReadableFile checks to see whether path, the file called name in fsys, is a regular
+// file and readable.
+func isReadableFile(log util.Logger, fsys fs.FS, name string, info fs.FileInfo, path string) bool {
 	// Is this a dir?
 	if info.IsDir() {
 		return false
 	}
 
 	// Is the file regular or a symlink?
-	// Note: filepath.Walk uses os.Lstat internally, so symlinks will have os.ModeSymlink set.
+	// Note: fs.WalkDir reports the info of the link itself, so symlinks will have os.ModeSymlink set.
 	mode := info.Mode()
 	isSymlink := mode&os.ModeSymlink != 0
 	if !mode.IsRegular() && !isSymlink {
-		util.PrintWarning("Skipping not regular file '%s'", path)
+		log.Warning("Skipping not regular file '%s'", path)
 		return false
 	}
 
 	// If it's a symlink, verify the target is a regular file.
-	// os.Stat follows symlinks, so this checks the target's type.
+	// fs.Stat follows symlinks, so this checks the target's type.
 	if isSymlink {
-		targetInfo, err := os.Stat(path)
+		targetInfo, err := fs.Stat(fsys, name)
 		if err != nil {
-			util.PrintWarning("Skipping symlink with unresolvable target '%s': %v", path, err)
+			log.Warning("Skipping symlink with unresolvable target '%s': %v", path, err)
 			return false
 		}
 		if !targetInfo.Mode().IsRegular() {
-			util.PrintWarning("Skipping symlink to non-regular file '%s'", path)
+			log.Warning("Skipping symlink to non-regular file '%s'", path)
 			return false
 		}
 	}
 
 	// Check readability by attempting to open the file.
-	file, err := os.Open(path)
+	file, err := fsys.Open(name)
 	if err != nil {
-		util.PrintWarning("Skipping not readable file '%s': %v", path, err)
+		log.Warning("Skipping not readable file '%s': %v", path, err)
 		return false
 	}
 	file.Close()
@@ -92,9 +95,10 @@ func isReadableFile(info fs.FileInfo, path string) bool {
 	return true
 }
 
-// sourceIsOlder returns true if source is older than dest (i.e., dest is newer).
-func sourceIsOlder(sourceInfo fs.FileInfo, destPath string) bool {
-	destInfo, err := os.Stat(destPath)
+// sourceIsOlder returns true if source is older than the file destName in out
+// (i.e., dest is newer).
+func sourceIsOlder(sourceInfo fs.FileInfo, out Output, destName string) bool {
+	destInfo, err := out.Stat(destName)
 	if err == nil && sourceInfo.ModTime().Before(destInfo.ModTime()) {
 		return true
 	}
@@ -104,7 +108,7 @@ func sourceIsOlder(sourceInfo fs.FileInfo, destPath string) bool {
 // removeConflictingDir removes a directory at path if it exists and conflicts
 // with creating a file at that location. Returns nil if path doesn't exist,
 // is already a file, or was successfully removed.
-func removeConflictingDir(path string) error {
+func removeConflictingDir(log util.Logger, path string) error {
 	info, err := os.Lstat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -118,7 +122,7 @@ func removeConflictingDir(path string) error {
 	}
 
 	// It's a directory where we need to write a file - remove it
-	util.PrintVerbose("Removing directory '%s' that conflicts with file destination", path)
+	log.Verbose("Removing directory '%s' that conflicts with file destination", path)
 	if err := os.RemoveAll(path); err != nil {
 		return fmt.Errorf("failed to remove conflicting directory '%s': %v", path, err)
 	}
@@ -128,7 +132,7 @@ func removeConflictingDir(path string) error {
 // ensureDirectoryPath ensures that dirPath can be created as a directory.
 // If any component of the path is an existing file that would block directory
 // creation, that file is removed first.
-func ensureDirectoryPath(dirPath string) error {
+func ensureDirectoryPath(log util.Logger, dirPath string) error {
 	// First, try the simple case
 	err := os.MkdirAll(dirPath, 0755)
 	if err == nil {
@@ -163,7 +167,7 @@ func ensureDirectoryPath(dirPath string) error {
 
 			if !info.IsDir() {
 				// Found a file blocking the directory path
-				util.PrintVerbose("Removing file '%s' that conflicts with directory path", currentPath)
+				log.Verbose("Removing file '%s' that conflicts with directory path", currentPath)
 				if removeErr := os.Remove(currentPath); removeErr != nil {
 					return fmt.Errorf("failed to remove file '%s' blocking directory '%s': %v", currentPath, dirPath, removeErr)
 				}
@@ -187,10 +191,10 @@ func ensureDirectoryPath(dirPath string) error {
 // then renames it to the destination. This ensures that on cancellation or error,
 // the destination file is never left in a partially written state.
 // The mode parameter specifies the file permissions for the destination file.
-func copyToFile(ctx context.Context, destPath string, source io.Reader, mode os.FileMode) (err error) {
+func copyToFile(ctx context.Context, log util.Logger, destPath string, source io.Reader, mode os.FileMode) (err error) {
 	// Remove any conflicting directory at the destination path.
 	// This handles the case where source structure changed from directory to file.
-	if err := removeConflictingDir(destPath); err != nil {
+	if err := removeConflictingDir(log, destPath); err != nil {
 		return err
 	}
 
@@ -265,8 +269,9 @@ func copyToFile(ctx context.Context, destPath string, source io.Reader, mode os.
 	return nil
 }
 
-// copyFileToDest copies a file from the source dir to the dest dir.
-func (wiki Wiki) copyFileToDest(ctx context.Context, sourcePath, sourceRelPath string, regen bool) error {
+// copyFileToDest copies a file from the source dir to relDestPath in the
+// dest dir.
+func (wiki Wiki) copyFileToDest(ctx context.Context, sourcePath, relDestPath string, regen bool) error {
 	// Check for cancellation before starting
 	select {
 	case <-ctx.Done():
@@ -277,10 +282,10 @@ func (wiki Wiki) copyFileToDest(ctx context.Context, sourcePath, sourceRelPath s
 	// Re-stat the file to get current info and prevent TOCTOU issues.
 	// This must happen BEFORE the skip decision to avoid a race condition where the file
 	// changes between the Walk and the copy decision.
-	currentInfo, err := os.Stat(sourcePath)
+	currentInfo, err := wiki.statSource(sourcePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			util.PrintVerbose("'%s' was not copied to dest because it no longer exists", sourcePath)
+		if errors.Is(err, fs.ErrNotExist) {
+			wiki.log.Verbose("'%s' was not copied to dest because it no longer exists", sourcePath)
 			return nil
 		} else {
 			return fmt.Errorf("failed to stat source file '%s': %v", sourcePath, err)
@@ -289,51 +294,87 @@ func (wiki Wiki) copyFileToDest(ctx context.Context, sourcePath, sourceRelPath s
 
 	// Skip copying if source is older than dest.
 	// Use currentInfo (not sourceInfo) to ensure we have the latest modification time.
-	destPath := filepath.Join(wiki.DestDir, sourceRelPath)
-	if !regen && sourceIsOlder(currentInfo, destPath) {
+	destName := filepath.ToSlash(relDestPath)
+	if !regen && sourceIsOlder(currentInfo, wiki.out, destName) {
+		wiki.result.addSkipped(relDestPath)
 		return nil
 	}
 
-	// Create dest dir if it doesn't exist.
-	// Use ensureDirectoryPath to handle conflicts where a file exists in the path.
-	destDirPath := filepath.Dir(destPath)
-	if err := ensureDirectoryPath(destDirPath); err != nil {
-		return err
+	// Link file, if the wiki links static files and it can be.
+	linker, canLink := wiki.out.(fileLinker)
+	if linkPath, linkable := wiki.linkSourcePath(sourcePath); linkable && canLink {
+		linked, err := linker.linkFile(ctx, destName, linkPath, currentInfo.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if linked {
+			wiki.log.Verbose("Linked '%s'", relDestPath)
+			wiki.result.addCopied(relDestPath)
+			return nil
+		}
 	}
 
 	// Copy file.
-	util.PrintVerbose("Copying '%s'", sourceRelPath)
-	source, err := os.Open(sourcePath)
+	wiki.log.Verbose("Copying '%s'", relDestPath)
+	source, err := wiki.openSource(sourcePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			util.PrintVerbose("'%s' was not copied to dest because it no longer exists", sourcePath)
+		if errors.Is(err, fs.ErrNotExist) {
+			wiki.log.Verbose("'%s' was not copied to dest because it no longer exists", sourcePath)
 			return nil
 		} else {
 			return fmt.Errorf("could not open '%s' for copy to dest: %w", sourcePath, err)
 		}
 	}
 	defer source.Close()
-	if err := copyToFile(ctx, destPath, source, currentInfo.Mode().Perm()); err != nil {
+	if err := wiki.out.WriteFile(ctx, destName, source, currentInfo.Mode().Perm()); err != nil {
 		return err
 	}
+	wiki.result.addCopied(relDestPath)
 
 	return nil
 }
 
-// copyCssFile copies the embedded css `file` to dest dir.
-func (wiki *Wiki) copyCssFile(ctx context.Context, file string) error {
-	// Read file
-	var css []byte
-	var err error
+// cssFiles are the embedded css files copied to the dest dir.
+var cssFiles = []string{"style.css", "github-style.css"}
+
+// readCssFile reads the embedded css `file`.
+func readCssFile(file string) ([]byte, error) {
 	sourcePath := fmt.Sprintf("static/%s", file)
-	if css, err = embeddedFileSystem.ReadFile(sourcePath); err != nil {
-		return fmt.Errorf("failed to read embedded file '%s': %v", sourcePath, err)
+	css, err := embeddedFileSystem.ReadFile(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded file '%s': %v", sourcePath, err)
+	}
+	return css, nil
+}
+
+// embeddedCSS returns the embedded css `file` as it's written to the dest
+// dir.
+func (wiki Wiki) embeddedCSS(file string) ([]byte, error) {
+	css, err := readCssFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if wiki.Minify {
+		css = minifyCSS(css)
+	}
+	return css, nil
+}
+
+// copyCssFile copies the embedded css `file` to dest dir, with the
+// fingerprinted name destName if fingerprinting.
+func (wiki *Wiki) copyCssFile(ctx context.Context, file, destName string) error {
+	// Read file
+	css, err := readCssFile(file)
+	if err != nil {
+		return err
 	}
 
 	// Copy file
-	destPath := fmt.Sprintf("%s/%s", wiki.DestDir, file)
-	util.PrintVerbose("Copying '%s' to '%s'", sourcePath, destPath)
-	if err := copyToFile(ctx, destPath, bytes.NewReader(css), 0644); err != nil {
+	wiki.log.Verbose("Copying 'static/%s' to '%s'", file, wiki.destPath(destName))
+	if wiki.Minify {
+		css = wiki.minify(destName, css, minifyCSS)
+	}
+	if err := wiki.out.WriteFile(ctx, destName, bytes.NewReader(css), 0644); err != nil {
 		return err
 	}
 
@@ -343,11 +384,12 @@ func (wiki *Wiki) copyCssFile(ctx context.Context, file string) error {
 // copyCssFiles copies CSS files to dest dir.
 func (wiki *Wiki) copyCssFiles(ctx context.Context, relDestPaths map[string]bool) error {
 	// Don't delete css files even though they don't have a corresponding
-	// file in the source dir.
-	cssFiles := []string{"style.css", "github-style.css"}
+	// file in the source dir. Fingerprinted files are also kept under their
+	// original names, for references that aren't rewritten.
 	if relDestPaths != nil {
 		for _, cssFile := range cssFiles {
 			relDestPaths[cssFile] = true
+			relDestPaths[wiki.fingerprint(cssFile)] = true
 		}
 	}
 
@@ -359,7 +401,12 @@ func (wiki *Wiki) copyCssFiles(ctx context.Context, relDestPaths map[string]bool
 			return ctx.Err()
 		default:
 		}
-		if err := wiki.copyCssFile(ctx, cssFile); err != nil {
+		if destName := wiki.fingerprint(cssFile); destName != cssFile {
+			if err := wiki.copyCssFile(ctx, cssFile, destName); err != nil {
+				return err
+			}
+		}
+		if err := wiki.copyCssFile(ctx, cssFile, cssFile); err != nil {
 			return err
 		}
 	}
@@ -392,14 +439,33 @@ func isDirectoryEmpty(path string) (bool, error) {
 	return len(entries) == 0, nil
 }
 
+// isDirectoryEmptyAfter checks whether a directory would be empty once the
+// files and directories in gone, by full path, were deleted.
+func isDirectoryEmptyAfter(path string, gone map[string]bool) (bool, error) {
+	entries, err := listDirectoryContents(path)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if !gone[filepath.Join(path, entry.Name())] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 // deleteEmptyDirectories deletes any empty directories within path, including
 // directories that have just empty directories.
-func deleteEmptyDirectories(ctx context.Context, path string) error {
-	return deleteEmptyDirectoriesWithDepth(ctx, path, 0)
+//
+// If gone isn't nil it's a dry run: nothing is deleted, the files and
+// directories in gone, by full path, are treated as already deleted, and the
+// directories that would be deleted are added to it.
+func deleteEmptyDirectories(ctx context.Context, log util.Logger, path string, gone map[string]bool) error {
+	return deleteEmptyDirectoriesWithDepth(ctx, log, path, 0, gone)
 }
 
 // deleteEmptyDirectoriesWithDepth is the internal recursive implementation that tracks depth.
-func deleteEmptyDirectoriesWithDepth(ctx context.Context, path string, depth int) error {
+func deleteEmptyDirectoriesWithDepth(ctx context.Context, log util.Logger, path string, depth int, gone map[string]bool) error {
 	// Check recursion depth limit
 	if depth > MaxRecursionDepth {
 		return fmt.Errorf("directory recursion depth exceeded at '%s' (depth %d, max %d)", path, depth, MaxRecursionDepth)
@@ -422,21 +488,29 @@ func deleteEmptyDirectoriesWithDepth(ctx context.Context, path string, depth int
 
 		if entry.IsDir() {
 			// Recursively delete empty directories in subdirectories.
-			err := deleteEmptyDirectoriesWithDepth(ctx, entryPath, depth+1)
+			err := deleteEmptyDirectoriesWithDepth(ctx, log, entryPath, depth+1, gone)
 			if err != nil {
 				return err
 			}
 
 			// Check wehther the directory is empty.
-			isEmpty, err := isDirectoryEmpty(entryPath)
+			var isEmpty bool
+			if gone != nil {
+				isEmpty, err = isDirectoryEmptyAfter(entryPath, gone)
+			} else {
+				isEmpty, err = isDirectoryEmpty(entryPath)
+			}
 			if err != nil {
 				return err
 			}
 
-			if isEmpty {
+			if isEmpty && gone != nil {
+				log.Verbose("Would delete empty directory '%s'", entryPath)
+				gone[entryPath] = true
+			} else if isEmpty {
 				// Delete the empty directory.
 				// Ignore any error - if removal fails (e.g., due to TOCTOU race), it's harmless.
-				util.PrintVerbose("Deleting empty directory '%s'", entryPath)
+				log.Verbose("Deleting empty directory '%s'", entryPath)
 				os.Remove(entryPath)
 			}
 		}
@@ -445,12 +519,91 @@ func deleteEmptyDirectoriesWithDepth(ctx context.Context, path string, depth int
 	return nil
 }
 
-// cleanDestDir cleans the dest dir by any deleting files that don't have
-// a corresponding source file, and by deleting any empty directories.
-func (wiki Wiki) cleanDestDir(ctx context.Context, relDestPaths map[string]bool) error {
-	// Delete dest files that don't have a corresponding source file.
-	baseDepth := strings.Count(wiki.DestDir, string(filepath.Separator))
-	err := filepath.Walk(wiki.DestDir, func(destPath string, info fs.FileInfo, err error) error {
+// DirOutput is an Output that writes files to a directory on disk. Files are
+// written atomically, and files and directories that are in the way of a
+// file being written are removed.
+type DirOutput struct {
+	dir string
+	log util.Logger
+}
+
+// NewDirOutput returns a DirOutput that writes to dir, printing messages
+// with log.
+func NewDirOutput(dir string, log util.Logger) *DirOutput {
+	return &DirOutput{dir: dir, log: log}
+}
+
+// path returns the full path of the file name.
+func (o *DirOutput) path(name string) string {
+	return filepath.Join(o.dir, filepath.FromSlash(name))
+}
+
+// Stat returns info on the file name.
+func (o *DirOutput) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(o.path(name))
+}
+
+// WriteFile atomically writes the contents of r to the file name.
+func (o *DirOutput) WriteFile(ctx context.Context, name string, r io.Reader, mode fs.FileMode) error {
+	// Create the file's directory if necessary.
+	// Use ensureDirectoryPath to handle conflicts where a file exists in the path.
+	destPath := o.path(name)
+	if err := ensureDirectoryPath(o.log, filepath.Dir(destPath)); err != nil {
+		return err
+	}
+	return copyToFile(ctx, o.log, destPath, r, mode)
+}
+
+// removeFile deletes the file name.
+func (o *DirOutput) removeFile(name string) error {
+	if err := os.Remove(o.path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete '%s': %v", o.path(name), err)
+	}
+	return nil
+}
+
+// beginBuild creates the directory if it doesn't exist, other than in a dry
+// run.
+func (o *DirOutput) beginBuild(dryRun bool) error {
+	if dryRun {
+		return nil
+	}
+	if err := os.MkdirAll(o.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory '%s': %v", o.dir, err)
+	}
+	return nil
+}
+
+// Clean deletes the files in the directory for which keep returns false, and
+// any empty directories.
+func (o *DirOutput) Clean(ctx context.Context, keep func(name string) bool) ([]string, error) {
+	return o.clean(ctx, keep, false)
+}
+
+// dryRunClean returns the files that Clean would delete, without deleting
+// anything.
+func (o *DirOutput) dryRunClean(ctx context.Context, keep func(name string) bool) ([]string, error) {
+	return o.clean(ctx, keep, true)
+}
+
+// clean deletes the files for which keep returns false and any empty
+// directories, or with dryRun just works out what it would delete.
+func (o *DirOutput) clean(ctx context.Context, keep func(name string) bool, dryRun bool) ([]string, error) {
+	// In a dry run the directory may not have been created yet, in which
+	// case there's nothing to delete.
+	if _, err := os.Stat(o.dir); dryRun && os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	// Delete files that keep rejects.
+	var deleted []string
+	var gone map[string]bool // Full paths of what would be deleted, in a dry run
+	if dryRun {
+		gone = make(map[string]bool)
+	}
+	dirFS := os.DirFS(o.dir)
+	baseDepth := strings.Count(o.dir, string(filepath.Separator))
+	err := filepath.Walk(o.dir, func(destPath string, info fs.FileInfo, err error) error {
 		// Check for cancellation periodically during walk
 		select {
 		case <-ctx.Done():
@@ -469,18 +622,11 @@ func (wiki Wiki) cleanDestDir(ctx context.Context, relDestPaths map[string]bool)
 			return err
 		}
 
-		// Is this file regular and readable?
-		if !isReadableFile(info, destPath) {
-			// Warn if this is a symlink to a directory
-			warnIfSymlinkToDir(info, destPath)
-			return nil
-		}
-
 		// What's the relative path to this file with respect to the dest dir?
 		var relDestPath string
-		relDestPath, err = filepath.Rel(wiki.DestDir, destPath)
+		relDestPath, err = filepath.Rel(o.dir, destPath)
 		if err != nil {
-			return fmt.Errorf("failed to find relative path of '%s' given '%s': %v", destPath, wiki.DestDir, err)
+			return fmt.Errorf("failed to find relative path of '%s' given '%s': %v", destPath, o.dir, err)
 		}
 
 		// Clean the path to normalize it (removes redundant separators, etc.)
@@ -490,35 +636,62 @@ func (wiki Wiki) cleanDestDir(ctx context.Context, relDestPaths map[string]bool)
 		// This should never happen with filepath.Walk, but adds a safety layer.
 		// After Clean(), a path escaping the dest dir would be ".." or start with "../".
 		if relDestPath == ".." || strings.HasPrefix(relDestPath, ".."+string(filepath.Separator)) {
-			util.PrintWarning("Skipping path that escapes dest dir: '%s' (from '%s')", relDestPath, destPath)
+			o.log.Warning("Skipping path that escapes dest dir: '%s' (from '%s')", relDestPath, destPath)
+			return nil
+		}
+
+		// Is this file regular and readable?
+		name := filepath.ToSlash(relDestPath)
+		if !isReadableFile(o.log, dirFS, name, info, destPath) {
+			// Warn if this is a symlink to a directory
+			warnIfSymlinkToDir(o.log, dirFS, name, info, destPath)
 			return nil
 		}
 
-		// Delete this file if it doesn't have a corresponding file in the source dir.
-		if !relDestPaths[relDestPath] {
-			util.PrintVerbose("Deleting '%s'", destPath)
+		// Delete this file if keep rejects it.
+		if !keep(name) && dryRun {
+			o.log.Verbose("Would delete '%s'", destPath)
+			gone[destPath] = true
+			deleted = append(deleted, name)
+		} else if !keep(name) {
+			o.log.Verbose("Deleting '%s'", destPath)
 			if err = os.Remove(destPath); err != nil {
-				util.PrintWarning("Failed to delete '%s': %v", destPath, err)
+				o.log.Warning("Failed to delete '%s': %v", destPath, err)
+			} else {
+				deleted = append(deleted, name)
 			}
 		}
 
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("cleaning destination failed: %v", err)
+		return deleted, fmt.Errorf("cleaning destination failed: %v", err)
 	}
 
 	// Check for cancellation before deleting empty directories
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return deleted, ctx.Err()
 	default:
 	}
 
 	// Delete empty directories.
-	if err := deleteEmptyDirectories(ctx, wiki.DestDir); err != nil {
-		return fmt.Errorf("failed to delete empty directories in '%s': %v", wiki.DestDir, err)
+	if err := deleteEmptyDirectories(ctx, o.log, o.dir, gone); err != nil {
+		return deleted, fmt.Errorf("failed to delete empty directories in '%s': %v", o.dir, err)
 	}
 
-	return nil
+	return deleted, nil
+}
+
+// cleanDestDir cleans the dest dir by any deleting files that don't have
+// a corresponding source file, and by deleting any empty directories. The
+// .gz siblings of files that are kept are kept too.
+func (wiki Wiki) cleanDestDir(ctx context.Context, relDestPaths map[string]bool) error {
+	deleted, err := wiki.out.Clean(ctx, func(name string) bool {
+		return relDestPaths[filepath.FromSlash(name)] || wiki.isGzipSibling(name, relDestPaths)
+	})
+	for _, name := range deleted {
+		wiki.result.addDeleted(name)
+	}
+	return err
 }
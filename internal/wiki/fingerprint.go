@@ -0,0 +1,200 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fingerprintExts are the extensions of the static files that are
+// fingerprinted.
+var fingerprintExts = []string{
+	".css", ".js", ".mjs",
+	".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".avif",
+	".woff", ".woff2",
+}
+
+// fingerprintLength is the number of hex digits of a file's hash that go in
+// its fingerprinted name.
+const fingerprintLength = 8
+
+// isFingerprintCandidate returns whether the static file name is
+// fingerprinted.
+func isFingerprintCandidate(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, fingerprintExt := range fingerprintExts {
+		if ext == fingerprintExt {
+			return true
+		}
+	}
+	return false
+}
+
+// fingerprintedName returns the slash-separated name with hash added before
+// its extension, so that style.css becomes style.3f9a1c2b.css.
+func fingerprintedName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash[:fingerprintLength] + ext
+}
+
+// assetFingerprints holds the fingerprinted names of the static files of a
+// wiki. It's shared by copies of the Wiki, so that files that haven't
+// changed aren't hashed again in watch mode.
+type assetFingerprints struct {
+	hashes  map[string]fileHash // Hashes of source files, by full path
+	names   map[string]string   // Fingerprinted dest paths for the current generate, by slash-separated content path
+	changed bool                // Whether any fingerprinted name changed since the last generate, so pages need regenerating
+}
+
+// fileHash is the hash of a file, as of when it had modTime and size.
+type fileHash struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+// fingerprint returns the fingerprinted dest path for the static file with
+// content path relPath, or relPath if it isn't fingerprinted.
+func (wiki Wiki) fingerprint(relPath string) string {
+	if wiki.assets == nil {
+		return relPath
+	}
+	if name, ok := wiki.assets.names[filepath.ToSlash(relPath)]; ok {
+		return filepath.FromSlash(name)
+	}
+	return relPath
+}
+
+// fingerprintsChanged returns whether any fingerprinted file has a name that
+// pages generated before may not link to.
+func (wiki Wiki) fingerprintsChanged() bool {
+	return wiki.assets != nil && wiki.assets.changed
+}
+
+// findFingerprints finds the fingerprinted names of the wiki's static files,
+// and of the embedded style sheets, for the generate about to start. If any
+// of them changed since the last generate, pages are regenerated, since up
+// to date pages would link to the old names. On the first generate, when
+// there are no names to compare with, a name has changed if it isn't in the
+// output yet, or its source is newer than it, as when a file is changed back
+// to contents it had before and its old fingerprinted copy wasn't cleaned.
+func (wiki *Wiki) findFingerprints(ctx context.Context) error {
+	if !wiki.Fingerprint {
+		wiki.assets = nil
+		return nil
+	}
+	if wiki.assets == nil {
+		wiki.assets = &assetFingerprints{hashes: make(map[string]fileHash)}
+	}
+	assets := wiki.assets
+	names := make(map[string]string)
+	found := make(map[string]bool)
+	infos := make(map[string]fs.FileInfo) // Info on the static files, by name
+
+	// Static files, found as they are by generateFromContent. Files that
+	// can't be read are left for it to report.
+	err := wiki.walkSource(wiki.ContentDir, func(contentPath string, entry fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return nil
+		}
+		if wiki.ignoreFile(contentPath, entry.IsDir()) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || isPathMarkdown(contentPath) || !isFingerprintCandidate(contentPath) {
+			return nil
+		}
+		info, err := wiki.statSource(contentPath)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		hash, ok := assets.hashes[contentPath]
+		if !ok || !hash.modTime.Equal(info.ModTime()) || hash.size != info.Size() {
+			sum, err := wiki.hashSource(contentPath)
+			if err != nil {
+				return nil
+			}
+			hash = fileHash{modTime: info.ModTime(), size: info.Size(), hash: sum}
+			assets.hashes[contentPath] = hash
+		}
+		found[contentPath] = true
+		relPath, err := filepath.Rel(wiki.ContentDir, contentPath)
+		if err != nil {
+			return nil
+		}
+		name := filepath.ToSlash(relPath)
+		names[name] = fingerprintedName(name, hash.hash)
+		infos[name] = info
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fingerprint static files: %v", err)
+	}
+
+	// Forget the hashes of files that are gone.
+	for contentPath := range assets.hashes {
+		if !found[contentPath] {
+			delete(assets.hashes, contentPath)
+		}
+	}
+
+	// Embedded style sheets, as they'll be written.
+	for _, file := range cssFiles {
+		css, err := wiki.embeddedCSS(file)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(css)
+		names[file] = fingerprintedName(file, hex.EncodeToString(sum[:]))
+	}
+
+	previous := assets.names
+	assets.names = names
+	assets.changed = false
+	if previous != nil {
+		if !maps.Equal(previous, names) {
+			wiki.log.Verbose("Fingerprinted names changed, so regenerating all pages")
+			assets.changed = true
+		}
+		return nil
+	}
+	for name, fingerprinted := range names {
+		_, err := wiki.out.Stat(fingerprinted)
+		info, isStatic := infos[name]
+		if err != nil || isStatic && !sourceIsOlder(info, wiki.out, fingerprinted) {
+			wiki.log.Verbose("Fingerprinted '%s' is new, so regenerating all pages", fingerprinted)
+			assets.changed = true
+			break
+		}
+	}
+	return nil
+}
+
+// hashSource returns the hex encoded SHA-256 hash of the source file at
+// path.
+func (wiki Wiki) hashSource(path string) (string, error) {
+	file, err := wiki.openSource(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,208 @@
+package wiki
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testFingerprint returns the fingerprinted name of name, with contents data.
+func testFingerprint(name, data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return fingerprintedName(name, hex.EncodeToString(sum[:]))
+}
+
+func TestFingerprintedName(t *testing.T) {
+	hash := "3f9a1c2b0123456789"
+	for name, want := range map[string]string{
+		"style.css":          "style.3f9a1c2b.css",
+		"img/logo.min.png":   "img/logo.min.3f9a1c2b.png",
+		"dir.with.dots/a.js": "dir.with.dots/a.3f9a1c2b.js",
+	} {
+		if got := fingerprintedName(name, hash); got != want {
+			t.Errorf("fingerprintedName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":        "![Logo](img/logo.png) [Notes](notes.txt)",
+		"Topics/Page.md":  "![Logo](../img/logo.png \"title\")",
+		"img/logo.png":    "logo v1",
+		"local.css":       "body { color: red }",
+		"notes.txt":       "not fingerprinted",
+		"Topics/About.md": "# About",
+	})
+	theWiki.Fingerprint = true
+	generate := func() *BuildResult {
+		t.Helper()
+		var result *BuildResult
+		theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+		if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		return result
+	}
+	readDest := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(destDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	generate()
+
+	logo := testFingerprint("img/logo.png", "logo v1")
+	localCSS := testFingerprint("local.css", "body { color: red }")
+	styleCSS, err := theWiki.embeddedCSS("style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	style := testFingerprint("style.css", string(styleCSS))
+	for _, name := range []string{logo, localCSS, style, "notes.txt", "img/logo.png", "local.css", "style.css"} {
+		readDest(name)
+	}
+
+	index := readDest("index.html")
+	for _, want := range []string{`src="` + logo + `"`, `href="notes.txt"`, `href="` + style + `"`, `href="` + localCSS + `"`} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index.html doesn't contain %q:\n%s", want, index)
+		}
+	}
+	page := readDest("Topics/Page.html")
+	for _, want := range []string{`src="../` + logo + `"`, `href="../` + style + `"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Topics/Page.html doesn't contain %q:\n%s", want, page)
+		}
+	}
+
+	// Nothing changed, so nothing is regenerated.
+	if result := generate(); len(result.Generated) != 0 || len(result.Copied) != 0 {
+		t.Errorf("Generated = %v, Copied = %v, want nothing", result.Generated, result.Copied)
+	}
+
+	// A changed image gets a new name, and pages are regenerated to link to
+	// it, while the old name is cleaned.
+	if err := os.WriteFile(filepath.Join(theWiki.ContentDir, "img", "logo.png"), []byte("logo v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result := generate()
+	if len(result.Generated) != 3 {
+		t.Errorf("Generated = %v, want all 3 pages", result.Generated)
+	}
+	newLogo := testFingerprint("img/logo.png", "logo v2")
+	if index := readDest("index.html"); !strings.Contains(index, `src="`+newLogo+`"`) {
+		t.Errorf("index.html doesn't link to %s:\n%s", newLogo, index)
+	}
+	if len(result.Deleted) != 1 || result.Deleted[0] != filepath.FromSlash(logo) {
+		t.Errorf("Deleted = %v, want [%s]", result.Deleted, logo)
+	}
+}
+
+func TestFingerprintPrettyLayout(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"Topics/Page.md":  "![Logo](logo.svg) [About](About.md)",
+		"Topics/About.md": "# About",
+		"Topics/logo.svg": "<svg/>",
+	})
+	theWiki.Fingerprint = true
+	theWiki.Layout = LayoutPretty
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	page, err := os.ReadFile(filepath.Join(destDir, "Topics", "Page", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logo := filepath.Base(testFingerprint("logo.svg", "<svg/>"))
+	for _, want := range []string{`src="../` + logo + `"`, `href="../About/"`, `href="../../style.`} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("Topics/Page/index.html doesn't contain %q:\n%s", want, page)
+		}
+	}
+}
+
+func TestFingerprintKeepsOriginalNames(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":     "<img src=\"img/logo.png\">\n\n![Logo](img/logo.png)",
+		"img/logo.png": "logo",
+		"img/bg.png":   "background",
+		"local.css":    "body { background: url(img/bg.png) }",
+	})
+	theWiki.Fingerprint = true
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// Raw HTML and url() in CSS aren't rewritten, so the files they refer
+	// to are kept under their original names, as well as the
+	// fingerprinted ones, even with clean.
+	for _, name := range []string{
+		"img/logo.png", testFingerprint("img/logo.png", "logo"),
+		"img/bg.png", testFingerprint("img/bg.png", "background"),
+		"local.css", testFingerprint("local.css", "body { background: url(img/bg.png) }"),
+		"style.css",
+	} {
+		if _, err := os.Stat(filepath.Join(destDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+	}
+	index, err := os.ReadFile(filepath.Join(destDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<img src="img/logo.png">`, `src="` + testFingerprint("img/logo.png", "logo") + `"`} {
+		if !strings.Contains(string(index), want) {
+			t.Errorf("index.html doesn't contain %q:\n%s", want, index)
+		}
+	}
+}
+
+func TestFingerprintRevert(t *testing.T) {
+	for _, newRun := range []bool{false, true} {
+		theWiki, destDir := includeTestWiki(t, map[string]string{
+			"index.md":    "![Logo](logo.png)",
+			"logo.png":    "logo A",
+			"Topics/B.md": "# B",
+		})
+		theWiki.Fingerprint = true
+		logoPath := filepath.Join(theWiki.ContentDir, "logo.png")
+		generate := func(logo string) {
+			t.Helper()
+			if err := os.WriteFile(logoPath, []byte(logo), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if newRun {
+				// Each build is a separate run of gomarkwiki.
+				var err error
+				if theWiki, err = NewWiki(theWiki.SourceDir, theWiki.DestDir, nil); err != nil {
+					t.Fatal(err)
+				}
+				theWiki.Fingerprint = true
+			}
+			if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+		}
+
+		// Changing the image from A to B and back to A, without -clean so
+		// that A's fingerprinted copy is still there, links the pages back
+		// to A's.
+		generate("logo A")
+		generate("logo B")
+		generate("logo A")
+		index, err := os.ReadFile(filepath.Join(destDir, "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := testFingerprint("logo.png", "logo A"); !strings.Contains(string(index), `src="`+want+`"`) {
+			t.Errorf("new run %v: index.html doesn't link to %s:\n%s", newRun, want, index)
+		}
+	}
+}
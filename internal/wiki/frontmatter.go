@@ -0,0 +1,195 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// frontMatterDelimiter starts and ends the front matter of a page.
+const frontMatterDelimiter = "---"
+
+// frontMatterKeys are the front matter keys that gomarkwiki uses. Lines
+// between two lines of --- at the start of a page are only front matter if
+// they have one of these keys, or if the page is passed to plugins, which can
+// use any keys. Otherwise they're Markdown, such as a horizontal rule
+// followed by a heading.
+var frontMatterKeys = []string{"aliases", "weight"}
+
+// frontMatter is the metadata at the start of a page, between two lines of
+// ---. It's a simple subset of YAML: each line is key: value, where the value
+// is a string, which can be quoted, or a list of strings written either as
+// [a, b] or as the following lines of - item. Blank lines and lines starting
+// with # are skipped. Values are strings or []string.
+type frontMatter map[string]any
+
+// String returns the string value of key, or "" if there's none.
+func (fm frontMatter) String(key string) string {
+	value, _ := fm[key].(string)
+	return value
+}
+
+// List returns the list value of key. A string value is returned as a list
+// of one.
+func (fm frontMatter) List(key string) []string {
+	switch value := fm[key].(type) {
+	case []string:
+		return value
+	case string:
+		return []string{value}
+	}
+	return nil
+}
+
+// splitPageFrontMatter splits the front matter from the start of data, the
+// contents of the markdown file at sourcePath, as splitFrontMatter does. Any
+// keys are accepted if the file is passed to plugins.
+func (wiki Wiki) splitPageFrontMatter(sourcePath string, data []byte) (frontMatter, []byte) {
+	anyKeys := false
+	if relPath, err := filepath.Rel(wiki.ContentDir, sourcePath); err == nil {
+		anyKeys = wiki.hasPlugins(filepath.ToSlash(relPath))
+	}
+	return splitFrontMatter(data, anyKeys)
+}
+
+// splitFrontMatter splits the front matter from the start of data, and
+// returns it parsed, along with the rest of data. If data doesn't start with
+// front matter, it returns nil and data. Lines between two lines of --- that
+// don't all parse as front matter, or that have none of the frontMatterKeys
+// unless anyKeys is set, aren't front matter, since Markdown can start with
+// a thematic break. A UTF-8 BOM at the start of data is removed either way.
+func splitFrontMatter(data []byte, anyKeys bool) (frontMatter, []byte) {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+
+	// Front matter starts with a line of ---, and ends with the next one.
+	first, rest, found := bytes.Cut(data, []byte("\n"))
+	if !found || string(bytes.TrimRight(first, " \t\r")) != frontMatterDelimiter {
+		return nil, data
+	}
+	var lines []string
+	for {
+		var line []byte
+		line, rest, found = bytes.Cut(rest, []byte("\n"))
+		text := strings.TrimRight(string(line), " \t\r")
+		if text == frontMatterDelimiter {
+			break
+		}
+		if !found {
+			// No closing line, so the --- was a thematic break and not front matter.
+			return nil, data
+		}
+		lines = append(lines, text)
+	}
+
+	fm, err := parseFrontMatter(lines)
+	if err != nil || !anyKeys && !fm.hasKnownKey() {
+		return nil, data
+	}
+	return fm, rest
+}
+
+// hasKnownKey returns whether fm has any of the frontMatterKeys.
+func (fm frontMatter) hasKnownKey() bool {
+	for _, key := range frontMatterKeys {
+		if _, ok := fm[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFrontMatter parses the lines of front matter.
+func parseFrontMatter(lines []string) (frontMatter, error) {
+	fm := make(frontMatter)
+	var listKey string             // Key of the block list being read, if any
+	blockKeys := map[string]bool{} // Keys with nothing after the colon, which may have block lists
+	for i, line := range lines {
+		lineNum := i + 2 // Counting the opening ---
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Items of a block list.
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok || trimmed == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("front matter line %d: list item without a key", lineNum)
+			}
+			value, err := unquoteFrontMatter(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("front matter line %d: %v", lineNum, err)
+			}
+			list, _ := fm[listKey].([]string)
+			fm[listKey] = append(list, value)
+			continue
+		}
+		listKey = ""
+
+		key, value, found := strings.Cut(trimmed, ":")
+		key = strings.TrimSpace(key)
+		if !found || key == "" || strings.ContainsAny(key, " \t\"'") {
+			return nil, fmt.Errorf("front matter line %d: expected key: value, got %q", lineNum, line)
+		}
+		if _, exists := fm[key]; exists {
+			return nil, fmt.Errorf("front matter line %d: duplicate key %q", lineNum, key)
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case value == "":
+			// A block list may follow.
+			fm[key] = []string{}
+			listKey = key
+			blockKeys[key] = true
+		case strings.HasPrefix(value, "["):
+			if !strings.HasSuffix(value, "]") {
+				return nil, fmt.Errorf("front matter line %d: list for %q isn't closed with ]", lineNum, key)
+			}
+			list := []string{}
+			if inner := strings.TrimSpace(value[1 : len(value)-1]); inner != "" {
+				for _, item := range strings.Split(inner, ",") {
+					item, err := unquoteFrontMatter(strings.TrimSpace(item))
+					if err != nil {
+						return nil, fmt.Errorf("front matter line %d: %v", lineNum, err)
+					}
+					list = append(list, item)
+				}
+			}
+			fm[key] = list
+		default:
+			unquoted, err := unquoteFrontMatter(value)
+			if err != nil {
+				return nil, fmt.Errorf("front matter line %d: %v", lineNum, err)
+			}
+			fm[key] = unquoted
+		}
+	}
+
+	// A key with nothing after it and no list items is an empty string.
+	for key := range blockKeys {
+		if len(fm[key].([]string)) == 0 {
+			fm[key] = ""
+		}
+	}
+	return fm, nil
+}
+
+// unquoteFrontMatter returns value with any quotes removed. Values in double
+// quotes can have Go-style escapes, and in values in single quotes a single
+// quote is written twice.
+func unquoteFrontMatter(value string) (string, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted value %s", value)
+		}
+		return unquoted, nil
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,107 @@
+package wiki
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantFM   frontMatter
+		wantRest string
+	}{
+		{"none", "# Title\n", nil, "# Title\n"},
+		{"bom", "\xEF\xBB\xBF# Title\n", nil, "# Title\n"},
+		{"strings", "---\ntitle: Hello\nauthor: \"A. Person\"\nnote: 'it''s'\n---\n# Title\n",
+			frontMatter{"title": "Hello", "author": "A. Person", "note": "it's"}, "# Title\n"},
+		{"lists", "---\ntags: [a, \"b c\"]\naliases:\n  - /old/\n  - other.html\nempty: []\n---\nBody",
+			frontMatter{"tags": []string{"a", "b c"}, "aliases": []string{"/old/", "other.html"}, "empty": []string{}}, "Body"},
+		{"empty value", "---\n# comment\n\nsummary:\n---\n", frontMatter{"summary": ""}, ""},
+		{"crlf", "---\r\ntitle: Hello\r\n---\r\nBody", frontMatter{"title": "Hello"}, "Body"},
+		{"not closed", "---\nNot front matter\n", nil, "---\nNot front matter\n"},
+
+		// Lines that don't all parse are Markdown, starting with a thematic break.
+		{"duplicate key", "---\na: 1\na: 2\n---\n", nil, "---\na: 1\na: 2\n---\n"},
+		{"no colon", "---\njust text\n---\n", nil, "---\njust text\n---\n"},
+		{"item without key", "---\n- item\n---\n", nil, "---\n- item\n---\n"},
+		{"unclosed list", "---\ntags: [a, b\n---\n", nil, "---\ntags: [a, b\n---\n"},
+		{"thematic breaks", "---\n\nSome prose.\n\n---\nMore", nil, "---\n\nSome prose.\n\n---\nMore"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm, rest := splitFrontMatter([]byte(tt.input), true)
+			if !reflect.DeepEqual(fm, tt.wantFM) {
+				t.Errorf("front matter = %#v, want %#v", fm, tt.wantFM)
+			}
+			if string(rest) != tt.wantRest {
+				t.Errorf("rest = %q, want %q", rest, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestSplitFrontMatterKnownKeys(t *testing.T) {
+	// Without any of the keys gomarkwiki uses, the lines are Markdown unless
+	// the page is passed to plugins.
+	input := "---\nNote: foo\n---\nBody"
+	if fm, rest := splitFrontMatter([]byte(input), false); fm != nil || string(rest) != input {
+		t.Errorf("splitFrontMatter(%q) = %#v, %q, want no front matter", input, fm, rest)
+	}
+	if fm, _ := splitFrontMatter([]byte(input), true); !reflect.DeepEqual(fm, frontMatter{"Note": "foo"}) {
+		t.Errorf("splitFrontMatter(%q) with any keys = %#v, want Note: foo", input, fm)
+	}
+
+	input = "---\ntitle: Hello\nweight: 2\n---\nBody"
+	if fm, rest := splitFrontMatter([]byte(input), false); !reflect.DeepEqual(fm, frontMatter{"title": "Hello", "weight": "2"}) || string(rest) != "Body" {
+		t.Errorf("splitFrontMatter(%q) = %#v, %q, want title and weight", input, fm, rest)
+	}
+}
+
+func TestFrontMatterAccessors(t *testing.T) {
+	fm := frontMatter{"title": "Hello", "tags": []string{"a", "b"}}
+	if got := fm.String("title"); got != "Hello" {
+		t.Errorf("String(title) = %q, want Hello", got)
+	}
+	if got := fm.String("tags"); got != "" {
+		t.Errorf("String(tags) = %q, want empty", got)
+	}
+	if got := fm.List("title"); !reflect.DeepEqual(got, []string{"Hello"}) {
+		t.Errorf("List(title) = %q, want [Hello]", got)
+	}
+	if got := fm.List("missing"); got != nil {
+		t.Errorf("List(missing) = %q, want nil", got)
+	}
+}
+
+func TestPageStartingWithThematicBreak(t *testing.T) {
+	source := fstest.MapFS{
+		"content/index.md": {Data: []byte("---\n\nSome prose.\n\n---\n\n{{include: Part.md}}\n")},
+		"content/Part.md":  {Data: []byte("---\nIncluded: prose, with a colon.\nAnd more\n---\n\n{{include: Note.md}}\n")},
+		"content/Note.md":  {Data: []byte("---\nNote: foo\n---\n")},
+	}
+	out := NewMemOutput()
+	theWiki, err := NewWikiFS(source, out, util.DiscardLogger())
+	if err != nil {
+		t.Fatalf("NewWikiFS failed: %v", err)
+	}
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	index, err := out.ReadFile("index.html")
+	if err != nil {
+		t.Fatalf("index.html wasn't generated: %v", err)
+	}
+	html := string(index)
+	for _, want := range []string{"<hr>", "<p>Some prose.</p>", "Included: prose, with a colon.", ">Note: foo</h2>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("index.html doesn't contain %q:\n%s", want, html)
+		}
+	}
+}
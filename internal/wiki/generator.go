@@ -4,13 +4,12 @@ package wiki
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
-	"os"
 	"path/filepath"
 	"strings"
-
-	"github.com/stalexan/gomarkwiki/internal/util"
 )
 
 // markdownExts specifies markdown file extensions.
@@ -84,18 +83,18 @@ func checkForStyleDirective(data []byte) (bool, []byte) {
 // relDestPath is the relative destination path (e.g., "Foo/Bar.html") that was
 // already computed for collision detection in the caller.
 func (wiki Wiki) generateHtmlFromMarkdown(ctx context.Context, mdPath, mdRelPath, relDestPath string, regen bool, version string) (string, error) {
-	// Compute the full output path. For example, if relDestPath is Foo/Bar.html
+	// Compute the output path. For example, if relDestPath is Foo/Bar.html
 	// and the destination directory (destDir) is /wiki-html, the output path is /wiki-html/Foo/Bar.html.
-	outPath := filepath.Join(wiki.DestDir, relDestPath)
-	outDir := filepath.Dir(outPath)
+	outName := filepath.ToSlash(relDestPath)
+	outPath := wiki.destPath(relDestPath)
 
 	// Re-stat the file to get current info and prevent TOCTOU issues.
 	// This must happen BEFORE the skip decision to avoid a race condition where the file
 	// changes between the Walk and the regeneration decision.
-	currentInfo, err := os.Stat(mdPath)
+	currentInfo, err := wiki.statSource(mdPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			util.PrintVerbose("markdown '%s' no longer exists and so no HTML will be generated for it", mdPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			wiki.log.Verbose("markdown '%s' no longer exists and so no HTML will be generated for it", mdPath)
 			return "", nil
 		} else {
 			return "", fmt.Errorf("failed to stat markdown file '%s': %v", mdPath, err)
@@ -107,34 +106,40 @@ func (wiki Wiki) generateHtmlFromMarkdown(ctx context.Context, mdPath, mdRelPath
 		return "", fmt.Errorf("markdown file '%s' is too large (%d bytes, max %d bytes)", mdPath, currentInfo.Size(), MaxMarkdownFileSize)
 	}
 
-	// Skip generating the HTML if markdown is older than current HTML.
-	// Use currentInfo (not mdInfo) to ensure we have the latest modification time.
-	if !regen && sourceIsOlder(currentInfo, outPath) {
+	// Skip generating the HTML if markdown, and any files it includes, are
+	// older than current HTML. Use currentInfo (not mdInfo) to ensure we have
+	// the latest modification time. Pages are also regenerated when a
+	// fingerprinted file they might link to has a new name.
+	if !regen && !wiki.stalePages[mdPath] && !wiki.fingerprintsChanged() && sourceIsOlder(currentInfo, wiki.out, outName) && !wiki.includesChangedSince(mdPath, currentInfo, outName) {
+		wiki.result.addSkipped(relDestPath)
 		return relDestPath, nil
 	}
-	util.PrintVerbose("Generating '%s'", outPath)
+	wiki.log.Verbose("Generating '%s'", outPath)
 
 	// Read markdown file.
 	var data []byte
-	if data, err = os.ReadFile(mdPath); err != nil {
-		if os.IsNotExist(err) {
-			util.PrintVerbose("markdown '%s' no longer exists and so no HTML will be generated for it", mdPath)
+	if data, err = wiki.readSource(mdPath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			wiki.log.Verbose("markdown '%s' no longer exists and so no HTML will be generated for it", mdPath)
 			return "", nil
 		} else {
 			return "", fmt.Errorf("failed to read markdown file '%s': %v", mdPath, err)
 		}
 	}
 
-	// Check for style directive.
-	useGitHubStyle, data := checkForStyleDirective(data)
-
-	// Make substitutions.
-	data = wiki.makeSubstitutions(data)
+	// Render the body of the page.
+	rendered, err := wiki.renderPage(ctx, mdPath, mdRelPath, currentInfo, data, version, func(markdown []byte, w io.Writer) error {
+		return wiki.convert(markdown, w, wiki.newLinkRewriter(mdRelPath, relDestPath))
+	})
+	if err != nil {
+		return "", err
+	}
 
 	// Determine relative path from the file being generated to the dest dir. For
 	// example if the file being generated is /wiki-html/Foo/Bar.html and the
-	// dest dir is /wiki-html, the relative path is ../
-	relPathJustDir := filepath.Dir(mdRelPath)
+	// dest dir is /wiki-html, the relative path is ../ (or ../../ for
+	// /wiki-html/Foo/Bar/index.html in the pretty layout).
+	relPathJustDir := filepath.Dir(relDestPath)
 	dirCount := 0
 	if relPathJustDir != "." {
 		dirCount = strings.Count(relPathJustDir, string(filepath.Separator)) + 1
@@ -143,53 +148,105 @@ func (wiki Wiki) generateHtmlFromMarkdown(ctx context.Context, mdPath, mdRelPath
 
 	// Generate the start of the HTML file using the template htmlHeaderTemplate.
 	html := &strings.Builder{}
-	// Extract title from file path. The html/template package automatically escapes
-	// all template variables (including this title) to prevent XSS attacks, so
-	// special characters in file paths are safely handled.
-	relPathNoExt := removeFileExtension(relDestPath) // Remove .html extension for title
-	title := filepath.Base(relPathNoExt)             // Markdown file name without file extension
-	if useGitHubStyle {
-		if err = githubHtmlHeaderTemplate.Execute(html, templateData{title, version, rootRelPath}); err != nil {
+	// The html/template package automatically escapes all template variables
+	// (including the title) to prevent XSS attacks, so special characters in
+	// file paths are safely handled.
+	if rendered.useGitHubStyle {
+		if err = wiki.headerTemplate(true).Execute(html, wiki.newTemplateData(rendered.title, version, rootRelPath)); err != nil {
 			return "", fmt.Errorf("failed to create GitHub HTML header for '%s': %v", outPath, err)
 		}
 	} else {
-		if err = defaultHtmlHeaderTemplate.Execute(html, templateData{title, version, rootRelPath}); err != nil {
+		if err = wiki.headerTemplate(false).Execute(html, wiki.newTemplateData(rendered.title, version, rootRelPath)); err != nil {
 			return "", fmt.Errorf("failed to create default HTML header for '%s': %v", outPath, err)
 		}
 	}
 
-	// Generate the body of the HTML from markdown.
-	if err = markdown.Convert(data, html); err != nil {
-		return "", fmt.Errorf("failed to generate HTML body for '%s': %v", outPath, err)
-	}
+	// Add the body, and generate end of HTML file.
+	html.WriteString(rendered.body)
+	html.WriteString(htmlFooter(rendered.useGitHubStyle))
 
-	// Generate end of HTML file.
-	if useGitHubStyle {
-		html.WriteString("</article>\n</body>\n</html>")
-	} else {
-		html.WriteString("</body>\n</html>")
+	output := []byte(html.String())
+	if wiki.Minify {
+		output = wiki.minify(outName, output, minifyHTML)
 	}
 
-	// Create output directory if necessary.
-	// Use ensureDirectoryPath to handle conflicts where a file exists in the path.
-	if err = ensureDirectoryPath(outDir); err != nil {
-		return "", err
+	// Write out the HTML file. Outputs never leave a file partially written,
+	// preventing corruption from crashes or interruptions.
+	if err := wiki.out.WriteFile(ctx, outName, bytes.NewReader(output), 0644); err != nil {
+		return "", fmt.Errorf("failed to write HTML file '%s': %v", outPath, err)
 	}
+	wiki.result.addGenerated(relDestPath)
 
-	// Remove any conflicting directory at the output path.
-	// This handles the case where source structure changed from directory to file.
-	if err = removeConflictingDir(outPath); err != nil {
-		return "", err
-	}
+	return relDestPath, nil
+}
 
-	// Write out the HTML file using atomic write semantics.
-	// This ensures the file is either fully written or unchanged, preventing
-	// corruption from crashes or interruptions (same as copyToFile for static files).
-	if err := copyToFile(ctx, outPath, strings.NewReader(html.String()), 0644); err != nil {
-		return "", fmt.Errorf("failed to write HTML file '%s': %v", outPath, err)
+// renderedPage is a markdown page rendered to the HTML of its body.
+type renderedPage struct {
+	title          string // Markdown file name without file extension
+	frontMatter    frontMatter
+	useGitHubStyle bool
+	body           string
+}
+
+// renderPage renders data, the contents of the markdown file mdPath with
+// content path mdRelPath and file info info, to the HTML of its body. It
+// expands includes, makes substitutions, and runs plugins, recording what the
+// page depends on, and then converts the markdown with convert unless a
+// plugin returned the body.
+func (wiki Wiki) renderPage(ctx context.Context, mdPath, mdRelPath string, info fs.FileInfo, data []byte, version string, convert func(markdown []byte, w io.Writer) error) (renderedPage, error) {
+	// Split off any front matter, and check for style directive.
+	fm, data := wiki.splitPageFrontMatter(mdPath, data)
+	useGitHubStyle, data := checkForStyleDirective(data)
+
+	// Expand include directives, and record the files included and the
+	// placeholders used so that changes to them regenerate this page.
+	data, includedFiles, err := wiki.expandIncludes(data, mdPath)
+	if err != nil {
+		return renderedPage{}, err
+	}
+	wiki.deps.record(mdPath, info, wiki.findDependencies(mdRelPath, fm, data, includedFiles))
+
+	// Extract title from file path.
+	title := filepath.Base(removeFileExtension(mdRelPath)) // Markdown file name without file extension
+
+	// Make substitutions, including the built-in variables for this page, and
+	// warn about any placeholders that aren't defined.
+	page := &pageContext{
+		title:     title,
+		relPath:   filepath.ToSlash(mdRelPath),
+		modTime:   info.ModTime(),
+		buildTime: wiki.buildTime,
+		version:   version,
+	}
+	var undefined map[string]bool
+	data, undefined = wiki.makeSubstitutions(data, page)
+	wiki.warnUndefinedPlaceholders(undefined, append([]string{mdPath}, includedFiles...))
+
+	// Pass the markdown through the plugins for this page, if any. A plugin
+	// can return the HTML body instead, in which case it isn't rendered.
+	markdown, body, err := wiki.runPlugins(ctx, pluginRequest{
+		Path:        page.relPath,
+		SourcePath:  mdPath,
+		Title:       title,
+		FrontMatter: fm,
+		Markdown:    string(data),
+	})
+	if err != nil {
+		return renderedPage{}, err
 	}
 
-	return relDestPath, nil
+	// Generate the body of the HTML from markdown.
+	rendered := renderedPage{title: title, frontMatter: fm, useGitHubStyle: useGitHubStyle}
+	if body != nil {
+		rendered.body = *body
+	} else {
+		html := &strings.Builder{}
+		if err = convert([]byte(markdown), html); err != nil {
+			return renderedPage{}, fmt.Errorf("failed to generate HTML body for '%s': %v", mdPath, err)
+		}
+		rendered.body = html.String()
+	}
+	return rendered, nil
 }
 
 // generateFromContent generates the part of the wiki that comes from the source content.
@@ -200,19 +257,22 @@ func (wiki Wiki) generateHtmlFromMarkdown(ctx context.Context, mdPath, mdRelPath
 //   - Markdown-markdown collisions (e.g., "foo.md" and "foo.markdown" both generate "foo.html")
 //   - Static-markdown collisions (e.g., static "foo.html" and "foo.md" generating "foo.html")
 //
-// The ordering is deterministic because filepath.Walk processes files in lexicographic
-// order (guaranteed by Go 1.16+), ensuring consistent collision resolution
+// The ordering is deterministic because fs.WalkDir processes files in lexicographic
+// order, ensuring consistent collision resolution
 // across regeneration cycles in watch mode.
 func (wiki Wiki) generateFromContent(ctx context.Context, regen bool, version string) (map[string]bool, error) {
 	// Walk the source directory and generate the wiki from the files found.
-	util.PrintDebug("Generating wiki '%s' from '%s'", wiki.DestDir, wiki.SourceDir)
+	wiki.log.Debug("Generating wiki '%s' from '%s'", wiki.DestDir, wiki.SourceDir)
 	relDestPaths := map[string]bool{}
 	sourceFileMap := map[string]string{} // Track which source file claimed each dest path (for collision detection)
+	destDirMap := map[string]string{}    // Track which source file first needed each dest dir (for collision detection)
+	pagePaths := map[string]bool{}       // Track markdown pages seen, to forget the includes of deleted pages
+	var aliasPages []pageAliases         // Pages with aliases, to generate redirects for once all pages have claimed their paths
+	images := map[string]staticImage{}   // Resizable images copied, by slash-separated content path, to generate variants of those pages show
 	fileCount := 0
 	allFilesEncountered := 0 // Track ALL files encountered, including errors
-	baseDepth := strings.Count(wiki.ContentDir, string(filepath.Separator))
 	var processingErrors []error
-	err := filepath.Walk(wiki.ContentDir, func(contentPath string, info fs.FileInfo, err error) error {
+	err := wiki.walkSource(wiki.ContentDir, func(contentPath string, entry fs.DirEntry, err error) error {
 		// Check for cancellation periodically during walk
 		select {
 		case <-ctx.Done():
@@ -229,38 +289,44 @@ func (wiki Wiki) generateFromContent(ctx context.Context, regen bool, version st
 
 		// Check recursion depth
 		// Note: This depth check is based on the path within the content directory tree,
-		// not on any symlink targets. This is correct because filepath.Walk uses os.Lstat
-		// internally and never follows symlinks to directories (they are detected and skipped
-		// by isReadableFile/warnIfSymlinkToDir). This prevents symlink-based bypass of the
+		// not on any symlink targets. This is correct because fs.WalkDir never follows
+		// symlinks to directories (they are detected and skipped by
+		// isReadableFile/warnIfSymlinkToDir). This prevents symlink-based bypass of the
 		// depth limit while still allowing symlinks to files in deep system paths.
-		currentDepth := strings.Count(contentPath, string(filepath.Separator)) - baseDepth
+		currentDepth := sourceDepth(wiki.ContentDir, contentPath)
 		if currentDepth > MaxRecursionDepth {
 			return fmt.Errorf("directory recursion depth exceeded at '%s' (depth %d, max %d)", contentPath, currentDepth, MaxRecursionDepth)
 		}
 
 		// Was there an error looking up this file?
+		var info fs.FileInfo
+		if err == nil {
+			info, err = entry.Info()
+		}
 		if err != nil {
-			util.PrintError(err, "failed to lookup info on '%s'", contentPath)
+			wiki.log.Error(err, "failed to lookup info on '%s'", contentPath)
 			// Cap error collection to prevent OOM from massive error accumulation
 			if len(processingErrors) < MaxProcessingErrors {
 				processingErrors = append(processingErrors, fmt.Errorf("failed to lookup info on '%s': %w", contentPath, err))
 			}
+			wiki.result.addError(contentPath, err)
 			return nil
 		}
 
 		// Ignore this file or directory? (Must check BEFORE isReadableFile to handle directories)
 		if wiki.ignoreFile(contentPath, info.IsDir()) {
-			util.PrintVerbose("Ignoring '%s'", contentPath)
+			wiki.log.Verbose("Ignoring '%s'", contentPath)
 			if info.IsDir() {
-				return filepath.SkipDir // Don't descend into ignored directories
+				return fs.SkipDir // Don't descend into ignored directories
 			}
 			return nil
 		}
 
 		// Is this file regular and readable?
-		if !isReadableFile(info, contentPath) {
+		name, _ := wiki.sourceName(contentPath)
+		if !isReadableFile(wiki.log, wiki.source, name, info, contentPath) {
 			// Warn if this is a symlink to a directory
-			warnIfSymlinkToDir(info, contentPath)
+			warnIfSymlinkToDir(wiki.log, wiki.source, name, info, contentPath)
 			return nil
 		}
 
@@ -274,67 +340,81 @@ func (wiki Wiki) generateFromContent(ctx context.Context, regen bool, version st
 		var relContentPath string
 		relContentPath, err = filepath.Rel(wiki.ContentDir, contentPath)
 		if err != nil {
-			util.PrintError(err, "failed to find relative path of '%s' given '%s'", contentPath, wiki.ContentDir)
+			wiki.log.Error(err, "failed to find relative path of '%s' given '%s'", contentPath, wiki.ContentDir)
 			// Cap error collection to prevent OOM from massive error accumulation
 			if len(processingErrors) < MaxProcessingErrors {
 				processingErrors = append(processingErrors, fmt.Errorf("failed to find relative path of '%s': %w", contentPath, err))
 			}
+			wiki.result.addError(contentPath, err)
 			return nil
 		}
 
 		// Create the dest version of this file.
+		defer wiki.result.timeFile(relContentPath)()
 		var relDestPath string
 		if isPathMarkdown(contentPath) {
 			// Determine the output path for the HTML file.
-			relPathNoExt := removeFileExtension(relContentPath)
-			relDestPath = relPathNoExt + ".html"
+			relDestPath = wiki.pageDestPath(removeFileExtension(relContentPath))
 
 			// Check for collision with previously processed files (static or markdown).
 			// This catches both markdown-markdown collisions (e.g., "foo.md" vs "foo.markdown")
-			// and static-markdown collisions (e.g., "foo.html" vs "foo.md").
-			// Collision determinism: filepath.Walk guarantees lexicographic order by full path
-			// (since Go 1.16+), so the lexicographically first source file wins.
-			if existingSource, collision := sourceFileMap[relDestPath]; collision {
-				util.PrintWarning("Skipping '%s': would generate '%s' which is already claimed by '%s'", relContentPath, relDestPath, existingSource)
+			// and static-markdown collisions (e.g., "foo.html" vs "foo.md"), and in the
+			// pretty layout, pages whose directories are files (e.g., static "foo" vs "foo.md").
+			// Collision determinism: fs.WalkDir guarantees lexicographic order by full path,
+			// so the lexicographically first source file wins.
+			if existingSource, collision := findDestCollision(relDestPath, sourceFileMap, destDirMap); collision {
+				wiki.log.Warning("Skipping '%s': would generate '%s' which is already claimed by '%s'", relContentPath, relDestPath, existingSource)
+				wiki.result.addCollision(relDestPath, relContentPath, existingSource)
 				return nil
 			}
 
 			// Generate HTML from markdown.
+			pagePaths[contentPath] = true
 			relDestPath, err = wiki.generateHtmlFromMarkdown(ctx, contentPath, relContentPath, relDestPath, regen, version)
 			if err != nil {
-				util.PrintError(err, "failed to generate HTML for '%s'", contentPath)
+				wiki.log.Error(err, "failed to generate HTML for '%s'", contentPath)
 				// Cap error collection to prevent OOM from massive error accumulation
 				if len(processingErrors) < MaxProcessingErrors {
 					processingErrors = append(processingErrors, fmt.Errorf("failed to generate HTML for '%s': %w", contentPath, err))
 				}
+				wiki.result.addError(contentPath, err)
 				// Note: relDestPath is always "" on error, so we can't record it for per-file protection.
 				// Existing output files are protected by the macro-level check (processingErr != nil)
 				// which skips cleaning entirely when ANY errors occur.
 				return nil
 			}
 
-			// Record the source file that generated this HTML path.
+			// Record the source file that generated this HTML path, and any
+			// aliases it has.
 			if relDestPath != "" {
-				sourceFileMap[relDestPath] = relContentPath
+				claimDestPath(relDestPath, relContentPath, sourceFileMap, destDirMap)
+				if deps, err := wiki.pageDependenciesFor(contentPath, info); err == nil && len(deps.aliases) > 0 {
+					aliasPages = append(aliasPages, pageAliases{contentPath, relContentPath, relDestPath, deps.aliases})
+				}
 			}
 		} else {
-			// This is not a markdown file. Just copy it.
-			relDestPath = relContentPath
+			// This is not a markdown file. Just copy it, under its
+			// fingerprinted name if it has one.
+			relDestPath = wiki.fingerprint(relContentPath)
 
 			// Check for collision with previously processed files.
-			// This catches static-static collisions (shouldn't happen with unique filenames)
-			// and provides the source info if a later markdown file tries to overwrite.
-			if existingSource, collision := sourceFileMap[relDestPath]; collision {
-				util.PrintWarning("Skipping '%s': destination '%s' is already claimed by '%s'", relContentPath, relDestPath, existingSource)
+			// This catches static-static collisions (shouldn't happen with unique filenames),
+			// static files in the way of page directories in the pretty layout (e.g., "foo.md"
+			// vs static "foo/index.html"), and provides the source info if a later markdown
+			// file tries to overwrite.
+			if existingSource, collision := findDestCollision(relDestPath, sourceFileMap, destDirMap); collision {
+				wiki.log.Warning("Skipping '%s': destination '%s' is already claimed by '%s'", relContentPath, relDestPath, existingSource)
+				wiki.result.addCollision(relDestPath, relContentPath, existingSource)
 				return nil
 			}
 
-			if err := wiki.copyFileToDest(ctx, contentPath, relContentPath, regen); err != nil {
-				util.PrintError(err, "failed to copy '%s' to dest", contentPath)
+			if err := wiki.copyFileToDest(ctx, contentPath, relDestPath, regen); err != nil {
+				wiki.log.Error(err, "failed to copy '%s' to dest", contentPath)
 				// Cap error collection to prevent OOM from massive error accumulation
 				if len(processingErrors) < MaxProcessingErrors {
 					processingErrors = append(processingErrors, fmt.Errorf("failed to copy '%s': %w", contentPath, err))
 				}
+				wiki.result.addError(contentPath, err)
 				// Still record the destination path to prevent deletion of existing output file.
 				// This is critical when using -clean flag to avoid deleting valid files on transient errors.
 				if relDestPath != "" {
@@ -344,7 +424,32 @@ func (wiki Wiki) generateFromContent(ctx context.Context, regen bool, version st
 			}
 
 			// Record the source file that claimed this dest path.
-			sourceFileMap[relDestPath] = relContentPath
+			claimDestPath(relDestPath, relContentPath, sourceFileMap, destDirMap)
+
+			// Keep a copy under the original name too, for references that
+			// aren't rewritten, such as in raw HTML or in url() in CSS.
+			if relDestPath != relContentPath {
+				if existingSource, collision := findDestCollision(relContentPath, sourceFileMap, destDirMap); collision {
+					wiki.log.Warning("Skipping '%s': destination '%s' is already claimed by '%s'", relContentPath, relContentPath, existingSource)
+					wiki.result.addCollision(relContentPath, relContentPath, existingSource)
+				} else {
+					if err := wiki.copyFileToDest(ctx, contentPath, relContentPath, regen); err != nil {
+						wiki.log.Error(err, "failed to copy '%s' to dest", contentPath)
+						if len(processingErrors) < MaxProcessingErrors {
+							processingErrors = append(processingErrors, fmt.Errorf("failed to copy '%s': %w", contentPath, err))
+						}
+						wiki.result.addError(contentPath, err)
+					}
+					claimDestPath(relContentPath, relContentPath, sourceFileMap, destDirMap)
+					relDestPaths[relContentPath] = true
+				}
+			}
+
+			// Downscaled variants are written once all pages are found,
+			// for the images they show.
+			if wiki.images != nil && isResizableImage(relContentPath) {
+				images[filepath.ToSlash(relContentPath)] = staticImage{contentPath, relContentPath, relDestPath}
+			}
 		}
 
 		// Record that this file corresponds to a file from the source dir.
@@ -357,6 +462,21 @@ func (wiki Wiki) generateFromContent(ctx context.Context, regen bool, version st
 	if err != nil {
 		return nil, fmt.Errorf("generate destination content failed: %v", err)
 	}
+	wiki.deps.setPages(pagePaths)
+
+	// Generate the variants of the images that pages show.
+	for _, err := range wiki.generateShownImageVariants(ctx, images, pagePaths, sourceFileMap, destDirMap, relDestPaths, regen) {
+		if len(processingErrors) < MaxProcessingErrors {
+			processingErrors = append(processingErrors, err)
+		}
+	}
+
+	// Generate redirects for the aliases of pages.
+	for _, err := range wiki.generateRedirects(ctx, aliasPages, sourceFileMap, destDirMap, relDestPaths, regen, version) {
+		if len(processingErrors) < MaxProcessingErrors {
+			processingErrors = append(processingErrors, err)
+		}
+	}
 
 	// Return collected processing errors if any occurred
 	if len(processingErrors) > 0 {
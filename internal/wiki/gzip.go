@@ -0,0 +1,161 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultGzipLevel is the compression level of .gz siblings when
+// Wiki.GzipLevel isn't set. They're compressed once and served many times,
+// so it's worth compressing them as much as possible.
+const DefaultGzipLevel = gzip.BestCompression
+
+// gzipExts are the extensions of the files that get .gz siblings.
+var gzipExts = []string{".html", ".htm", ".css", ".js", ".mjs", ".svg", ".json"}
+
+// isGzipCandidate returns whether the file name, if it's big enough, gets a
+// .gz sibling.
+func isGzipCandidate(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, gzipExt := range gzipExts {
+		if ext == gzipExt {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipLevel returns the wiki's GzipLevel, with DefaultGzipLevel for the
+// default.
+func (wiki Wiki) gzipLevel() int {
+	if wiki.GzipLevel == 0 {
+		return DefaultGzipLevel
+	}
+	return wiki.GzipLevel
+}
+
+// isGzipSibling returns whether the file name in the dest dir is the .gz
+// sibling of a file in relDestPaths, the files generated from the source
+// dir, so that it's kept by clean.
+func (wiki Wiki) isGzipSibling(name string, relDestPaths map[string]bool) bool {
+	if !wiki.Gzip {
+		return false
+	}
+	primary, ok := strings.CutSuffix(name, ".gz")
+	if !ok || !isGzipCandidate(primary) || !relDestPaths[filepath.FromSlash(primary)] {
+		return false
+	}
+	info, err := wiki.out.Stat(primary)
+	return err == nil && info.Size() >= wiki.GzipMinSize
+}
+
+// gzipOutput wraps the Output of a wiki to write a gzip compressed sibling,
+// named with .gz added, next to each HTML, CSS, JavaScript, SVG, and JSON
+// file that's at least minSize bytes, for web servers that serve
+// precompressed files. A file whose sibling is missing or older than it is
+// reported by Stat as out of date, so that both are written again.
+type gzipOutput struct {
+	out     Output
+	level   int
+	minSize int64
+}
+
+// Stat returns info on the file name in the wrapped output, with a zero
+// modification time if its .gz sibling isn't up to date.
+func (o *gzipOutput) Stat(name string) (fs.FileInfo, error) {
+	info, err := o.out.Stat(name)
+	if err != nil || !isGzipCandidate(name) || info.Size() < o.minSize {
+		return info, err
+	}
+	sibling, err := o.out.Stat(name + ".gz")
+	if err != nil || sibling.ModTime().Before(info.ModTime()) {
+		return staleFileInfo{info}, nil
+	}
+	return info, nil
+}
+
+// staleFileInfo is the info of a file that's out of date because its .gz
+// sibling is, which has a zero modification time so that it's older than
+// any source.
+type staleFileInfo struct {
+	fs.FileInfo
+}
+
+func (staleFileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+// WriteFile writes the contents of r to the file name in the wrapped output,
+// followed by its .gz sibling if it gets one.
+func (o *gzipOutput) WriteFile(ctx context.Context, name string, r io.Reader, mode fs.FileMode) error {
+	if !isGzipCandidate(name) {
+		return o.out.WriteFile(ctx, name, r, mode)
+	}
+	data, err := readAll(ctx, r)
+	if err != nil {
+		return err
+	}
+	if err := o.out.WriteFile(ctx, name, bytes.NewReader(data), mode); err != nil {
+		return err
+	}
+	return o.writeSibling(ctx, name, data, mode)
+}
+
+// writeSibling writes data, the contents of the file name, compressed to its
+// .gz sibling if it's big enough, or otherwise deletes any sibling left from
+// when it was, so that it isn't served in place of the file. The sibling is
+// written after the file, so that it's newer.
+func (o *gzipOutput) writeSibling(ctx context.Context, name string, data []byte, mode fs.FileMode) error {
+	if int64(len(data)) < o.minSize {
+		if remover, ok := o.out.(fileRemover); ok {
+			return remover.removeFile(name + ".gz")
+		}
+		return nil
+	}
+	var compressed bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&compressed, o.level)
+	if err != nil {
+		return fmt.Errorf("failed to compress '%s': %v", name, err)
+	}
+	if _, err := zw.Write(data); err != nil {
+		return fmt.Errorf("failed to compress '%s': %v", name, err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress '%s': %v", name, err)
+	}
+	return o.out.WriteFile(ctx, name+".gz", &compressed, mode)
+}
+
+// Clean cleans the wrapped output. Siblings are kept by the keep function of
+// cleanDestDir.
+func (o *gzipOutput) Clean(ctx context.Context, keep func(name string) bool) ([]string, error) {
+	return o.out.Clean(ctx, keep)
+}
+
+// linkFile links the file name with the wrapped output, if it can, and then
+// writes its .gz sibling from the source file.
+func (o *gzipOutput) linkFile(ctx context.Context, name, sourcePath string, mode fs.FileMode) (bool, error) {
+	linker, ok := o.out.(fileLinker)
+	if !ok {
+		return false, nil
+	}
+	linked, err := linker.linkFile(ctx, name, sourcePath, mode)
+	if err != nil || !linked || !isGzipCandidate(name) {
+		return linked, err
+	}
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return true, fmt.Errorf("failed to read '%s': %v", sourcePath, err)
+	}
+	return true, o.writeSibling(ctx, name, data, mode)
+}
@@ -0,0 +1,145 @@
+package wiki
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readGzip returns the decompressed contents of the gzip file at path.
+func readGzip(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("%s isn't gzip compressed: %v", path, err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestGzip(t *testing.T) {
+	big := strings.Repeat("Some text. ", 200)
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":    "# Index\n\n" + big,
+		"small.md":    "# Small",
+		"app.js":      big,
+		"image.png":   big,
+		"data/x.json": "[]",
+	})
+	theWiki.Gzip = true
+	theWiki.GzipMinSize = 1024
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for _, name := range []string{"index.html", "app.js", "style.css"} {
+		data, err := os.ReadFile(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readGzip(t, filepath.Join(destDir, name+".gz")); got != string(data) {
+			t.Errorf("%s.gz doesn't match %s", name, name)
+		}
+	}
+	for _, name := range []string{"small.html", "image.png", "data/x.json"} {
+		if _, err := os.Stat(filepath.Join(destDir, name+".gz")); err == nil {
+			t.Errorf("%s.gz written, want none", name)
+		}
+	}
+
+	// A missing sibling is written again, along with its file, and siblings
+	// are kept by clean while stray .gz files aren't.
+	if err := os.Remove(filepath.Join(destDir, "app.js.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "stray.html.gz"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var result *BuildResult
+	theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(result.Copied) != 1 || result.Copied[0] != "app.js" {
+		t.Errorf("Copied = %v, want [app.js]", result.Copied)
+	}
+	if len(result.Generated) != 0 {
+		t.Errorf("Generated = %v, want nothing", result.Generated)
+	}
+	if len(result.Deleted) != 1 || result.Deleted[0] != "stray.html.gz" {
+		t.Errorf("Deleted = %v, want [stray.html.gz]", result.Deleted)
+	}
+	if got := readGzip(t, filepath.Join(destDir, "app.js.gz")); got != big {
+		t.Errorf("app.js.gz wasn't rewritten")
+	}
+
+	// Without -gzip, siblings are cleaned.
+	theWiki.Gzip = false
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "index.html.gz")); err == nil {
+		t.Error("index.html.gz kept by clean without Gzip")
+	}
+}
+
+func TestGzipSiblingRemoved(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md": strings.Repeat("x", 2000),
+	})
+	theWiki.Gzip = true
+	theWiki.GzipMinSize = 1000
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	siblingPath := filepath.Join(destDir, "index.html.gz")
+	if _, err := os.Stat(siblingPath); err != nil {
+		t.Fatalf("index.html.gz not written: %v", err)
+	}
+
+	// Once the page is too small for a sibling, the old one is deleted
+	// rather than left to be served in its place, other than in a dry run.
+	if err := os.WriteFile(filepath.Join(theWiki.ContentDir, "index.md"), []byte("small"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var result *BuildResult
+	theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+	theWiki.DryRun = true
+	if err := theWiki.Generate(context.Background(), true, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(result.Deleted) != 1 || result.Deleted[0] != "index.html.gz" {
+		t.Errorf("dry run Deleted = %v, want [index.html.gz]", result.Deleted)
+	}
+	if _, err := os.Stat(siblingPath); err != nil {
+		t.Errorf("index.html.gz deleted by a dry run: %v", err)
+	}
+	theWiki.DryRun = false
+	if err := theWiki.Generate(context.Background(), true, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if _, err := os.Stat(siblingPath); err == nil {
+		t.Error("index.html.gz kept after the page got too small for it")
+	}
+}
+
+func TestGzipInvalidLevel(t *testing.T) {
+	theWiki, _ := includeTestWiki(t, nil)
+	theWiki.Gzip = true
+	theWiki.GzipLevel = 10
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err == nil {
+		t.Error("Generate succeeded with gzip level 10")
+	}
+}
@@ -0,0 +1,232 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+// DefaultHookTimeout is how long a hook command may run before it's killed,
+// when Wiki.HookTimeout isn't set.
+const DefaultHookTimeout = 5 * time.Minute
+
+// When hooks run, as given in the first field of each line of hooks.csv.
+const (
+	HookPre  = "pre"  // Before each generation
+	HookPost = "post" // After each generation
+)
+
+// hook is a command to run before or after each generation of a wiki.
+type hook struct {
+	when    string // HookPre or HookPost
+	command string // Run with the shell
+}
+
+// loadHooks loads the hook commands of a wiki, from its hooks.csv. Hooks are
+// only run for wikis with a source directory on disk, so that building from
+// an arbitrary fs.FS never runs commands it provides.
+func (wiki *Wiki) loadHooks() error {
+	wiki.hooks = nil
+	if !wiki.sourceIsDir {
+		return nil
+	}
+
+	const hooksFileName = "hooks.csv"
+	hooksPath := filepath.Join(wiki.SourceDir, hooksFileName)
+	pairs, err := util.LoadStringPairs(hooksPath)
+	if err != nil {
+		return fmt.Errorf("failed to load hooks from '%s': %v", hooksPath, err)
+	}
+
+	for i, pair := range pairs {
+		when := strings.TrimSpace(pair[0])
+		command := strings.TrimSpace(pair[1])
+		if when != HookPre && when != HookPost {
+			return fmt.Errorf("invalid hook at line %d of '%s': expected %s or %s, got %q", i+1, hooksPath, HookPre, HookPost, when)
+		}
+		if command == "" {
+			return fmt.Errorf("invalid hook at line %d of '%s': command is empty", i+1, hooksPath)
+		}
+		wiki.hooks = append(wiki.hooks, hook{when: when, command: command})
+	}
+	return nil
+}
+
+// build generates the wiki, running the pre hooks before and the post hooks
+// after. Hook failures are logged, and don't fail the build.
+func (wiki *Wiki) build(ctx context.Context, regen, clean bool, version string) error {
+	// Reload hooks each time, so that changes apply to the next build in
+	// watch mode. Hooks aren't run in a dry run, since they can make changes
+	// of their own.
+	if wiki.DryRun {
+		wiki.hooks = nil
+	} else if err := wiki.loadHooks(); err != nil {
+		wiki.log.Error(err, "not running hooks")
+	}
+
+	// Reload plugins too. When they've changed, every page is regenerated,
+	// since its output may differ even though its source hasn't changed.
+	firstLoad := wiki.plugins == nil || !wiki.plugins.loaded
+	if changed, err := wiki.loadPlugins(); err != nil {
+		wiki.log.Error(err, "not running plugins")
+		wiki.plugins.clear()
+	} else if changed && !firstLoad {
+		wiki.log.Verbose("Plugins changed, so regenerating all pages")
+		regen = true
+	}
+
+	wiki.runHooks(ctx, HookPre, nil, nil)
+	err := wiki.generate(ctx, regen, clean, version)
+	if ctx.Err() == nil {
+		wiki.runHooks(ctx, HookPost, wiki.result, err)
+	}
+	return err
+}
+
+// runHooks runs the hooks for when, in the order they're listed. For post
+// hooks, result and buildErr are what the generation did and the error it
+// returned, if any.
+func (wiki *Wiki) runHooks(ctx context.Context, when string, result *BuildResult, buildErr error) {
+	var env []string // Environment for the hooks, built when needed
+	for _, hook := range wiki.hooks {
+		if hook.when != when {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if env == nil {
+			var cleanup func()
+			var err error
+			if env, cleanup, err = wiki.hookEnv(when, result, buildErr); err != nil {
+				wiki.log.Error(err, "failed to prepare environment for %s hooks", when)
+				return
+			}
+			defer cleanup()
+		}
+
+		if err := wiki.runHook(ctx, hook, env); err != nil {
+			wiki.log.Error(err, "%s hook failed", when)
+		}
+	}
+}
+
+// hookEnv returns the environment variables of hooks run at when, and a
+// function to call to remove the temporary files they name.
+func (wiki *Wiki) hookEnv(when string, result *BuildResult, buildErr error) ([]string, func(), error) {
+	env := append(os.Environ(),
+		"GOMARKWIKI_HOOK="+when,
+		"GOMARKWIKI_SOURCE_DIR="+wiki.SourceDir,
+		"GOMARKWIKI_CONTENT_DIR="+wiki.ContentDir,
+		"GOMARKWIKI_DEST_DIR="+wiki.DestDir,
+	)
+	if when != HookPost || result == nil {
+		return env, func() {}, nil
+	}
+
+	status := "success"
+	if buildErr != nil {
+		status = "failure"
+		env = append(env, "GOMARKWIKI_ERROR="+buildErr.Error())
+	}
+	env = append(env, "GOMARKWIKI_STATUS="+status)
+
+	// The changed and deleted files are listed in temporary files, since
+	// there can be too many for the environment.
+	changed := append(append([]string{}, result.Generated...), result.Copied...)
+	changedPath, err := writeFileList("gomarkwiki-changed-", changed)
+	if err != nil {
+		return nil, nil, err
+	}
+	deletedPath, err := writeFileList("gomarkwiki-deleted-", result.Deleted)
+	if err != nil {
+		os.Remove(changedPath)
+		return nil, nil, err
+	}
+	env = append(env,
+		"GOMARKWIKI_CHANGED_FILES="+changedPath,
+		fmt.Sprintf("GOMARKWIKI_CHANGED_COUNT=%d", len(changed)),
+		"GOMARKWIKI_DELETED_FILES="+deletedPath,
+		fmt.Sprintf("GOMARKWIKI_DELETED_COUNT=%d", len(result.Deleted)),
+	)
+	cleanup := func() {
+		os.Remove(changedPath)
+		os.Remove(deletedPath)
+	}
+	return env, cleanup, nil
+}
+
+// writeFileList writes names to a new temporary file, one per line, and
+// returns its path. The file's name starts with prefix.
+func writeFileList(prefix string, names []string) (string, error) {
+	file, err := os.CreateTemp("", prefix+"*.txt")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file list: %v", err)
+	}
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString(name)
+		buf.WriteByte('\n')
+	}
+	_, err = file.Write(buf.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write file list '%s': %v", file.Name(), err)
+	}
+	return file.Name(), nil
+}
+
+// runHook runs the command of hook with the shell, in the source dir, with
+// environment env. It's killed if it runs longer than the hook timeout or ctx
+// is cancelled.
+func (wiki *Wiki) runHook(ctx context.Context, hook hook, env []string) error {
+	timeout := wiki.HookTimeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", hook.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", hook.command)
+	}
+	setProcessGroup(cmd)
+	cmd.Dir = wiki.SourceDir
+	cmd.Env = env
+	cmd.WaitDelay = time.Second // Don't wait long for output from any children left running
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	wiki.log.Verbose("Running %s hook '%s'", hook.when, hook.command)
+	err := cmd.Run()
+	if text := strings.TrimRight(output.String(), "\n"); text != "" {
+		wiki.log.Verbose("Output of %s hook '%s':\n%s", hook.when, hook.command, text)
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("'%s' timed out after %s", hook.command, timeout)
+		}
+		if text := strings.TrimSpace(output.String()); text != "" {
+			return fmt.Errorf("'%s': %v: %s", hook.command, err, text)
+		}
+		return fmt.Errorf("'%s': %v", hook.command, err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package wiki
+
+import "os/exec"
+
+// setProcessGroup does nothing where process groups aren't supported. When
+// cmd is cancelled only it is killed, and not any children it started.
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,76 @@
+package wiki
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	theWiki, errOut := commandTestWiki(t, map[string]string{
+		"index.md":  "# Index",
+		"image.png": "png",
+	}, "hooks.csv", `pre,"echo ""$GOMARKWIKI_HOOK $GOMARKWIKI_DEST_DIR"" > pre.txt"
+post,"echo ""$GOMARKWIKI_STATUS $GOMARKWIKI_CHANGED_COUNT"" > post.txt; sort ""$GOMARKWIKI_CHANGED_FILES"" >> post.txt"
+`)
+
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("unexpected errors: %s", errOut)
+	}
+
+	pre, err := os.ReadFile(filepath.Join(theWiki.SourceDir, "pre.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "pre " + theWiki.DestDir + "\n"; string(pre) != want {
+		t.Errorf("pre hook wrote %q, want %q", pre, want)
+	}
+	post, err := os.ReadFile(filepath.Join(theWiki.SourceDir, "post.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "success 2\nimage.png\nindex.html\n"; string(post) != want {
+		t.Errorf("post hook wrote %q, want %q", post, want)
+	}
+}
+
+func TestHookFailure(t *testing.T) {
+	theWiki, errOut := commandTestWiki(t, map[string]string{"index.md": "# Index"}, "hooks.csv",
+		"pre,echo oops; exit 3\npost,sleep 5\n")
+	theWiki.HookTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("Generate took %s, want the post hook killed after its timeout", elapsed)
+	}
+	if _, err := os.Stat(filepath.Join(theWiki.DestDir, "index.html")); err != nil {
+		t.Errorf("failed pre hook stopped the build: %v", err)
+	}
+
+	log := errOut.String()
+	if !strings.Contains(log, "ERROR: pre hook failed: 'echo oops; exit 3': exit status 3: oops") {
+		t.Errorf("pre hook failure not logged:\n%s", log)
+	}
+	if !strings.Contains(log, "ERROR: post hook failed: 'sleep 5' timed out after 100ms") {
+		t.Errorf("post hook timeout not logged:\n%s", log)
+	}
+}
+
+func TestInvalidHooks(t *testing.T) {
+	theWiki, errOut := commandTestWiki(t, map[string]string{"index.md": "# Index"}, "hooks.csv", "during,true\n")
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(errOut.String(), `expected pre or post, got "during"`) {
+		t.Errorf("invalid hook not reported:\n%s", errOut)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package wiki
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes cmd run in its own process group, and be killed
+// along with any children it started when it's cancelled.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
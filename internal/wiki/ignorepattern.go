@@ -2,7 +2,9 @@
 package wiki
 
 import (
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -279,45 +281,139 @@ func (p *IgnorePattern) matchPath(path, pattern string) bool {
 // IgnoreMatcher manages a list of ignore patterns and determines if paths should be ignored.
 type IgnoreMatcher struct {
 	patterns []*IgnorePattern
+	scopes   []ignoreScope // Patterns from per-directory ignore files, shallowest first
 }
 
-// NewIgnoreMatcher creates a new IgnoreMatcher from a list of pattern strings.
-func NewIgnoreMatcher(lines []string) (*IgnoreMatcher, error) {
-	matcher := &IgnoreMatcher{
-		patterns: make([]*IgnorePattern, 0),
-	}
+// ignoreScope holds the patterns from an ignore file in a content
+// subdirectory. The patterns apply to paths within dir, relative to dir, the
+// way patterns in a nested .gitignore file do.
+type ignoreScope struct {
+	dir      string // Directory of the ignore file, slash-separated and relative to the content dir; "." for the root
+	patterns []*IgnorePattern
+}
 
+// parseIgnorePatterns parses each line of lines as an ignore pattern.
+func parseIgnorePatterns(lines []string) ([]*IgnorePattern, error) {
+	patterns := make([]*IgnorePattern, 0)
 	for _, line := range lines {
 		pattern, err := ParseIgnorePattern(line)
 		if err != nil {
 			return nil, err
 		}
 		if pattern != nil {
-			matcher.patterns = append(matcher.patterns, pattern)
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// NewIgnoreMatcher creates a new IgnoreMatcher from a list of pattern strings.
+func NewIgnoreMatcher(lines []string) (*IgnoreMatcher, error) {
+	patterns, err := parseIgnorePatterns(lines)
+	if err != nil {
+		return nil, err
+	}
+	return &IgnoreMatcher{patterns: patterns}, nil
+}
+
+// AddScope adds patterns from an ignore file in the content subdirectory dir,
+// given relative to the content dir. The patterns apply only to paths within
+// dir, and are matched relative to it. Patterns in deeper directories are
+// evaluated after those in shallower ones, so that they can override them.
+func (m *IgnoreMatcher) AddScope(dir string, lines []string) error {
+	patterns, err := parseIgnorePatterns(lines)
+	if err != nil {
+		return err
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	// Insert after any scopes at the same or a shallower depth.
+	scope := ignoreScope{dir: cleanScopeDir(dir), patterns: patterns}
+	depth := scopeDepth(scope.dir)
+	idx := len(m.scopes)
+	for idx > 0 && scopeDepth(m.scopes[idx-1].dir) > depth {
+		idx--
+	}
+	m.scopes = slices.Insert(m.scopes, idx, scope)
+	return nil
+}
+
+// WithoutScopesUnder returns a copy of the matcher without the patterns of
+// ignore files in dir or any of its subdirectories. A nil matcher returns an
+// empty one.
+func (m *IgnoreMatcher) WithoutScopesUnder(dir string) *IgnoreMatcher {
+	result := &IgnoreMatcher{patterns: make([]*IgnorePattern, 0)}
+	if m == nil {
+		return result
+	}
+	result.patterns = m.patterns
+	dir = cleanScopeDir(dir)
+	for _, scope := range m.scopes {
+		if !scopeContains(dir, scope.dir) {
+			result.scopes = append(result.scopes, scope)
 		}
 	}
+	return result
+}
+
+// cleanScopeDir returns dir slash-separated and cleaned, with "." for the content root.
+func cleanScopeDir(dir string) string {
+	return path.Clean(filepath.ToSlash(dir))
+}
+
+// scopeDepth returns the number of directories in dir, which is 0 for the content root.
+func scopeDepth(dir string) int {
+	if dir == "." {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
 
-	return matcher, nil
+// scopeContains returns true if relPath is dir or is inside dir. Both are
+// slash-separated and relative to the content dir.
+func scopeContains(dir, relPath string) bool {
+	return dir == "." || relPath == dir || strings.HasPrefix(relPath, dir+"/")
 }
 
 // Matches returns true if the path should be ignored based on the patterns.
 // Patterns are evaluated in order, with later patterns overriding earlier ones.
 // Negation patterns (starting with !) can un-ignore files.
 func (m *IgnoreMatcher) Matches(relPath string, isDir bool) bool {
-	if m == nil || len(m.patterns) == 0 {
+	if m == nil || (len(m.patterns) == 0 && len(m.scopes) == 0) {
 		return false
 	}
 
-	matched := false
-
 	// Process patterns in order - later patterns override earlier ones
-	for _, pattern := range m.patterns {
+	matched := matchPatterns(m.patterns, relPath, isDir, false)
+
+	// Then patterns from per-directory ignore files, from the shallowest
+	// directory to the deepest, matched relative to their directory.
+	slashPath := filepath.ToSlash(relPath)
+	for _, scope := range m.scopes {
+		if scope.dir == slashPath || !scopeContains(scope.dir, slashPath) {
+			continue
+		}
+		scopePath := slashPath
+		if scope.dir != "." {
+			scopePath = strings.TrimPrefix(slashPath, scope.dir+"/")
+		}
+		matched = matchPatterns(scope.patterns, scopePath, isDir, matched)
+	}
+
+	return matched
+}
+
+// matchPatterns evaluates patterns in order against relPath, starting from
+// matched, and returns whether relPath ends up ignored.
+func matchPatterns(patterns []*IgnorePattern, relPath string, isDir bool, matched bool) bool {
+	for _, pattern := range patterns {
 		if pattern.Matches(relPath, isDir) {
 			// If this is a negation pattern, un-ignore the file
 			// Otherwise, ignore it
 			matched = !pattern.isNegation
 		}
 	}
-
 	return matched
 }
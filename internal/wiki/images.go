@@ -0,0 +1,474 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"maps"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// imageJPEGQuality is the quality that downscaled JPEG images are encoded
+// with.
+const imageJPEGQuality = 85
+
+// isResizableImage returns whether the file name is an image that gets
+// downscaled variants.
+func isResizableImage(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return true
+	}
+	return false
+}
+
+// imageVariantName returns the slash-separated dest path of the variant of
+// the image at destName that's width pixels wide, so that photo.png becomes
+// photo-480w.png.
+func imageVariantName(destName string, width int) string {
+	ext := path.Ext(destName)
+	return fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(destName, ext), width, ext)
+}
+
+// imageWidths returns the wiki's ImageWidths, sorted, without duplicates.
+func (wiki Wiki) imageWidths() []int {
+	widths := slices.Clone(wiki.ImageWidths)
+	slices.Sort(widths)
+	return slices.Compact(widths)
+}
+
+// imageCache holds the sizes of a wiki's images, so that they aren't decoded
+// again for each page that shows them. It's shared by copies of the Wiki.
+type imageCache struct {
+	mu    sync.Mutex
+	sizes map[string]imageSize // By full path of the image
+}
+
+// imageSize is the size of an image, as of when it had modTime and
+// fileSize, and the widths of its downscaled variants.
+type imageSize struct {
+	modTime  time.Time
+	fileSize int64
+	width    int
+	height   int
+	variants []int // Widths of the variants, which are those of ImageWidths that are smaller than the image
+}
+
+// tooLarge returns whether the image has more than MaxImagePixels pixels,
+// and so isn't decoded to make variants.
+func (size imageSize) tooLarge() bool {
+	return int64(size.width)*int64(size.height) > MaxImagePixels
+}
+
+// imageSizeOf returns the size of the image at sourcePath, and the widths
+// of its variants. Animated GIFs have no variants, since only their first
+// frame would be kept, and neither do images that are too large.
+func (wiki Wiki) imageSizeOf(sourcePath string) (imageSize, error) {
+	info, err := wiki.statSource(sourcePath)
+	if err != nil {
+		return imageSize{}, err
+	}
+	cache := wiki.images
+	cache.mu.Lock()
+	size, ok := cache.sizes[sourcePath]
+	cache.mu.Unlock()
+	if ok && size.modTime.Equal(info.ModTime()) && size.fileSize == info.Size() {
+		return size, nil
+	}
+
+	// Read just the header of the image for its size, and just the blocks of
+	// a GIF to find whether it's animated, so that no pixels are decoded.
+	file, err := wiki.openSource(sourcePath)
+	if err != nil {
+		return imageSize{}, err
+	}
+	config, format, err := image.DecodeConfig(bufio.NewReader(file))
+	file.Close()
+	if err != nil {
+		return imageSize{}, fmt.Errorf("failed to decode image '%s': %v", sourcePath, err)
+	}
+	size = imageSize{modTime: info.ModTime(), fileSize: info.Size(), width: config.Width, height: config.Height}
+	animated := false
+	if format == "gif" {
+		animated = true // Unless it's found to have just one frame
+		if file, err := wiki.openSource(sourcePath); err == nil {
+			multiFrame, err := isAnimatedGIF(file)
+			file.Close()
+			animated = multiFrame || err != nil
+		}
+	}
+	if !animated && !size.tooLarge() {
+		for _, width := range wiki.imageWidths() {
+			if width < size.width {
+				size.variants = append(size.variants, width)
+			}
+		}
+	}
+
+	cache.mu.Lock()
+	cache.sizes[sourcePath] = size
+	cache.mu.Unlock()
+	return size, nil
+}
+
+// isAnimatedGIF returns whether the GIF read from r has more than one frame.
+// It skips over the blocks of the file up to the second frame, without
+// decoding any of them.
+func isAnimatedGIF(r io.Reader) (bool, error) {
+	br := bufio.NewReader(r)
+
+	// Skip the header, logical screen descriptor, and global color table.
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return false, err
+	}
+	if string(header[:3]) != "GIF" {
+		return false, errors.New("not a GIF")
+	}
+	if err := skipGIFColorTable(br, header[10]); err != nil {
+		return false, err
+	}
+
+	frames := 0
+	for {
+		block, err := br.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		switch block {
+		case 0x21: // Extension: a label, and then sub-blocks
+			if _, err := br.ReadByte(); err != nil {
+				return false, err
+			}
+			if err := skipGIFSubBlocks(br); err != nil {
+				return false, err
+			}
+		case 0x2c: // Image descriptor: a frame
+			frames++
+			if frames > 1 {
+				return true, nil
+			}
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(br, descriptor); err != nil {
+				return false, err
+			}
+			if err := skipGIFColorTable(br, descriptor[8]); err != nil {
+				return false, err
+			}
+			if _, err := br.ReadByte(); err != nil { // LZW minimum code size
+				return false, err
+			}
+			if err := skipGIFSubBlocks(br); err != nil {
+				return false, err
+			}
+		case 0x3b: // Trailer
+			return false, nil
+		default:
+			return false, fmt.Errorf("unknown GIF block 0x%02x", block)
+		}
+	}
+}
+
+// skipGIFColorTable skips the color table that follows a GIF descriptor
+// with the packed fields flags, if it has one.
+func skipGIFColorTable(br *bufio.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+	_, err := br.Discard(3 << (flags&0x07 + 1))
+	return err
+}
+
+// skipGIFSubBlocks skips GIF data sub-blocks, up to the empty one that ends
+// them.
+func skipGIFSubBlocks(br *bufio.Reader) error {
+	for {
+		size, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err := br.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+// findImages returns the slash-separated content paths of the resizable
+// images shown by the markdown data, of the page with content path
+// relContentPath, sorted.
+func (wiki Wiki) findImages(relContentPath string, data []byte) []string {
+	r := &linkRewriter{sourcePath: filepath.ToSlash(relContentPath)}
+	images := make(map[string]bool)
+	doc := wiki.markdown().Parser().Parse(text.NewReader(data))
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if n, ok := node.(*ast.Image); ok && entering {
+			if target, ok := r.target(string(n.Destination)); ok && isResizableImage(target) {
+				images[target] = true
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return slices.Sorted(maps.Keys(images))
+}
+
+// staticImage is a resizable image copied from the content dir.
+type staticImage struct {
+	contentPath    string // Full path of the image
+	relContentPath string // Path of the image relative to the content dir
+	relDestPath    string // Dest path it's copied to
+}
+
+// generateShownImageVariants writes the downscaled variants of the images
+// in images, by slash-separated content path, that are shown by any of the
+// pages at pagePaths. claimed and dirs are the dest paths claimed by source
+// files, and their directories, as used by findDestCollision; a variant that
+// collides with one is skipped with a warning. The dest paths of the
+// variants are added to relDestPaths, so that they're kept by clean.
+func (wiki Wiki) generateShownImageVariants(ctx context.Context, images map[string]staticImage, pagePaths map[string]bool, claimed, dirs map[string]string, relDestPaths map[string]bool, regen bool) []error {
+	shown := make(map[string]bool)
+	for pagePath := range pagePaths {
+		info, err := wiki.statSource(pagePath)
+		if err != nil {
+			continue
+		}
+		deps, err := wiki.pageDependenciesFor(pagePath, info)
+		if err != nil {
+			continue // Reported when the page is generated
+		}
+		for _, target := range deps.images {
+			shown[target] = true
+		}
+	}
+
+	var errs []error
+	for _, target := range slices.Sorted(maps.Keys(shown)) {
+		img, ok := images[target]
+		if !ok {
+			continue
+		}
+		claim := func(variantPath string) bool {
+			if existingSource, collision := findDestCollision(variantPath, claimed, dirs); collision {
+				wiki.log.Warning("Skipping variant of '%s': destination '%s' is already claimed by '%s'", img.relContentPath, variantPath, existingSource)
+				wiki.result.addCollision(variantPath, img.relContentPath, existingSource)
+				return false
+			}
+			claimDestPath(variantPath, img.relContentPath, claimed, dirs)
+			return true
+		}
+
+		// Keep any variants that were written before on error.
+		variantPaths, err := wiki.generateImageVariants(ctx, img.contentPath, img.relDestPath, regen, claim)
+		for _, variantPath := range variantPaths {
+			relDestPaths[variantPath] = true
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return append(errs, ctx.Err())
+			}
+			wiki.log.Error(err, "failed to generate variants of '%s'", img.contentPath)
+			wiki.result.addError(img.contentPath, err)
+			errs = append(errs, fmt.Errorf("failed to generate variants of '%s': %w", img.contentPath, err))
+		}
+	}
+	return errs
+}
+
+// generateImageVariants writes the downscaled variants of the image at
+// sourcePath, which is copied to relDestPath, and returns their dest paths.
+// Each variant is written only if claim returns true for its dest path, and
+// variants newer than the image are skipped. An image that can't be decoded,
+// or that's too large, is only warned about, since it's still copied.
+func (wiki Wiki) generateImageVariants(ctx context.Context, sourcePath, relDestPath string, regen bool, claim func(variantPath string) bool) ([]string, error) {
+	size, err := wiki.imageSizeOf(sourcePath)
+	if err != nil {
+		wiki.log.Warning("Not generating variants of '%s': %v", sourcePath, err)
+		return nil, nil
+	}
+	if size.tooLarge() {
+		wiki.log.Warning("Not generating variants of '%s': image is too large (%dx%d pixels, max %d pixels)", sourcePath, size.width, size.height, MaxImagePixels)
+		return nil, nil
+	}
+	info, err := wiki.statSource(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var src image.Image // Decoded when a variant needs writing
+	var format string
+	var variantPaths []string
+	for _, width := range size.variants {
+		if ctx.Err() != nil {
+			return variantPaths, ctx.Err()
+		}
+		name := imageVariantName(filepath.ToSlash(relDestPath), width)
+		variantPath := filepath.FromSlash(name)
+		if !claim(variantPath) {
+			continue
+		}
+		variantPaths = append(variantPaths, variantPath)
+		if !regen && sourceIsOlder(info, wiki.out, name) {
+			wiki.result.addSkipped(variantPath)
+			continue
+		}
+
+		if src == nil {
+			data, err := wiki.readSource(sourcePath)
+			if err != nil {
+				return variantPaths, err
+			}
+			if src, format, err = image.Decode(bytes.NewReader(data)); err != nil {
+				return variantPaths, fmt.Errorf("failed to decode image '%s': %v", sourcePath, err)
+			}
+		}
+		wiki.log.Verbose("Generating '%s'", wiki.destPath(variantPath))
+		height := max(1, (size.height*width+size.width/2)/size.width)
+		var encoded bytes.Buffer
+		if err := encodeImage(&encoded, resizeImage(src, width, height), format); err != nil {
+			return variantPaths, fmt.Errorf("failed to encode '%s': %v", wiki.destPath(variantPath), err)
+		}
+		if err := wiki.out.WriteFile(ctx, name, &encoded, info.Mode().Perm()); err != nil {
+			return variantPaths, err
+		}
+		wiki.result.addGenerated(variantPath)
+	}
+	return variantPaths, nil
+}
+
+// encodeImage writes img to w in format, as named by image.Decode.
+func encodeImage(w *bytes.Buffer, img image.Image, format string) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: imageJPEGQuality})
+	case "gif":
+		return gif.Encode(w, img, nil)
+	}
+	return fmt.Errorf("unsupported image format %s", format)
+}
+
+// resizeImage returns src downscaled to width by height, averaging the
+// source pixels that each pixel covers. It's done in two passes, across and
+// then down, in premultiplied RGBA.
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok || rgba.Rect.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Rect, src, bounds.Min, draw.Src)
+	}
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	// Across, to width by srcHeight, with 16 bits per channel.
+	across := make([]uint32, width*srcHeight*4)
+	for y := range srcHeight {
+		row := rgba.Pix[y*rgba.Stride:]
+		for x := range width {
+			x0, x1 := boxRange(x, width, srcWidth)
+			var sum [4]uint32
+			for sx := x0; sx < x1; sx++ {
+				for c := range 4 {
+					sum[c] += uint32(row[sx*4+c])
+				}
+			}
+			for c := range 4 {
+				across[(y*width+x)*4+c] = sum[c] * 256 / uint32(x1-x0)
+			}
+		}
+	}
+
+	// Down, to width by height.
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		y0, y1 := boxRange(y, height, srcHeight)
+		for x := range width {
+			var sum [4]uint64
+			for sy := y0; sy < y1; sy++ {
+				for c := range 4 {
+					sum[c] += uint64(across[(sy*width+x)*4+c])
+				}
+			}
+			for c := range 4 {
+				dst.Pix[y*dst.Stride+x*4+c] = uint8((sum[c]/uint64(y1-y0) + 128) / 256)
+			}
+		}
+	}
+	return dst
+}
+
+// boxRange returns the range of source pixels that pixel i of n covers,
+// when srcN pixels are scaled down to n.
+func boxRange(i, n, srcN int) (int, int) {
+	start := i * srcN / n
+	end := max((i+1)*srcN/n, start+1)
+	return start, end
+}
+
+// addImageAttributes adds srcset, sizes, width, height, and loading
+// attributes to the image node n, if it shows an image in the content dir
+// that has variants. target is the slash-separated content path of the
+// image, and destDir the slash-separated directory of the page's dest path.
+func (wiki Wiki) addImageAttributes(n *ast.Image, target, destDir string) {
+	if !isResizableImage(target) {
+		return
+	}
+	size, err := wiki.imageSizeOf(filepath.Join(wiki.ContentDir, filepath.FromSlash(target)))
+	if err != nil {
+		return // Reported when the image is copied, if it's in the wiki
+	}
+
+	if _, ok := n.AttributeString("width"); !ok {
+		if _, ok := n.AttributeString("height"); !ok {
+			n.SetAttributeString("width", []byte(fmt.Sprint(size.width)))
+			n.SetAttributeString("height", []byte(fmt.Sprint(size.height)))
+		}
+	}
+	if _, ok := n.AttributeString("loading"); !ok {
+		n.SetAttributeString("loading", []byte("lazy"))
+	}
+	if _, ok := n.AttributeString("srcset"); ok || len(size.variants) == 0 {
+		return
+	}
+
+	destName := filepath.ToSlash(wiki.fingerprint(filepath.FromSlash(target)))
+	var srcset []string
+	for _, width := range size.variants {
+		// A file in the content dir with the variant's name is copied
+		// instead of the variant.
+		variantName := imageVariantName(destName, width)
+		if _, err := wiki.statSource(filepath.Join(wiki.ContentDir, filepath.FromSlash(variantName))); err == nil {
+			continue
+		}
+		srcset = append(srcset, fmt.Sprintf("%s %dw", urlPathEscape(relativeURLPath(destDir, variantName)), width))
+	}
+	srcset = append(srcset, fmt.Sprintf("%s %dw", urlPathEscape(relativeURLPath(destDir, destName)), size.width))
+	n.SetAttributeString("srcset", []byte(strings.Join(srcset, ", ")))
+	n.SetAttributeString("sizes", []byte(fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", size.width, size.width)))
+}
+
+// urlPathEscape escapes the slash-separated path p for use in a URL, such
+// as in a srcset, where spaces would be taken as separators.
+func urlPathEscape(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
@@ -0,0 +1,243 @@
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPNG returns a PNG image of width by height, filled with c.
+func testPNG(t *testing.T, width, height int, c color.Color) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestResizeImage(t *testing.T) {
+	// Stripes of black and white average to gray.
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := range 2 {
+		for x := range 4 {
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+	dst := resizeImage(src, 2, 1)
+	if dst.Bounds() != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("bounds = %v, want 2x1", dst.Bounds())
+	}
+	for x := range 2 {
+		if got := dst.RGBAAt(x, 0); got.R != 128 || got.A != 255 {
+			t.Errorf("pixel %d = %v, want gray", x, got)
+		}
+	}
+}
+
+func TestImageVariantName(t *testing.T) {
+	for name, want := range map[string]string{
+		"photo.png":              "photo-480w.png",
+		"img/shot.3f9a1c2b.jpeg": "img/shot.3f9a1c2b-480w.jpeg",
+	} {
+		if got := imageVariantName(name, 480); got != want {
+			t.Errorf("imageVariantName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestImageVariants(t *testing.T) {
+	var animated bytes.Buffer
+	frame := image.NewPaletted(image.Rect(0, 0, 400, 100), []color.Color{color.Black, color.White})
+	if err := gif.EncodeAll(&animated, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":        "![Shot](img/my%20shot.png) ![Small](small.png) ![Anim](anim.gif)",
+		"img/my shot.png": testPNG(t, 400, 200, color.White),
+		"small.png":       testPNG(t, 50, 20, color.Black),
+		"anim.gif":        animated.String(),
+	})
+	theWiki.ImageWidths = []int{200, 100, 100}
+	var result *BuildResult
+	theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for name, wantWidth := range map[string]int{"img/my shot-100w.png": 100, "img/my shot-200w.png": 200} {
+		f, err := os.Open(filepath.Join(destDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("variant %s not written: %v", name, err)
+		}
+		config, err := png.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != wantWidth || config.Height != wantWidth/2 {
+			t.Errorf("%s is %dx%d, want %dx%d", name, config.Width, config.Height, wantWidth, wantWidth/2)
+		}
+	}
+	for _, name := range []string{"small-100w.png", "anim-100w.gif"} {
+		if _, err := os.Stat(filepath.Join(destDir, name)); err == nil {
+			t.Errorf("variant %s written, want none", name)
+		}
+	}
+
+	page, err := os.ReadFile(filepath.Join(destDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`srcset="img/my%20shot-100w.png 100w, img/my%20shot-200w.png 200w, img/my%20shot.png 400w"`,
+		`sizes="(max-width: 400px) 100vw, 400px"`,
+		`width="400" height="200" loading="lazy"`,
+		`<img src="small.png" alt="Small" width="50" height="20" loading="lazy">`,
+		`<img src="anim.gif" alt="Anim" width="400" height="100" loading="lazy">`,
+	} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("index.html doesn't contain %q:\n%s", want, page)
+		}
+	}
+
+	// Variants are kept by clean, and skipped when up to date.
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(theWiki.ContentDir, "img", "my shot.png"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(result.Generated) != 0 || len(result.Deleted) != 0 {
+		t.Errorf("Generated = %v, Deleted = %v, want nothing", result.Generated, result.Deleted)
+	}
+}
+
+func TestIsAnimatedGIF(t *testing.T) {
+	frame := image.NewPaletted(image.Rect(0, 0, 4, 4), []color.Color{color.Black, color.White})
+	var still, animated, globalPalette bytes.Buffer
+	if err := gif.Encode(&still, frame, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.EncodeAll(&animated, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	config := image.Config{ColorModel: frame.Palette, Width: 4, Height: 4}
+	if err := gif.EncodeAll(&globalPalette, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}, Config: config}); err != nil {
+		t.Fatal(err)
+	}
+	for name, test := range map[string]struct {
+		data []byte
+		want bool
+	}{
+		"still":          {still.Bytes(), false},
+		"animated":       {animated.Bytes(), true},
+		"global palette": {globalPalette.Bytes(), true},
+	} {
+		got, err := isAnimatedGIF(bytes.NewReader(test.data))
+		if err != nil || got != test.want {
+			t.Errorf("%s: isAnimatedGIF = %v, %v, want %v", name, got, err, test.want)
+		}
+	}
+	if _, err := isAnimatedGIF(strings.NewReader("GIF89a")); err == nil {
+		t.Errorf("isAnimatedGIF of a truncated GIF succeeded")
+	}
+}
+
+// testPNGHeader returns the start of a PNG image of width by height, with
+// just its header, which is enough for image.DecodeConfig.
+func testPNGHeader(width, height uint32) string {
+	ihdr := []byte("IHDR")
+	ihdr = binary.BigEndian.AppendUint32(ihdr, width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, 8, 2, 0, 0, 0) // 8-bit RGB
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(ihdr)-4))
+	data = append(data, ihdr...)
+	data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+	return string(data)
+}
+
+func TestImageVariantsOfShownImages(t *testing.T) {
+	still := image.NewPaletted(image.Rect(0, 0, 400, 100), []color.Color{color.Black, color.White})
+	var stillGIF bytes.Buffer
+	if err := gif.Encode(&stillGIF, still, nil); err != nil {
+		t.Fatal(err)
+	}
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":       "![Photo](photo.png) ![Huge](huge.png) ![Still](still.gif)",
+		"photo.png":      testPNG(t, 400, 200, color.White),
+		"photo-100w.png": testPNG(t, 10, 10, color.Black),
+		"huge.png":       testPNGHeader(100000, 100000),
+		"still.gif":      stillGIF.String(),
+		"unused.png":     testPNG(t, 400, 200, color.White),
+	})
+	theWiki.ImageWidths = []int{100, 200}
+	var result *BuildResult
+	theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+
+	// The huge image is never decoded, so it doesn't fail the build.
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for _, name := range []string{"photo-200w.png", "still-100w.gif", "still-200w.gif"} {
+		if _, err := os.Stat(filepath.Join(destDir, name)); err != nil {
+			t.Errorf("variant %s not written: %v", name, err)
+		}
+	}
+	for _, name := range []string{"unused-100w.png", "unused-200w.png", "huge-100w.png"} {
+		if _, err := os.Stat(filepath.Join(destDir, name)); err == nil {
+			t.Errorf("variant %s written, want none", name)
+		}
+	}
+
+	// A variant that would overwrite a file in the content dir is skipped.
+	f, err := os.Open(filepath.Join(destDir, "photo-100w.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := png.DecodeConfig(f)
+	f.Close()
+	if err != nil || config.Width != 10 {
+		t.Errorf("photo-100w.png was overwritten: %v, %v", config, err)
+	}
+	want := Collision{Path: "photo-100w.png", Source: "photo.png", ClaimedBy: "photo-100w.png"}
+	if len(result.Collisions) != 1 || result.Collisions[0] != want {
+		t.Errorf("Collisions = %v, want [%v]", result.Collisions, want)
+	}
+
+	page, err := os.ReadFile(filepath.Join(destDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`srcset="photo-200w.png 200w, photo.png 400w"`,
+		`<img src="huge.png" alt="Huge" width="100000" height="100000" loading="lazy">`,
+	} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("index.html doesn't contain %q:\n%s", want, page)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Include directives pull the content of another markdown file into a page
+// before it's rendered. Two forms are supported:
+//
+//	{{include: Shared/Contacts.md}}   path relative to the page, or to the content root
+//	![[Contacts]]                     wiki-style embed; the markdown extension is optional
+//
+// Either form can name a single heading section with #, as in
+// {{include: Shared/Contacts.md#On-call}} or ![[Contacts#On-call]].
+var (
+	includeDirectiveRegexp = regexp.MustCompile(`\{\{include:\s*([^}]+?)\s*\}\}`)
+	embedDirectiveRegexp   = regexp.MustCompile(`!\[\[([^\]]+?)\]\]`)
+	atxHeadingRegexp       = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+)
+
+// includeTarget is a parsed include directive.
+type includeTarget struct {
+	path    string // Path as written in the directive, without the section
+	section string // Heading section to include, or "" for the whole file
+	embed   bool   // True for the ![[Name]] form, where the extension is optional
+}
+
+// parseIncludeTarget splits the argument of an include directive into path and section.
+func parseIncludeTarget(arg string, embed bool) includeTarget {
+	target := includeTarget{path: strings.TrimSpace(arg), embed: embed}
+	if idx := strings.LastIndexByte(target.path, '#'); idx >= 0 {
+		target.section = strings.TrimSpace(target.path[idx+1:])
+		target.path = strings.TrimSpace(target.path[:idx])
+	}
+	return target
+}
+
+// expandIncludes replaces include directives in data, the content of the
+// markdown file at pagePath, with the content of the files they name. It
+// returns the expanded data and the full paths of every file included,
+// directly or transitively. Directives inside fenced code blocks are left as is.
+func (wiki Wiki) expandIncludes(data []byte, pagePath string) ([]byte, []string, error) {
+	included := make(map[string]bool)
+	stack := []string{filepath.Clean(pagePath)}
+	expanded, err := wiki.expandIncludesWithDepth(data, pagePath, stack, included)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := make([]string, 0, len(included))
+	for file := range included {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return expanded, files, nil
+}
+
+// expandIncludesWithDepth is the internal recursive implementation of
+// expandIncludes. stack holds the chain of files being expanded, for cycle
+// detection and the depth limit.
+func (wiki Wiki) expandIncludesWithDepth(data []byte, filePath string, stack []string, included map[string]bool) ([]byte, error) {
+	// Quick check so that pages without directives are returned unchanged.
+	if !bytes.Contains(data, []byte("{{include:")) && !bytes.Contains(data, []byte("![[")) {
+		return data, nil
+	}
+
+	var result bytes.Buffer
+	var expandErr error
+	forEachLineOutsideCode(data, func(line []byte, inCode bool) {
+		if inCode || expandErr != nil {
+			result.Write(line)
+			return
+		}
+		line = replaceDirectives(line, func(target includeTarget) []byte {
+			if expandErr != nil {
+				return nil
+			}
+			var content []byte
+			content, expandErr = wiki.includeFile(target, filePath, stack, included)
+			return content
+		})
+		result.Write(line)
+	})
+	if expandErr != nil {
+		return nil, expandErr
+	}
+
+	return result.Bytes(), nil
+}
+
+// replaceDirectives replaces each include directive in line with what replace returns.
+func replaceDirectives(line []byte, replace func(includeTarget) []byte) []byte {
+	line = includeDirectiveRegexp.ReplaceAllFunc(line, func(match []byte) []byte {
+		arg := includeDirectiveRegexp.FindSubmatch(match)[1]
+		return replace(parseIncludeTarget(string(arg), false))
+	})
+	line = embedDirectiveRegexp.ReplaceAllFunc(line, func(match []byte) []byte {
+		arg := embedDirectiveRegexp.FindSubmatch(match)[1]
+		return replace(parseIncludeTarget(string(arg), true))
+	})
+	return line
+}
+
+// includeFile reads and expands the file named by target, which was found in
+// the file at fromPath.
+func (wiki Wiki) includeFile(target includeTarget, fromPath string, stack []string, included map[string]bool) ([]byte, error) {
+	// Check depth limit.
+	if len(stack) > MaxIncludeDepth {
+		return nil, fmt.Errorf("include depth exceeded at '%s' (depth %d, max %d)", fromPath, len(stack), MaxIncludeDepth)
+	}
+
+	// Find the file.
+	includePath, err := wiki.resolveInclude(target, fromPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve include '%s' in '%s': %v", target.path, fromPath, err)
+	}
+	included[includePath] = true
+
+	// Check for cycles.
+	for _, ancestor := range stack {
+		if ancestor == includePath {
+			chain := append(append([]string{}, stack...), includePath)
+			return nil, fmt.Errorf("include cycle detected: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	// Read the file.
+	info, err := wiki.statSource(includePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat included file '%s': %v", includePath, err)
+	}
+	if info.Size() > MaxMarkdownFileSize {
+		return nil, fmt.Errorf("included file '%s' is too large (%d bytes, max %d bytes)", includePath, info.Size(), MaxMarkdownFileSize)
+	}
+	data, err := wiki.readSource(includePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read included file '%s': %v", includePath, err)
+	}
+
+	// An included file's front matter and style directive apply only when
+	// it's rendered as a page.
+	_, data = wiki.splitPageFrontMatter(includePath, data)
+	_, data = checkForStyleDirective(data)
+
+	// Extract the section, if one was named.
+	if target.section != "" {
+		var found bool
+		if data, found = extractSection(data, target.section); !found {
+			return nil, fmt.Errorf("section '%s' not found in included file '%s'", target.section, includePath)
+		}
+	}
+
+	// Expand includes within the included file.
+	data, err = wiki.expandIncludesWithDepth(data, includePath, append(stack, includePath), included)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(data, "\n"), nil
+}
+
+// resolveInclude finds the file named by target. Paths are resolved relative
+// to the directory of the including file first, and then relative to the
+// content root. A path starting with / is resolved relative to the content
+// root only. Resolved paths must stay within the content directory.
+func (wiki Wiki) resolveInclude(target includeTarget, fromPath string) (string, error) {
+	name := filepath.FromSlash(target.path)
+	if name == "" {
+		return "", fmt.Errorf("empty include path")
+	}
+
+	var bases []string
+	if strings.HasPrefix(target.path, "/") {
+		bases = []string{wiki.ContentDir}
+	} else {
+		bases = []string{filepath.Dir(fromPath), wiki.ContentDir}
+	}
+
+	// Embeds may leave off the markdown extension.
+	candidates := []string{name}
+	if target.embed && !isPathMarkdown(name) {
+		candidates = candidates[:0]
+		for _, ext := range markdownExts {
+			candidates = append(candidates, name+ext)
+		}
+	}
+
+	for _, base := range bases {
+		for _, candidate := range candidates {
+			candidatePath := filepath.Join(base, candidate)
+			if !isPathWithin(candidatePath, wiki.ContentDir) {
+				return "", fmt.Errorf("path escapes content directory")
+			}
+			if info, err := wiki.statSource(candidatePath); err == nil && !info.IsDir() {
+				return candidatePath, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("file not found")
+}
+
+// isPathWithin returns true if path is dir or is inside dir.
+func isPathWithin(path, dir string) bool {
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// forEachLineOutsideCode calls fn for each line of data, including its line
+// ending, with inCode set for lines that are part of a fenced code block.
+func forEachLineOutsideCode(data []byte, fn func(line []byte, inCode bool)) {
+	var fence []byte // Opening fence of the current code block, or nil
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+		line := data[:end]
+		data = data[end:]
+
+		trimmed := bytes.TrimLeft(line, " ")
+		isFence := len(line)-len(trimmed) < 4 && (bytes.HasPrefix(trimmed, []byte("```")) || bytes.HasPrefix(trimmed, []byte("~~~")))
+		switch {
+		case fence == nil && isFence:
+			// Opening fence: remember its character and length.
+			n := 0
+			for n < len(trimmed) && trimmed[n] == trimmed[0] {
+				n++
+			}
+			fence = trimmed[:n]
+			fn(line, true)
+		case fence != nil:
+			// Closing fence must use the same character and be at least as long.
+			if isFence && bytes.HasPrefix(trimmed, fence) && len(bytes.TrimSpace(bytes.TrimLeft(trimmed, string(fence[:1])))) == 0 {
+				fence = nil
+			}
+			fn(line, true)
+		default:
+			fn(line, false)
+		}
+	}
+}
+
+// extractSection returns the section of data that starts with the heading
+// whose text or ID matches section, up to the next heading of the same or a
+// higher level. Returns false if no such heading was found.
+func extractSection(data []byte, section string) ([]byte, bool) {
+	var result bytes.Buffer
+	level := 0 // Level of the matched heading, or 0 if not yet found
+	done := false
+	forEachLineOutsideCode(data, func(line []byte, inCode bool) {
+		if done {
+			return
+		}
+		if !inCode {
+			if match := atxHeadingRegexp.FindSubmatch(bytes.TrimRight(line, "\r\n")); match != nil {
+				headingLevel := len(match[1])
+				text := string(match[2])
+				if level > 0 && headingLevel <= level {
+					done = true
+					return
+				}
+				if level == 0 && (strings.EqualFold(text, section) || headingSlug(text) == headingSlug(section)) {
+					level = headingLevel
+				}
+			}
+		}
+		if level > 0 {
+			result.Write(line)
+		}
+	})
+	return result.Bytes(), level > 0
+}
+
+// headingSlug returns a simplified ID for heading text, along the lines of the
+// IDs generated for headings, so that sections can be named either way.
+func headingSlug(text string) string {
+	var slug strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case r == ' ' || r == '-' || r == '_':
+			slug.WriteRune('-')
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r > 127:
+			slug.WriteRune(r)
+		}
+	}
+	return slug.String()
+}
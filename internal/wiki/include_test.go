@@ -0,0 +1,220 @@
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+// includeTestWiki creates a wiki in a temp dir with the given content files,
+// keyed by path relative to the content dir.
+func includeTestWiki(t *testing.T, files map[string]string) (*Wiki, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	contentDir := filepath.Join(sourceDir, "content")
+	destDir := filepath.Join(tmpDir, "dest")
+	for relPath, content := range files {
+		path := filepath.Join(contentDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(contentDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	theWiki, err := NewWiki(sourceDir, destDir, nil)
+	if err != nil {
+		t.Fatalf("NewWiki failed: %v", err)
+	}
+	return theWiki, destDir
+}
+
+// commandTestWiki returns a wiki with the given content and the config file
+// configName, such as hooks.csv, holding config, for tests of commands run
+// with /bin/sh. Errors are logged to the returned buffer.
+func commandTestWiki(t *testing.T, files map[string]string, configName, config string) (*Wiki, *bytes.Buffer) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("command tests use /bin/sh")
+	}
+	theWiki, _ := includeTestWiki(t, files)
+	if err := os.WriteFile(filepath.Join(theWiki.SourceDir, configName), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var errOut bytes.Buffer
+	theWiki.log = util.NewLogger(&bytes.Buffer{}, &errOut, false, false)
+	return theWiki, &errOut
+}
+
+func TestExpandIncludes(t *testing.T) {
+	theWiki, _ := includeTestWiki(t, map[string]string{
+		"Shared/Contacts.md": "#[style(github)]\n# Contacts\nAlice\n## On-call\nBob\n## Other\nCarol\n",
+		"Shared/Nested.md":   "Before {{include: Contacts.md#On-call}} after",
+		"Cycle/A.md":         "{{include: B.md}}",
+		"Cycle/B.md":         "{{include: A.md}}",
+	})
+	pagePath := filepath.Join(theWiki.ContentDir, "Page.md")
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{"whole file from root", "{{include: Shared/Contacts.md}}\n", "# Contacts\nAlice\n## On-call\nBob\n## Other\nCarol\n", ""},
+		{"section by text", "{{include: Shared/Contacts.md#On-call}}\n", "## On-call\nBob\n", ""},
+		{"section by id", "![[Shared/Contacts#on-call]]\n", "## On-call\nBob\n", ""},
+		{"embed without extension", "![[Shared/Contacts#Other]]", "## Other\nCarol", ""},
+		{"nested relative include", "{{include: Shared/Nested.md}}", "Before ## On-call\nBob after", ""},
+		{"code block left alone", "```\n{{include: Shared/Contacts.md}}\n```\n", "```\n{{include: Shared/Contacts.md}}\n```\n", ""},
+		{"missing file", "{{include: Missing.md}}", "", "file not found"},
+		{"missing section", "{{include: Shared/Contacts.md#Nope}}", "", "section 'Nope' not found"},
+		{"escapes content dir", "{{include: ../ignore.txt}}", "", "escapes content directory"},
+		{"cycle", "{{include: Cycle/A.md}}", "", "include cycle detected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := theWiki.expandIncludes([]byte(tt.input), pagePath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expandIncludes() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandIncludes() unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("expandIncludes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncludeDepthLimit(t *testing.T) {
+	// Build a chain of files one longer than the depth limit.
+	files := map[string]string{}
+	for i := 0; i <= MaxIncludeDepth+1; i++ {
+		files[filepath.Join("Chain", "f"+strings.Repeat("x", i)+".md")] = "{{include: f" + strings.Repeat("x", i+1) + ".md}}"
+	}
+	theWiki, _ := includeTestWiki(t, files)
+	pagePath := filepath.Join(theWiki.ContentDir, "Chain", "f.md")
+
+	_, _, err := theWiki.expandIncludes([]byte(files[filepath.Join("Chain", "f.md")]), pagePath)
+	if err == nil || !strings.Contains(err.Error(), "include depth exceeded") {
+		t.Fatalf("expected depth limit error, got %v", err)
+	}
+}
+
+// TestIncludedFileChangeRegeneratesPages verifies that editing an included
+// file regenerates every page that includes it, even without -regen.
+func TestIncludedFileChangeRegeneratesPages(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"Shared/Contacts.md": "Alice",
+		"One.md":             "# One\n{{include: Shared/Contacts.md}}",
+		"Two/Two.md":         "# Two\n![[Contacts]]",
+		"Two/Contacts.md":    "Local contacts",
+		"Three.md":           "# Three\nNo includes",
+	})
+
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	threeInfo, err := os.Stat(filepath.Join(destDir, "Three.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the change newer than the generated HTML.
+	sharedPath := filepath.Join(theWiki.ContentDir, "Shared", "Contacts.md")
+	if err := os.WriteFile(sharedPath, []byte("Bob"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(2 * time.Second)
+	if err := os.Chtimes(sharedPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	one, err := os.ReadFile(filepath.Join(destDir, "One.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(one), "Bob") {
+		t.Errorf("One.html was not regenerated after included file changed:\n%s", one)
+	}
+	two, err := os.ReadFile(filepath.Join(destDir, "Two", "Two.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(two), "Local contacts") {
+		t.Errorf("Two.html should include the page-relative Contacts.md:\n%s", two)
+	}
+
+	// Pages without includes are left alone.
+	newThreeInfo, err := os.Stat(filepath.Join(destDir, "Three.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newThreeInfo.ModTime().Equal(threeInfo.ModTime()) {
+		t.Errorf("Three.html should not have been regenerated")
+	}
+}
+
+// TestPollingRegeneratesOnIgnoredIncludeChange verifies that watch mode
+// regenerates a page when a file it includes changes, even when that file is
+// ignored and so is not part of the content snapshot.
+func TestPollingRegeneratesOnIgnoredIncludeChange(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":          "# Index\n{{include: Shared/Snippet.md}}",
+		"Shared/Snippet.md": "first",
+		"../ignore.txt":     "Shared/\n", // ignore.txt lives in the source dir, next to content
+	})
+	theWiki.PollInterval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- theWiki.Generate(ctx, true, false, true, "test")
+	}()
+	defer func() {
+		cancel()
+		<-errChan
+	}()
+
+	indexHTML := filepath.Join(destDir, "index.html")
+	if got, ok := waitForFileContent(indexHTML, "first", 2*time.Second); !ok {
+		t.Fatalf("initial generation did not include snippet; got:\n%s", got)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "Shared")); err == nil {
+		t.Fatalf("ignored Shared directory should not be generated")
+	}
+
+	// Edit the ignored snippet.
+	snippetPath := filepath.Join(theWiki.ContentDir, "Shared", "Snippet.md")
+	if err := os.WriteFile(snippetPath, []byte("second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(2 * time.Second)
+	if err := os.Chtimes(snippetPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := waitForFileContent(indexHTML, "second", 5*time.Second); !ok {
+		t.Fatalf("index.html did not pick up snippet edit; got:\n%s", got)
+	}
+}
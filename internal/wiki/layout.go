@@ -0,0 +1,253 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// Output layouts, which say where the page for each markdown file goes.
+const (
+	LayoutFlat   = "flat"   // Foo/Bar.md becomes Foo/Bar.html
+	LayoutPretty = "pretty" // Foo/Bar.md becomes Foo/Bar/index.html, served as Foo/Bar/
+)
+
+// layout returns the wiki's layout, with LayoutFlat for the default.
+func (wiki Wiki) layout() string {
+	if wiki.Layout == "" {
+		return LayoutFlat
+	}
+	return wiki.Layout
+}
+
+// pageDestPath returns the dest path of the page generated from the markdown
+// file with content path relPathNoExt, without its extension. In the pretty
+// layout, pages named index keep their place, so that Foo/index.md becomes
+// Foo/index.html.
+func (wiki Wiki) pageDestPath(relPathNoExt string) string {
+	if wiki.Layout == LayoutPretty && filepath.Base(relPathNoExt) != "index" {
+		return filepath.Join(relPathNoExt, "index.html")
+	}
+	return relPathNoExt + ".html"
+}
+
+// findDestCollision returns the source that already claimed relDestPath in
+// claimed, a map of dest paths to the sources that claimed them. Besides the
+// same path being claimed twice, this catches a file being claimed where
+// another file needs a directory, such as a static file Foo and the page
+// Foo/index.html of Foo.md in the pretty layout. dirs maps the directories
+// of the claimed dest paths to the sources that needed them.
+func findDestCollision(relDestPath string, claimed, dirs map[string]string) (string, bool) {
+	if source, ok := claimed[relDestPath]; ok {
+		return source, true
+	}
+	if source, ok := dirs[relDestPath]; ok {
+		return source, true
+	}
+	for dir := filepath.Dir(relDestPath); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if source, ok := claimed[dir]; ok {
+			return source, true
+		}
+	}
+	return "", false
+}
+
+// claimDestPath records that relSourcePath claimed relDestPath, for
+// findDestCollision.
+func claimDestPath(relDestPath, relSourcePath string, claimed, dirs map[string]string) {
+	claimed[relDestPath] = relSourcePath
+	for dir := filepath.Dir(relDestPath); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if _, ok := dirs[dir]; ok {
+			break
+		}
+		dirs[dir] = relSourcePath
+	}
+}
+
+// isPage returns whether there's a markdown file for the slash-separated
+// content path relPathNoExt, which has no extension.
+func (wiki Wiki) isPage(relPathNoExt string) bool {
+	for _, ext := range markdownExts {
+		if _, err := wiki.statSource(filepath.Join(wiki.ContentDir, filepath.FromSlash(relPathNoExt)+ext)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// newLinkRewriter returns the linkRewriter for the page with content path
+// relContentPath and dest path relDestPath, or nil if links don't need to be
+// rewritten in the wiki's layout and there are no fingerprinted files.
+func (wiki Wiki) newLinkRewriter(relContentPath, relDestPath string) *linkRewriter {
+	r := &linkRewriter{
+		sourcePath: filepath.ToSlash(relContentPath),
+		destPath:   filepath.ToSlash(relDestPath),
+	}
+	if wiki.assets != nil {
+		r.fingerprints = wiki.assets.names
+	}
+	if wiki.images != nil {
+		r.addImageAttributes = wiki.addImageAttributes
+	}
+	if wiki.Layout == LayoutPretty {
+		r.isPage = wiki.isPage
+		r.destPathOf = func(relPathNoExt string) string {
+			return filepath.ToSlash(wiki.pageDestPath(filepath.FromSlash(relPathNoExt)))
+		}
+	} else if len(r.fingerprints) == 0 && r.addImageAttributes == nil {
+		return nil
+	}
+	return r
+}
+
+// linkRewriterKey is the parser context key for the linkRewriter of the page
+// being converted.
+var linkRewriterKey = parser.NewContextKey()
+
+// linkRewriter rewrites the relative links and images of a page in the pretty
+// layout, and links to fingerprinted files. Since each page is moved down
+// into a directory of its own, links written relative to the markdown file
+// need to go up a level, and links to pages need to point at their
+// directories.
+type linkRewriter struct {
+	sourcePath   string              // Slash-separated content path of the markdown file
+	destPath     string              // Slash-separated dest path of its page
+	isPage       func(string) bool   // Whether a slash-separated content path without extension is a markdown page; nil in the flat layout
+	destPathOf   func(string) string // Dest path of the page with a content path without extension
+	fingerprints map[string]string   // Fingerprinted dest paths of static files, by slash-separated content path
+
+	// addImageAttributes adds attributes for its variants to an image, given
+	// its slash-separated content path and the directory of destPath; nil
+	// if images don't have variants.
+	addImageAttributes func(n *ast.Image, target, destDir string)
+}
+
+// linkTransformer is the goldmark AST transformer that applies the
+// linkRewriter or exportRewriter in the parser context, if there is one.
+type linkTransformer struct{}
+
+// Transform rewrites the destinations of the links and images in doc.
+func (linkTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	if exporter, _ := pc.Get(exportRewriterKey).(*exportRewriter); exporter != nil {
+		exporter.transform(doc, reader.Source())
+		return
+	}
+	rewriter, _ := pc.Get(linkRewriterKey).(*linkRewriter)
+	if rewriter == nil {
+		return
+	}
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := node.(type) {
+		case *ast.Link:
+			n.Destination = []byte(rewriter.rewrite(string(n.Destination)))
+		case *ast.Image:
+			if target, ok := rewriter.target(string(n.Destination)); ok && rewriter.addImageAttributes != nil {
+				rewriter.addImageAttributes(n, target, path.Dir(rewriter.destPath))
+			}
+			n.Destination = []byte(rewriter.rewrite(string(n.Destination)))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// target returns the slash-separated content path of what the link
+// destination dest points at, if it's a relative link to a file in the
+// content dir.
+func (r *linkRewriter) target(dest string) (string, bool) {
+	link, err := url.Parse(dest)
+	if err != nil {
+		return "", false
+	}
+	return r.linkTarget(link)
+}
+
+// linkTarget returns the slash-separated content path of what link points
+// at, if it's a relative link to a file in the content dir.
+func (r *linkRewriter) linkTarget(link *url.URL) (string, bool) {
+	if link.Scheme != "" || link.Host != "" || link.Path == "" || strings.HasPrefix(link.Path, "/") {
+		return "", false
+	}
+	target := path.Join(path.Dir(r.sourcePath), link.Path)
+	if target == ".." || strings.HasPrefix(target, "../") {
+		return "", false // Outside the wiki
+	}
+	return target, true
+}
+
+// rewrite returns the link destination dest rewritten for the page's place
+// in the dest dir. Absolute URLs and paths, links within the page, and links
+// outside the wiki are returned as is.
+func (r *linkRewriter) rewrite(dest string) string {
+	link, err := url.Parse(dest)
+	if err != nil {
+		return dest
+	}
+	target, ok := r.linkTarget(link)
+	if !ok {
+		return dest
+	}
+
+	// Links to pages, by their markdown or HTML names, point at the page's
+	// directory.
+	targetDest := target
+	trailingSlash := strings.HasSuffix(link.Path, "/")
+	ext := strings.ToLower(path.Ext(target))
+	if fingerprinted, ok := r.fingerprints[target]; ok {
+		targetDest = fingerprinted
+	} else if r.isPage == nil {
+		return dest // Nothing else to rewrite in the flat layout
+	} else if noExt := removeFileExtension(target); (isPathMarkdown(target) || ext == ".html") && r.isPage(noExt) {
+		targetDest = r.destPathOf(noExt)
+		if path.Base(targetDest) == "index.html" {
+			targetDest = path.Dir(targetDest)
+			trailingSlash = true
+		}
+	}
+
+	// Make the link relative to the page's directory.
+	rel := relativeURLPath(path.Dir(r.destPath), targetDest)
+	if trailingSlash && !strings.HasSuffix(rel, "/") {
+		rel += "/"
+	}
+	link.Path = rel
+	link.RawPath = ""
+	return link.String()
+}
+
+// relativeURLPath returns the slash-separated path target relative to the
+// directory dir, where both are relative to the same root.
+func relativeURLPath(dir, target string) string {
+	dirParts := splitURLPath(dir)
+	targetParts := splitURLPath(target)
+	common := 0
+	for common < len(dirParts) && common < len(targetParts) && dirParts[common] == targetParts[common] {
+		common++
+	}
+	parts := make([]string, 0, len(dirParts)-common+len(targetParts)-common)
+	for range dirParts[common:] {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, targetParts[common:]...)
+	if len(parts) == 0 {
+		return "./"
+	}
+	return strings.Join(parts, "/")
+}
+
+// splitURLPath splits the slash-separated path p into its elements, with "."
+// being none.
+func splitURLPath(p string) []string {
+	if p == "." || p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
@@ -0,0 +1,110 @@
+package wiki
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLinkRewriter(t *testing.T) {
+	pages := map[string]bool{"index": true, "Topics/Example": true, "Topics/Other": true, "Topics/index": true}
+	prettyWiki := Wiki{Layout: LayoutPretty}
+	rewriter := &linkRewriter{
+		sourcePath: "Topics/Example.md",
+		destPath:   "Topics/Example/index.html",
+		isPage:     func(relPathNoExt string) bool { return pages[relPathNoExt] },
+		destPathOf: func(relPathNoExt string) string {
+			return filepath.ToSlash(prettyWiki.pageDestPath(filepath.FromSlash(relPathNoExt)))
+		},
+	}
+
+	tests := []struct {
+		dest string
+		want string
+	}{
+		{"Other.md", "../Other/"},
+		{"Other.html#Usage", "../Other/#Usage"},
+		{"Example.md", "./"},
+		{"index.md", "../"},
+		{"../index.html", "../../"},
+		{"image.png", "../image.png"},
+		{"Sub/file.pdf?x=1", "../Sub/file.pdf?x=1"},
+		{"Missing.html", "../Missing.html"},
+		{"#section", "#section"},
+		{"/absolute/path.html", "/absolute/path.html"},
+		{"https://example.com/Other.html", "https://example.com/Other.html"},
+		{"mailto:someone@example.com", "mailto:someone@example.com"},
+		{"../../outside.html", "../../outside.html"},
+	}
+	for _, tt := range tests {
+		if got := rewriter.rewrite(tt.dest); got != tt.want {
+			t.Errorf("rewrite(%q) = %q, want %q", tt.dest, got, tt.want)
+		}
+	}
+}
+
+func TestPrettyLayout(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":           "[Example](Topics/Example.md)",
+		"Topics/index.md":    "# Topics",
+		"Topics/Example.md":  "[Other](Other.md) [Topics](index.md) ![Image](image.png) [Web](https://example.com/)",
+		"Topics/Other.md":    "# Other",
+		"Topics/image.png":   "png",
+		"Topics/Clash":       "static",
+		"Topics/Clash.md":    "# Clash",
+		"Topics/Example.old": "old",
+	})
+
+	// Generate with the flat layout first, to check that clean removes its pages.
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	var result *BuildResult
+	theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+	theWiki.Layout = LayoutPretty
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	for _, relPath := range []string{"index.html", "Topics/index.html", "Topics/Example/index.html", "Topics/Other/index.html", "Topics/image.png"} {
+		if _, err := os.Stat(filepath.Join(destDir, relPath)); err != nil {
+			t.Errorf("%s not generated: %v", relPath, err)
+		}
+	}
+	for _, relPath := range []string{"Topics/Example.html", "Topics/Other.html"} {
+		if _, err := os.Stat(filepath.Join(destDir, relPath)); !os.IsNotExist(err) {
+			t.Errorf("%s from the flat layout not cleaned: %v", relPath, err)
+		}
+	}
+
+	example, err := os.ReadFile(filepath.Join(destDir, "Topics", "Example", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<title>Example</title>`,
+		`href="../../style.css"`,
+		`<a href="../Other/">Other</a>`,
+		`<a href="../">Topics</a>`,
+		`<img src="../image.png" alt="Image">`,
+		`<a href="https://example.com/">Web</a>`,
+	} {
+		if !strings.Contains(string(example), want) {
+			t.Errorf("Topics/Example/index.html doesn't contain %q:\n%s", want, example)
+		}
+	}
+	index, err := os.ReadFile(filepath.Join(destDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<a href="Topics/Example/">Example</a>`; !strings.Contains(string(index), want) {
+		t.Errorf("index.html doesn't contain %q:\n%s", want, index)
+	}
+
+	// The static file Clash is where the page Clash.md needs a directory.
+	if len(result.Collisions) != 1 || result.Collisions[0].Source != "Topics/Clash.md" || result.Collisions[0].ClaimedBy != "Topics/Clash" {
+		t.Errorf("Collisions = %+v, want Topics/Clash.md claimed by Topics/Clash", result.Collisions)
+	}
+}
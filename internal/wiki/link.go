@@ -0,0 +1,99 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// How static files are put in the dest dir.
+const (
+	LinkStaticCopy    = "copy"    // Copy their contents
+	LinkStaticReflink = "reflink" // Clone them, sharing data copy-on-write, where the filesystem supports it
+)
+
+// fileLinker is implemented by outputs that can put a static file in place
+// without copying its contents.
+type fileLinker interface {
+	// linkFile clones the file at sourcePath on disk to the file name. It
+	// returns false if it couldn't, so that the file is copied instead.
+	linkFile(ctx context.Context, name, sourcePath string, mode fs.FileMode) (bool, error)
+}
+
+// linkStatic returns the wiki's LinkStatic mode, with LinkStaticCopy for the
+// default.
+func (wiki Wiki) linkStatic() string {
+	if wiki.LinkStatic == "" {
+		return LinkStaticCopy
+	}
+	return wiki.LinkStatic
+}
+
+// linkSourcePath returns the path on disk of the static file at sourcePath,
+// with symlinks resolved, if it can be linked to the dest dir.
+func (wiki Wiki) linkSourcePath(sourcePath string) (string, bool) {
+	if !wiki.sourceIsDir || wiki.linkStatic() == LinkStaticCopy {
+		return "", false
+	}
+	resolved, err := filepath.EvalSymlinks(sourcePath)
+	if err != nil {
+		return "", false
+	}
+	return resolved, true
+}
+
+// linkFile clones the file at sourcePath to the file name. A clone is a
+// separate file that shares data copy-on-write with its source, so it's as
+// safe to modify as a copy. It's made under a temporary name and renamed into
+// place, like a copy, so an existing file at name is replaced rather than
+// written to.
+func (o *DirOutput) linkFile(ctx context.Context, name, sourcePath string, mode fs.FileMode) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	destPath := o.path(name)
+	destDir := filepath.Dir(destPath)
+	if err := ensureDirectoryPath(o.log, destDir); err != nil {
+		return false, err
+	}
+	if err := removeConflictingDir(o.log, destPath); err != nil {
+		return false, err
+	}
+
+	tempFile, err := os.CreateTemp(destDir, ".tmp-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temp file in '%s': %v", destDir, err)
+	}
+	tempPath := tempFile.Name()
+	cloneErr := cloneFile(tempFile, sourcePath)
+	if cloneErr == nil {
+		cloneErr = tempFile.Chmod(mode)
+	}
+	if err := tempFile.Close(); err != nil && cloneErr == nil {
+		cloneErr = err
+	}
+	if cloneErr != nil {
+		os.Remove(tempPath)
+		o.log.Debug("Can't clone '%s', so copying it: %v", sourcePath, cloneErr)
+		return false, nil
+	}
+
+	if err := os.Rename(tempPath, destPath); err != nil {
+		os.Remove(tempPath)
+		return false, fmt.Errorf("failed to rename temp file '%s' to '%s': %v", tempPath, destPath, err)
+	}
+	return true, nil
+}
+
+// linkFile doesn't link anything in a dry run, so that the file is read and
+// recorded by WriteFile instead.
+func (o *dryRunOutput) linkFile(ctx context.Context, name, sourcePath string, mode fs.FileMode) (bool, error) {
+	return false, nil
+}
+
+// errCloneUnsupported is returned by cloneFile where files can't be cloned.
+var errCloneUnsupported = errors.New("cloning files isn't supported on this system")
@@ -0,0 +1,21 @@
+//go:build linux
+
+package wiki
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dest, an empty file, a clone of the file at sourcePath
+// with the FICLONE ioctl, which is supported by filesystems such as Btrfs,
+// XFS, and bcachefs when both files are on the same one.
+func cloneFile(dest *os.File, sourcePath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	return unix.IoctlFileClone(int(dest.Fd()), int(source.Fd()))
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package wiki
+
+import "os"
+
+// cloneFile isn't supported here, so static files are copied instead.
+func cloneFile(dest *os.File, sourcePath string) error {
+	return errCloneUnsupported
+}
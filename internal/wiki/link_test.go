@@ -0,0 +1,64 @@
+package wiki
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkStaticReflink(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"data.bin": "data",
+	})
+	theWiki.LinkStatic = LinkStaticReflink
+	var result *BuildResult
+	theWiki.OnBuild = func(r *BuildResult, err error) { result = r }
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// Whether it was cloned or copied, it's a separate file.
+	sourcePath := filepath.Join(theWiki.ContentDir, "data.bin")
+	destPath := filepath.Join(destDir, "data.bin")
+	if data, _ := os.ReadFile(destPath); string(data) != "data" {
+		t.Errorf("dest file has %q, want %q", data, "data")
+	}
+	sourceInfo, err := os.Stat(sourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	destInfo, err := os.Stat(destPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(sourceInfo, destInfo) {
+		t.Errorf("dest file is its source file")
+	}
+
+	// It's up to date like a copy.
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(result.Copied) != 0 {
+		t.Errorf("Copied = %v, want nothing", result.Copied)
+	}
+
+	// Editing it in place leaves the source alone.
+	if err := os.WriteFile(destPath, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(sourcePath); string(data) != "data" {
+		t.Errorf("source file has %q, want %q", data, "data")
+	}
+}
+
+func TestLinkStaticInvalid(t *testing.T) {
+	for _, mode := range []string{"symlink", "hardlink"} {
+		theWiki, _ := includeTestWiki(t, nil)
+		theWiki.LinkStatic = mode
+		if err := theWiki.Generate(context.Background(), false, false, false, "test"); err == nil {
+			t.Errorf("Generate succeeded with link mode '%s'", mode)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the buckets of the
+// build duration histogram.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// wikiMetrics holds the metrics for one wiki.
+type wikiMetrics struct {
+	wiki *Wiki
+
+	buildsCompleted uint64
+	buildsFailed    uint64
+	lastSuccess     time.Time // Zero until a build succeeds
+	lastBuildFailed bool
+
+	durationCounts []uint64 // Count of builds in each of durationBuckets, not cumulative
+	durationSum    float64  // Total of build durations, in seconds
+
+	pagesRendered uint64
+	filesCopied   uint64
+	watchEvents   uint64
+	pollCycles    uint64
+
+	watchStopped bool // Whether the wiki was watched and then stopped being watched, and hasn't been restarted
+}
+
+// Metrics collects metrics for a set of wikis, and serves them over HTTP in
+// the Prometheus text exposition format, along with a health check.
+type Metrics struct {
+	mu    sync.Mutex
+	wikis []*wikiMetrics
+}
+
+// NewMetrics returns Metrics for wikis, adding each with Add.
+func NewMetrics(wikis []*Wiki) *Metrics {
+	m := &Metrics{}
+	for _, wiki := range wikis {
+		m.Add(wiki)
+	}
+	return m
+}
+
+// Add adds metrics for wiki. It sets the OnBuild and OnWatch of wiki, calling
+// any that were already set.
+func (m *Metrics) Add(wiki *Wiki) {
+	wm := &wikiMetrics{
+		wiki:           wiki,
+		durationCounts: make([]uint64, len(durationBuckets)),
+	}
+	m.mu.Lock()
+	m.wikis = append(m.wikis, wm)
+	m.mu.Unlock()
+
+	onBuild := wiki.OnBuild
+	wiki.OnBuild = func(result *BuildResult, err error) {
+		m.recordBuild(wm, result, err)
+		if onBuild != nil {
+			onBuild(result, err)
+		}
+	}
+	onWatch := wiki.OnWatch
+	wiki.OnWatch = func(event WatchEvent) {
+		m.recordWatch(wm, event)
+		if onWatch != nil {
+			onWatch(event)
+		}
+	}
+}
+
+// Remove removes the metrics for wiki, which then no longer affects the
+// health check.
+func (m *Metrics) Remove(wiki *Wiki) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.wikis = slices.DeleteFunc(m.wikis, func(wm *wikiMetrics) bool { return wm.wiki == wiki })
+}
+
+// recordBuild records a generation of the wiki with metrics wm.
+func (m *Metrics) recordBuild(wm *wikiMetrics, result *BuildResult, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		wm.buildsFailed++
+		wm.lastBuildFailed = true
+	} else {
+		wm.buildsCompleted++
+		wm.lastBuildFailed = false
+		wm.lastSuccess = result.Start.Add(result.Duration)
+	}
+
+	seconds := result.Duration.Seconds()
+	wm.durationSum += seconds
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			wm.durationCounts[i]++
+			break
+		}
+	}
+
+	wm.pagesRendered += uint64(len(result.Generated))
+	wm.filesCopied += uint64(len(result.Copied))
+}
+
+// recordWatch records event from watching the wiki with metrics wm.
+func (m *Metrics) recordWatch(wm *wikiMetrics, event WatchEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch event {
+	case WatchEventReceived:
+		wm.watchEvents++
+	case WatchPollCycle:
+		wm.pollCycles++
+	case WatchStarted:
+		wm.watchStopped = false
+	case WatchStopped:
+		wm.watchStopped = true
+	}
+}
+
+// Handler returns a handler that serves the metrics at /metrics, and the
+// health check at /healthz.
+func (m *Metrics) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		problems := m.Problems()
+		if len(problems) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			for _, problem := range problems {
+				fmt.Fprintln(w, problem)
+			}
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// Problems returns why the wikis are unhealthy: a wiki's watcher has exited,
+// or its last build failed. It returns nil if they're healthy.
+func (m *Metrics) Problems() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var problems []string
+	for _, wm := range m.wikis {
+		if wm.watchStopped {
+			problems = append(problems, fmt.Sprintf("wiki '%s': watcher exited", wm.wiki.SourceDir))
+		}
+		if wm.lastBuildFailed {
+			problems = append(problems, fmt.Sprintf("wiki '%s': last build failed", wm.wiki.SourceDir))
+		}
+	}
+	return problems
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	counter := func(name, help string, value func(wm *wikiMetrics) uint64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, wm := range m.wikis {
+			fmt.Fprintf(&b, "%s{%s} %d\n", name, wikiLabel(wm), value(wm))
+		}
+	}
+
+	counter("gomarkwiki_builds_completed_total", "Builds that completed without error.",
+		func(wm *wikiMetrics) uint64 { return wm.buildsCompleted })
+	counter("gomarkwiki_builds_failed_total", "Builds that failed.",
+		func(wm *wikiMetrics) uint64 { return wm.buildsFailed })
+
+	name := "gomarkwiki_last_success_timestamp_seconds"
+	fmt.Fprintf(&b, "# HELP %s Unix time the last successful build finished, or 0 if none has.\n# TYPE %s gauge\n", name, name)
+	for _, wm := range m.wikis {
+		var seconds float64
+		if !wm.lastSuccess.IsZero() {
+			seconds = float64(wm.lastSuccess.UnixNano()) / float64(time.Second)
+		}
+		fmt.Fprintf(&b, "%s{%s} %s\n", name, wikiLabel(wm), formatFloat(seconds))
+	}
+
+	name = "gomarkwiki_build_duration_seconds"
+	fmt.Fprintf(&b, "# HELP %s How long builds took.\n# TYPE %s histogram\n", name, name)
+	for _, wm := range m.wikis {
+		var count uint64
+		for i, bound := range durationBuckets {
+			count += wm.durationCounts[i]
+			fmt.Fprintf(&b, "%s_bucket{%s,le=\"%s\"} %d\n", name, wikiLabel(wm), formatFloat(bound), count)
+		}
+		count = wm.buildsCompleted + wm.buildsFailed
+		fmt.Fprintf(&b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, wikiLabel(wm), count)
+		fmt.Fprintf(&b, "%s_sum{%s} %s\n", name, wikiLabel(wm), formatFloat(wm.durationSum))
+		fmt.Fprintf(&b, "%s_count{%s} %d\n", name, wikiLabel(wm), count)
+	}
+
+	counter("gomarkwiki_pages_rendered_total", "Pages generated from Markdown.",
+		func(wm *wikiMetrics) uint64 { return wm.pagesRendered })
+	counter("gomarkwiki_files_copied_total", "Files copied as is.",
+		func(wm *wikiMetrics) uint64 { return wm.filesCopied })
+	counter("gomarkwiki_watcher_events_total", "File system events received in watch mode.",
+		func(wm *wikiMetrics) uint64 { return wm.watchEvents })
+	counter("gomarkwiki_poll_cycles_total", "Poll cycles in watch mode with -poll-interval.",
+		func(wm *wikiMetrics) uint64 { return wm.pollCycles })
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// wikiLabel returns the label that identifies the wiki of wm.
+func wikiLabel(wm *wikiMetrics) string {
+	return `wiki="` + escapeLabelValue(wm.wiki.SourceDir) + `"`
+}
+
+// escapeLabelValue escapes a label value for the text exposition format.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatFloat formats f for the text exposition format.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,136 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getMetrics returns the status code and body of a GET of path from m.
+func getMetrics(t *testing.T, m *Metrics, path string) (int, string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	theWiki, _ := includeTestWiki(t, map[string]string{
+		"index.md":  "# Index",
+		"Page.md":   "# Page",
+		"image.png": "png",
+	})
+	theWiki.PollInterval = 20 * time.Millisecond
+	m := NewMetrics([]*Wiki{theWiki})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- theWiki.Generate(ctx, false, false, true, "test")
+	}()
+
+	// Wait for the initial build and a few poll cycles.
+	label := `{wiki="` + theWiki.SourceDir + `"}`
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, body := getMetrics(t, m, "/metrics")
+		if !strings.Contains(body, "gomarkwiki_poll_cycles_total"+label+" 0\n") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no poll cycles counted:\n%s", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	code, body := getMetrics(t, m, "/metrics")
+	if code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", code)
+	}
+	for _, want := range []string{
+		"# TYPE gomarkwiki_builds_completed_total counter\n",
+		"gomarkwiki_builds_completed_total" + label + " 1\n",
+		"gomarkwiki_builds_failed_total" + label + " 0\n",
+		"gomarkwiki_pages_rendered_total" + label + " 2\n",
+		"gomarkwiki_files_copied_total" + label + " 1\n",
+		"gomarkwiki_watcher_events_total" + label + " 0\n",
+		"# TYPE gomarkwiki_build_duration_seconds histogram\n",
+		`gomarkwiki_build_duration_seconds_bucket{wiki="` + theWiki.SourceDir + `",le="+Inf"} 1` + "\n",
+		"gomarkwiki_build_duration_seconds_count" + label + " 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "gomarkwiki_last_success_timestamp_seconds"+label+" 0\n") {
+		t.Errorf("last success time not set:\n%s", body)
+	}
+	if code, body := getMetrics(t, m, "/healthz"); code != http.StatusOK || body != "ok\n" {
+		t.Errorf("GET /healthz = %d %q, want 200 ok", code, body)
+	}
+
+	// The wiki is unhealthy once its watcher exits.
+	cancel()
+	<-errChan
+	code, body = getMetrics(t, m, "/healthz")
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, "watcher exited") {
+		t.Errorf("GET /healthz after watcher exited = %d %q, want 503", code, body)
+	}
+}
+
+func TestMetricsFailedBuild(t *testing.T) {
+	theWiki, _ := includeTestWiki(t, map[string]string{"index.md": "# Index"})
+	m := NewMetrics([]*Wiki{theWiki})
+
+	theWiki.OnBuild(&BuildResult{Start: time.Now(), Duration: 2 * time.Second}, errors.New("failed"))
+	code, body := getMetrics(t, m, "/healthz")
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, "last build failed") {
+		t.Errorf("GET /healthz after failed build = %d %q, want 503", code, body)
+	}
+	_, body = getMetrics(t, m, "/metrics")
+	label := `wiki="` + theWiki.SourceDir + `"`
+	for _, want := range []string{
+		"gomarkwiki_builds_failed_total{" + label + "} 1\n",
+		"gomarkwiki_build_duration_seconds_bucket{" + label + `,le="1"} 0` + "\n",
+		"gomarkwiki_build_duration_seconds_bucket{" + label + `,le="2.5"} 1` + "\n",
+		"gomarkwiki_build_duration_seconds_sum{" + label + "} 2\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %q:\n%s", want, body)
+		}
+	}
+
+	// A successful build makes it healthy again.
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if code, _ := getMetrics(t, m, "/healthz"); code != http.StatusOK {
+		t.Errorf("GET /healthz after successful build = %d, want 200", code)
+	}
+}
+
+func TestMetricsRemove(t *testing.T) {
+	theWiki, _ := includeTestWiki(t, map[string]string{"index.md": "# Index"})
+	m := NewMetrics([]*Wiki{theWiki})
+
+	theWiki.OnWatch(WatchStopped)
+	if code, _ := getMetrics(t, m, "/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("GET /healthz after watcher exited = %d, want 503", code)
+	}
+	theWiki.OnWatch(WatchStarted)
+	if code, _ := getMetrics(t, m, "/healthz"); code != http.StatusOK {
+		t.Errorf("GET /healthz after watcher restarted = %d, want 200", code)
+	}
+	theWiki.OnWatch(WatchStopped)
+	m.Remove(theWiki)
+	if code, _ := getMetrics(t, m, "/healthz"); code != http.StatusOK {
+		t.Errorf("GET /healthz after wiki removed = %d, want 200", code)
+	}
+	if _, body := getMetrics(t, m, "/metrics"); strings.Contains(body, theWiki.SourceDir) {
+		t.Errorf("metrics still have removed wiki:\n%s", body)
+	}
+}
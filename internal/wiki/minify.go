@@ -0,0 +1,253 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"bytes"
+	"strings"
+)
+
+// minify returns data minified with minifier, and prints how much was saved
+// for the dest file name.
+func (wiki Wiki) minify(name string, data []byte, minifier func([]byte) []byte) []byte {
+	minified := minifier(data)
+	saved := len(data) - len(minified)
+	percent := 0.0
+	if len(data) > 0 {
+		percent = 100 * float64(saved) / float64(len(data))
+	}
+	wiki.log.Verbose("Minified '%s', saving %d bytes (%.1f%%)", name, saved, percent)
+	return minified
+}
+
+// rawHTMLElements are the elements whose content is left as is when HTML is
+// minified, since its whitespace is significant or it isn't HTML. Inline
+// code elements are included, since code in them is often shown in a
+// monospace font with white-space: pre by stylesheets.
+var rawHTMLElements = map[string]bool{
+	"pre": true, "textarea": true, "script": true, "style": true,
+	"code": true, "kbd": true, "samp": true, "tt": true,
+}
+
+// blockHTMLElements are the elements that whitespace next to can be removed
+// when HTML is minified, since browsers don't render it.
+var blockHTMLElements = map[string]bool{
+	"!doctype": true, "html": true, "head": true, "body": true, "meta": true, "link": true,
+	"title": true, "base": true, "script": true, "style": true, "noscript": true,
+	"div": true, "p": true, "ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "hr": true,
+	"table": true, "caption": true, "colgroup": true, "col": true, "thead": true, "tbody": true,
+	"tfoot": true, "tr": true, "td": true, "th": true, "blockquote": true, "pre": true,
+	"section": true, "article": true, "aside": true, "header": true, "footer": true,
+	"nav": true, "main": true, "figure": true, "figcaption": true, "details": true,
+	"summary": true, "form": true, "fieldset": true, "legend": true, "address": true,
+}
+
+// minifyHTML returns src with comments removed and whitespace collapsed.
+// Runs of whitespace in text become a single space, or newline if they had
+// one, and whitespace next to block elements is removed. Tags, and the
+// content of the elements in rawHTMLElements, are left as is.
+// Conditional comments are kept.
+func minifyHTML(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	prevBlock := true // Whether the last tag written was a block element, or there's none
+	for i := 0; i < len(src); {
+		if src[i] != '<' || !isHTMLTagStart(src[i:]) {
+			// Text, up to the next tag or comment.
+			end := i + 1
+			for end < len(src) && !(src[end] == '<' && isHTMLTagStart(src[end:])) {
+				end++
+			}
+			text := collapseHTMLSpace(src[i:end])
+			if prevBlock {
+				text = bytes.TrimLeft(text, " \n")
+			}
+			if end == len(src) || blockHTMLElements[htmlTagName(src[end:])] {
+				text = bytes.TrimRight(text, " \n")
+			}
+			out = append(out, text...)
+			if len(text) > 0 {
+				prevBlock = false
+			}
+			i = end
+			continue
+		}
+
+		// Comments, other than conditional comments, are removed.
+		if bytes.HasPrefix(src[i:], []byte("<!--")) {
+			end := bytes.Index(src[i+4:], []byte("-->"))
+			if end < 0 {
+				end = len(src)
+			} else {
+				end += i + 4 + len("-->")
+			}
+			if bytes.HasPrefix(src[i+4:], []byte("[if")) || bytes.HasPrefix(src[i+4:], []byte("<![endif]")) {
+				out = append(out, src[i:end]...)
+			}
+			i = end
+			continue
+		}
+
+		// Tags are written as is, along with the content of raw elements.
+		end := htmlTagEnd(src, i)
+		out = append(out, src[i:end]...)
+		name := htmlTagName(src[i:])
+		opening := src[i+1] != '/' && src[end-2] != '/'
+		prevBlock = blockHTMLElements[name]
+		i = end
+		if rawHTMLElements[name] && opening {
+			closing := indexFold(src[i:], "</"+name)
+			if closing < 0 {
+				closing = len(src) - i
+			}
+			out = append(out, src[i:i+closing]...)
+			i += closing
+		}
+	}
+	return out
+}
+
+// isHTMLTagStart returns whether src, which starts with <, starts a tag,
+// closing tag, comment, or doctype, rather than being a < in text.
+func isHTMLTagStart(src []byte) bool {
+	if len(src) < 2 {
+		return false
+	}
+	c := src[1]
+	return c == '/' || c == '!' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// htmlTagName returns the lowercased name of the tag at the start of src,
+// without the / of a closing tag.
+func htmlTagName(src []byte) string {
+	if len(src) < 2 || src[0] != '<' {
+		return ""
+	}
+	start := 1
+	if src[start] == '/' {
+		start++
+	}
+	end := start
+	for end < len(src) && !strings.ContainsRune(" \t\r\n/>", rune(src[end])) {
+		end++
+	}
+	return strings.ToLower(string(src[start:end]))
+}
+
+// htmlTagEnd returns the index just past the end of the tag that starts at
+// src[start], skipping over quoted attribute values.
+func htmlTagEnd(src []byte, start int) int {
+	var quote byte
+	for i := start + 1; i < len(src); i++ {
+		switch c := src[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return len(src)
+}
+
+// collapseHTMLSpace returns text with each run of whitespace replaced with a
+// newline if it had one, or else a space.
+func collapseHTMLSpace(text []byte) []byte {
+	out := make([]byte, 0, len(text))
+	for i := 0; i < len(text); {
+		if !isHTMLSpace(text[i]) {
+			out = append(out, text[i])
+			i++
+			continue
+		}
+		newline := false
+		for ; i < len(text) && isHTMLSpace(text[i]); i++ {
+			newline = newline || text[i] == '\n'
+		}
+		if newline {
+			out = append(out, '\n')
+		} else {
+			out = append(out, ' ')
+		}
+	}
+	return out
+}
+
+// isHTMLSpace returns whether c is HTML whitespace.
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// indexFold returns the index of the first instance of the ASCII string s
+// in src, ignoring case, or -1 if there's none.
+func indexFold(src []byte, s string) int {
+	for i := 0; i+len(s) <= len(src); i++ {
+		if strings.EqualFold(string(src[i:i+len(s)]), s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// minifyCSS returns src with comments removed and whitespace collapsed.
+// Whitespace is removed where it's never significant, next to braces,
+// semicolons, commas, and child combinators, and after colons, and a
+// semicolon before a closing brace is removed. Strings, and comments that
+// start with /*!, such as licenses, are kept.
+func minifyCSS(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	space := false // Whether whitespace was skipped since the last byte written
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				end = len(src)
+			} else {
+				end += i + 2 + len("*/")
+			}
+			if i+2 < len(src) && src[i+2] == '!' {
+				out = appendCSS(out, space, src[i:end]...)
+				space = false
+			} else {
+				space = true // A comment separates tokens like whitespace
+			}
+			i = end
+		case isHTMLSpace(c):
+			space = true
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(src) && src[end] != c && src[end] != '\n' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(src))
+			out = appendCSS(out, space, src[i:end]...)
+			space = false
+			i = end
+		default:
+			if c == '}' && len(out) > 0 && out[len(out)-1] == ';' {
+				out = out[:len(out)-1]
+			}
+			out = appendCSS(out, space, c)
+			space = false
+			i++
+		}
+	}
+	return out
+}
+
+// appendCSS appends data to out, preceded by a space if space is set and
+// the space is significant.
+func appendCSS(out []byte, space bool, data ...byte) []byte {
+	if space && len(out) > 0 && !strings.ContainsRune("{};,>:(", rune(out[len(out)-1])) && !strings.ContainsRune("{};,>)", rune(data[0])) {
+		out = append(out, ' ')
+	}
+	return append(out, data...)
+}
@@ -0,0 +1,88 @@
+package wiki
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"block whitespace", "<div>\n  <p>\n    Some   text\n  </p>\n</div>\n", "<div><p>Some text</p></div>"},
+		{"inline whitespace", "<p>a <em>b</em>  <strong>c</strong>\n</p>", "<p>a <em>b</em> <strong>c</strong></p>"},
+		{"newlines", "<p>one\n\n  two</p>", "<p>one\ntwo</p>"},
+		{"comments", "<p>a<!-- note --> b</p>\n<!-- x -->\n<p>c</p>", "<p>a b</p><p>c</p>"},
+		{"conditional comment", "<!--[if IE]><p>IE</p><![endif]-->", "<!--[if IE]><p>IE</p><![endif]-->"},
+		{"pre", "<pre><code>  a\n\n    b  </code></pre>\n<p> x </p>", "<pre><code>  a\n\n    b  </code></pre><p>x</p>"},
+		{"textarea", "<div>\n<TEXTAREA rows=\"3\">  a\n  b </TEXTAREA>\n</div>", "<div><TEXTAREA rows=\"3\">  a\n  b </TEXTAREA></div>"},
+		{"code", "<p>x <code>a    b</code> y</p>", "<p>x <code>a    b</code> y</p>"},
+		{"kbd", "<p><KBD>Ctrl  +\n  C</KBD> and <samp>a  b</samp> <tt>c  d</tt></p>", "<p><KBD>Ctrl  +\n  C</KBD> and <samp>a  b</samp> <tt>c  d</tt></p>"},
+		{"script", "<script>\n  if (a < b) { x(); }\n</script>", "<script>\n  if (a < b) { x(); }\n</script>"},
+		{"attributes", "<a title=\"a  >  b\"  href=x>link</a>", "<a title=\"a  >  b\"  href=x>link</a>"},
+		{"less than", "<p>a < b</p>", "<p>a < b</p>"},
+		{"doctype", "<!doctype html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\" />\n</head>", "<!doctype html><html lang=\"en\"><head><meta charset=\"utf-8\" /></head>"},
+	}
+	for _, tt := range tests {
+		if got := string(minifyHTML([]byte(tt.in))); got != tt.want {
+			t.Errorf("%s: minifyHTML(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMinifyCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"rules", "body {\n  color: red;\n  margin: 0 auto;\n}\n", "body{color:red;margin:0 auto}"},
+		{"selectors", "a:hover, ul > li  p,\n.x .y {}", "a:hover,ul>li p,.x .y{}"},
+		{"descendant pseudo", "div :first-child { x: y }", "div :first-child{x:y}"},
+		{"comments", "/* theme */\na { b: c; /* why */ }\n/*! License */", "a{b:c}/*! License */"},
+		{"strings", "a::before { content: \"  ;  } \"; }", "a::before{content:\"  ;  } \"}"},
+		{"media", "@media screen and (max-width: 600px) {\n  a { b: calc(1px + 2px); }\n}", "@media screen and (max-width:600px){a{b:calc(1px + 2px)}}"},
+	}
+	for _, tt := range tests {
+		if got := string(minifyCSS([]byte(tt.in))); got != tt.want {
+			t.Errorf("%s: minifyCSS(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMinify(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md": "# Title\n\nSome   text.\n\n```\n  indented\n\n    code\n```\n",
+	})
+	theWiki.Minify = true
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	page, err := os.ReadFile(filepath.Join(destDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<h1 id=\"title\">Title</h1><p>Some text.</p>", "<pre><code>  indented\n\n    code\n</code></pre>"} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("index.html doesn't contain %q:\n%s", want, page)
+		}
+	}
+
+	css, err := os.ReadFile(filepath.Join(destDir, "github-style.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := embeddedFileSystem.ReadFile("static/github-style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(css) >= len(original) || strings.Contains(string(css), "\n  ") {
+		t.Errorf("github-style.css wasn't minified: %d bytes, from %d", len(css), len(original))
+	}
+}
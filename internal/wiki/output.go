@@ -0,0 +1,493 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output is where a wiki is generated. Files are named by slash-separated
+// paths relative to the root of the output, such as "Foo/Bar.html".
+type Output interface {
+	// Stat returns info on the file name. If there's no such file the error
+	// satisfies errors.Is(err, fs.ErrNotExist).
+	Stat(name string) (fs.FileInfo, error)
+
+	// WriteFile writes the contents of r to the file name, creating any
+	// directories needed and replacing any file that's there. A file must
+	// never be left partially written, on error or cancellation of ctx.
+	WriteFile(ctx context.Context, name string, r io.Reader, mode fs.FileMode) error
+
+	// Clean deletes the files for which keep returns false, and any
+	// directories that are left empty. Returns the names of the files deleted.
+	Clean(ctx context.Context, keep func(name string) bool) ([]string, error)
+}
+
+// buildOutput is implemented by outputs that need to prepare for each build
+// of a wiki, such as directories that are created when first needed.
+type buildOutput interface {
+	// beginBuild is called before anything is written by a build. In a dry
+	// run it must not change anything.
+	beginBuild(dryRun bool) error
+}
+
+// fileRemover is implemented by outputs that can delete a single file, such
+// as a .gz sibling that's no longer wanted.
+type fileRemover interface {
+	// removeFile deletes the file name. It's not an error if there's no such
+	// file.
+	removeFile(name string) error
+}
+
+// outputFileInfo is the fs.FileInfo for files in outputs that aren't
+// directories on disk.
+type outputFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (info outputFileInfo) Name() string       { return path.Base(info.name) }
+func (info outputFileInfo) Size() int64        { return info.size }
+func (info outputFileInfo) Mode() fs.FileMode  { return info.mode }
+func (info outputFileInfo) ModTime() time.Time { return info.modTime }
+func (info outputFileInfo) IsDir() bool        { return false }
+func (info outputFileInfo) Sys() any           { return nil }
+
+// readAll reads r, checking for cancellation of ctx between chunks.
+func readAll(ctx context.Context, r io.Reader) ([]byte, error) {
+	var data []byte
+	buf := make([]byte, 32*1024)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("copy cancelled: %w", err)
+		}
+		n, err := r.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read source: %v", err)
+		}
+	}
+}
+
+// MemOutput is an Output that keeps files in memory.
+type MemOutput struct {
+	mu    sync.Mutex
+	files map[string]memFile
+}
+
+// memFile is a file in a MemOutput.
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemOutput returns an empty MemOutput.
+func NewMemOutput() *MemOutput {
+	return &MemOutput{files: make(map[string]memFile)}
+}
+
+// Stat returns info on the file name.
+func (o *MemOutput) Stat(name string) (fs.FileInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	file, ok := o.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return outputFileInfo{name, int64(len(file.data)), file.mode, file.modTime}, nil
+}
+
+// WriteFile stores the contents of r as the file name.
+func (o *MemOutput) WriteFile(ctx context.Context, name string, r io.Reader, mode fs.FileMode) error {
+	data, err := readAll(ctx, r)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.files[name] = memFile{data: data, mode: mode, modTime: time.Now()}
+	return nil
+}
+
+// removeFile deletes the file name.
+func (o *MemOutput) removeFile(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.files, name)
+	return nil
+}
+
+// Clean deletes the files for which keep returns false.
+func (o *MemOutput) Clean(ctx context.Context, keep func(name string) bool) ([]string, error) {
+	return o.clean(ctx, keep, false)
+}
+
+// dryRunClean returns the files that Clean would delete.
+func (o *MemOutput) dryRunClean(ctx context.Context, keep func(name string) bool) ([]string, error) {
+	return o.clean(ctx, keep, true)
+}
+
+// clean deletes the files for which keep returns false, or with dryRun just
+// returns them.
+func (o *MemOutput) clean(ctx context.Context, keep func(name string) bool, dryRun bool) ([]string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var deleted []string
+	for _, name := range slices.Sorted(maps.Keys(o.files)) {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		if !keep(name) {
+			if !dryRun {
+				delete(o.files, name)
+			}
+			deleted = append(deleted, name)
+		}
+	}
+	return deleted, nil
+}
+
+// ReadFile returns the contents of the file name.
+func (o *MemOutput) ReadFile(name string) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	file, ok := o.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return slices.Clone(file.data), nil
+}
+
+// Names returns the names of the files, sorted.
+func (o *MemOutput) Names() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.Sorted(maps.Keys(o.files))
+}
+
+// ArchiveOutput is an Output that writes files to a zip or tar archive.
+// Files are spooled to a temp file until the archive is written, and are
+// then written sorted by name, with the same modification time, so that the same
+// files always make the same archive. The modification time is taken from
+// the SOURCE_DATE_EPOCH environment variable, in seconds since the epoch, or
+// is 1980-01-01 00:00:00 UTC if that isn't set. Since an archive can't be
+// updated, every file is treated as out of date, and Clean does nothing.
+type ArchiveOutput struct {
+	mu     sync.Mutex
+	format string    // One of the archive* formats
+	w      io.Writer // Where Close writes the archive, for archives not written to a file
+	path   string    // File the archive is written to at the end of each build, if any
+	spool  *os.File  // Temp file holding the contents of the files, one after another
+	size   int64     // Size of spool
+	files  map[string]archiveFile
+}
+
+// archiveFile is a file in an ArchiveOutput, with its contents in the spool.
+type archiveFile struct {
+	offset int64
+	size   int64
+	mode   fs.FileMode
+}
+
+// Archive formats.
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// archiveFormat returns the format of the archive at path, going by its
+// extension, or "" if it isn't an archive.
+func archiveFormat(path string) string {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(lower, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return archiveTarGz
+	}
+	return ""
+}
+
+// IsArchivePath returns whether path names an archive that a wiki can be
+// generated to, with NewArchiveFileOutput: a file ending with .zip, .tar,
+// .tar.gz, or .tgz.
+func IsArchivePath(path string) bool {
+	return archiveFormat(path) != ""
+}
+
+// NewZipOutput returns an ArchiveOutput that writes a zip archive to w when
+// closed.
+func NewZipOutput(w io.Writer) *ArchiveOutput {
+	return &ArchiveOutput{format: archiveZip, w: w, files: make(map[string]archiveFile)}
+}
+
+// NewTarOutput returns an ArchiveOutput that writes a tar archive to w when
+// closed.
+func NewTarOutput(w io.Writer) *ArchiveOutput {
+	return &ArchiveOutput{format: archiveTar, w: w, files: make(map[string]archiveFile)}
+}
+
+// NewArchiveFileOutput returns an ArchiveOutput that writes the archive at
+// path, in the format given by its extension as for IsArchivePath. The
+// archive is replaced at the end of each build with one that holds just the
+// files of that build, so there's nothing to clean, and there's no need to
+// call Close.
+func NewArchiveFileOutput(path string) (*ArchiveOutput, error) {
+	format := archiveFormat(path)
+	if format == "" {
+		return nil, fmt.Errorf("'%s' isn't a .zip, .tar, .tar.gz, or .tgz archive", path)
+	}
+	return &ArchiveOutput{format: format, path: path, files: make(map[string]archiveFile)}, nil
+}
+
+// Stat always reports that name doesn't exist, so that it's written.
+func (o *ArchiveOutput) Stat(name string) (fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// WriteFile adds the contents of r to the archive as the file name. The
+// contents are appended to the spool, and the file is added only once
+// they've been read in full, so that a failed read doesn't leave a partial
+// file in the archive.
+func (o *ArchiveOutput) WriteFile(ctx context.Context, name string, r io.Reader, mode fs.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.files[name]; ok {
+		return fmt.Errorf("'%s' was already written to the archive", name)
+	}
+	if o.spool == nil {
+		spool, err := os.CreateTemp("", "gomarkwiki-archive-*")
+		if err != nil {
+			return fmt.Errorf("failed to create archive spool file: %v", err)
+		}
+		o.spool = spool
+		o.size = 0
+	}
+
+	// Anything written before a failure is overwritten by the next file.
+	n, err := io.Copy(io.NewOffsetWriter(o.spool, o.size), &contextReader{ctx: ctx, r: r})
+	if err != nil {
+		return fmt.Errorf("failed to write '%s' to archive spool file: %w", name, err)
+	}
+	o.files[name] = archiveFile{offset: o.size, size: n, mode: mode}
+	o.size += n
+	return nil
+}
+
+// contextReader is a reader that fails once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read reads from the underlying reader, unless the context is done.
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, fmt.Errorf("copy cancelled: %w", err)
+	}
+	return r.r.Read(p)
+}
+
+// discard forgets the files written so far, and removes the spool.
+// The caller must hold o.mu.
+func (o *ArchiveOutput) discard() {
+	o.files = make(map[string]archiveFile)
+	if o.spool != nil {
+		o.spool.Close()
+		os.Remove(o.spool.Name())
+		o.spool = nil
+		o.size = 0
+	}
+}
+
+// Clean does nothing, since files are never removed from an archive.
+func (o *ArchiveOutput) Clean(ctx context.Context, keep func(name string) bool) ([]string, error) {
+	return nil, nil
+}
+
+// dryRunClean does nothing, like Clean.
+func (o *ArchiveOutput) dryRunClean(ctx context.Context, keep func(name string) bool) ([]string, error) {
+	return nil, nil
+}
+
+// beginBuild starts a new archive for an archive written to a file, so that
+// it holds just the files of this build, and creates the directory it's in.
+func (o *ArchiveOutput) beginBuild(dryRun bool) error {
+	if o.path == "" {
+		return nil
+	}
+	o.mu.Lock()
+	o.discard()
+	o.mu.Unlock()
+	if dryRun {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(o.path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory '%s': %v", filepath.Dir(o.path), err)
+	}
+	return nil
+}
+
+// endBuild is called at the end of each build of an archive that's written
+// to a file. If write is set, it writes the archive, atomically replacing the
+// archive from any earlier build. Either way the spool is removed.
+func (o *ArchiveOutput) endBuild(ctx context.Context, write bool) (err error) {
+	if o.path == "" {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	defer o.discard()
+	if !write {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Write to a temp file in the same directory, and then rename it, so that
+	// the archive is never left partially written.
+	dir := filepath.Dir(o.path)
+	tempFile, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file in '%s': %v", dir, err)
+	}
+	tempPath := tempFile.Name()
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	if err := o.writeArchive(tempFile); err != nil {
+		return fmt.Errorf("failed to write archive '%s': %v", o.path, err)
+	}
+	if err := tempFile.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to set permissions on temp file '%s': %v", tempPath, err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file '%s': %v", tempPath, err)
+	}
+	if err := os.Rename(tempPath, o.path); err != nil {
+		return fmt.Errorf("failed to rename temp file '%s' to '%s': %v", tempPath, o.path, err)
+	}
+	return nil
+}
+
+// Close writes the archive to the writer it was created with, and removes
+// the spool. It doesn't close the writer. For an archive written to a file,
+// it does nothing.
+func (o *ArchiveOutput) Close() error {
+	if o.path != "" {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	defer o.discard()
+	return o.writeArchive(o.w)
+}
+
+// contents returns a reader for the contents of file, from the spool.
+func (o *ArchiveOutput) contents(file archiveFile) io.Reader {
+	return io.NewSectionReader(o.spool, file.offset, file.size)
+}
+
+// writeArchive writes the files to w as an archive, sorted by name, reading
+// their contents from the spool one at a time. The caller must hold o.mu.
+func (o *ArchiveOutput) writeArchive(w io.Writer) error {
+	modTime := archiveModTime()
+	names := slices.Sorted(maps.Keys(o.files))
+
+	if o.format == archiveZip {
+		zw := zip.NewWriter(w)
+		for _, name := range names {
+			file := o.files[name]
+			header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+			header.SetMode(file.mode)
+			fw, err := zw.CreateHeader(header)
+			if err != nil {
+				return fmt.Errorf("failed to add '%s' to zip archive: %v", name, err)
+			}
+			if _, err := io.Copy(fw, o.contents(file)); err != nil {
+				return fmt.Errorf("failed to write '%s' to zip archive: %v", name, err)
+			}
+		}
+		return zw.Close()
+	}
+
+	var gw *gzip.Writer
+	if o.format == archiveTarGz {
+		gw = gzip.NewWriter(w)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		file := o.files[name]
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     file.size,
+			Mode:     int64(file.mode.Perm()),
+			ModTime:  modTime,
+			Format:   tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to add '%s' to tar archive: %v", name, err)
+		}
+		if _, err := io.Copy(tw, o.contents(file)); err != nil {
+			return fmt.Errorf("failed to write '%s' to tar archive: %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gw != nil {
+		return gw.Close()
+	}
+	return nil
+}
+
+// archiveModTime returns the modification time of the files in archives:
+// the time given by SOURCE_DATE_EPOCH, if it's set to a valid number of
+// seconds, or else the earliest time a zip archive can hold.
+func archiveModTime() time.Time {
+	if modTime, ok := sourceDateEpoch(); ok {
+		return modTime
+	}
+	return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// sourceDateEpoch returns the time given by the SOURCE_DATE_EPOCH
+// environment variable, in seconds since the epoch, and whether it's set to a
+// valid number.
+func sourceDateEpoch() (time.Time, bool) {
+	epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(epoch, 0).UTC(), true
+}
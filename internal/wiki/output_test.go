@@ -0,0 +1,256 @@
+package wiki
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+// testSourceFS returns an in-memory wiki source.
+func testSourceFS() fstest.MapFS {
+	modTime := time.Now().Add(-time.Hour)
+	return fstest.MapFS{
+		"substitution-strings.csv":  {Data: []byte("SITE,example.com\n"), ModTime: modTime},
+		"ignore.txt":                {Data: []byte("*.tmp\n"), ModTime: modTime},
+		"content/index.md":          {Data: []byte("# Index\n{{SITE}}\n\n{{include: Part.md}}"), ModTime: modTime},
+		"content/Part.md":           {Data: []byte("Included part"), ModTime: modTime},
+		"content/Sub/Page.markdown": {Data: []byte("# Page"), ModTime: modTime},
+		"content/Sub/image.png":     {Data: []byte("png"), Mode: 0600, ModTime: modTime},
+		"content/notes.tmp":         {Data: []byte("tmp"), ModTime: modTime},
+	}
+}
+
+func TestGenerateFromFSToMemOutput(t *testing.T) {
+	out := NewMemOutput()
+	theWiki, err := NewWikiFS(testSourceFS(), out, util.DiscardLogger())
+	if err != nil {
+		t.Fatalf("NewWikiFS failed: %v", err)
+	}
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	want := []string{"Part.html", "Sub/Page.html", "Sub/image.png", "github-style.css", "index.html", "style.css"}
+	if got := out.Names(); !slices.Equal(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+	index, err := out.ReadFile("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"example.com", "Included part"} {
+		if !strings.Contains(string(index), s) {
+			t.Errorf("index.html does not contain %q:\n%s", s, index)
+		}
+	}
+	if info, err := out.Stat("Sub/image.png"); err != nil || info.Mode() != 0600 {
+		t.Errorf("Stat(Sub/image.png) = %v, %v; want mode 0600", info, err)
+	}
+
+	// Up to date files are skipped, and clean removes files with no source.
+	if err := out.WriteFile(context.Background(), "Old/stale.html", strings.NewReader("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(theWiki.result.Generated) != 0 || len(theWiki.result.Copied) != 0 {
+		t.Errorf("second generate wrote %v and %v, want nothing", theWiki.result.Generated, theWiki.result.Copied)
+	}
+	if !slices.Equal(theWiki.result.Deleted, []string{"Old/stale.html"}) {
+		t.Errorf("Deleted = %v, want [Old/stale.html]", theWiki.result.Deleted)
+	}
+
+	// A wiki generated from an fs.FS can't be watched.
+	if err := theWiki.Generate(context.Background(), false, false, true, "test"); err == nil {
+		t.Errorf("Generate with watch succeeded for an fs.FS source")
+	}
+}
+
+func TestNewWikiFSMissingContent(t *testing.T) {
+	source := fstest.MapFS{"index.md": {Data: []byte("# Index")}}
+	if _, err := NewWikiFS(source, NewMemOutput(), util.DiscardLogger()); err == nil {
+		t.Errorf("NewWikiFS succeeded without a content directory")
+	}
+}
+
+func TestArchiveOutput(t *testing.T) {
+	for _, format := range []string{"zip", "tar"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			var out *ArchiveOutput
+			if format == "zip" {
+				out = NewZipOutput(&buf)
+			} else {
+				out = NewTarOutput(&buf)
+			}
+			theWiki, err := NewWikiFS(testSourceFS(), out, util.DiscardLogger())
+			if err != nil {
+				t.Fatalf("NewWikiFS failed: %v", err)
+			}
+			if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+
+			// Files can be written just once, and a failed write leaves
+			// nothing behind.
+			ctx := context.Background()
+			if err := out.WriteFile(ctx, "index.html", strings.NewReader(""), 0644); err == nil {
+				t.Errorf("writing index.html twice succeeded")
+			}
+			failing := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("read failed")))
+			if err := out.WriteFile(ctx, "failed.txt", failing, 0644); err == nil {
+				t.Errorf("writing failed.txt succeeded")
+			}
+			if err := out.WriteFile(ctx, "after.txt", strings.NewReader("after"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := out.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Read back the archive.
+			files := map[string]string{}
+			if format == "zip" {
+				zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, file := range zr.File {
+					rc, err := file.Open()
+					if err != nil {
+						t.Fatal(err)
+					}
+					data, _ := io.ReadAll(rc)
+					rc.Close()
+					files[file.Name] = string(data)
+				}
+			} else {
+				tr := tar.NewReader(&buf)
+				for {
+					header, err := tr.Next()
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						t.Fatal(err)
+					}
+					data, _ := io.ReadAll(tr)
+					files[header.Name] = string(data)
+				}
+			}
+
+			if len(files) != 7 {
+				t.Errorf("archive has %d files, want 7: %v", len(files), files)
+			}
+			if files["after.txt"] != "after" {
+				t.Errorf("after.txt = %q, want %q", files["after.txt"], "after")
+			}
+			if files["Sub/image.png"] != "png" {
+				t.Errorf("Sub/image.png = %q, want %q", files["Sub/image.png"], "png")
+			}
+			if !strings.Contains(files["Sub/Page.html"], "<title>Page</title>") {
+				t.Errorf("Sub/Page.html is missing its title:\n%s", files["Sub/Page.html"])
+			}
+		})
+	}
+}
+
+func TestArchiveFileOutput(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	sourceDir := t.TempDir()
+	for relPath, content := range map[string]string{
+		"content/index.md":    "# Index\n",
+		"content/Sub/Page.md": "# Page\n",
+		"content/logo.png":    "png",
+	} {
+		path := filepath.Join(sourceDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A dest that's an archive path is written as an archive, creating the
+	// directory it's in.
+	archivePath := filepath.Join(t.TempDir(), "out", "site.tar.gz")
+	theWiki, err := NewWiki(sourceDir, archivePath, util.DiscardLogger())
+	if err != nil {
+		t.Fatalf("NewWiki failed: %v", err)
+	}
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	first, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files are sorted, with the time from SOURCE_DATE_EPOCH.
+	archiveNames := func(data []byte) []string {
+		t.Helper()
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gr)
+		var names []string
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return names
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, header.Name)
+			if !header.ModTime.Equal(time.Unix(1700000000, 0)) {
+				t.Errorf("%s has modification time %v", header.Name, header.ModTime)
+			}
+		}
+	}
+	want := []string{"Sub/Page.html", "github-style.css", "index.html", "logo.png", "style.css"}
+	if names := archiveNames(first); !slices.Equal(names, want) {
+		t.Errorf("archive has %v, want %v", names, want)
+	}
+
+	// Building again makes the same archive, holding just the current files.
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if second, err := os.ReadFile(archivePath); err != nil || !bytes.Equal(first, second) {
+		t.Errorf("second build made a different archive: %v", err)
+	}
+	if err := os.Remove(filepath.Join(sourceDir, "content", "logo.png")); err != nil {
+		t.Fatal(err)
+	}
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	third, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := archiveNames(third); slices.Contains(names, "logo.png") {
+		t.Errorf("archive still holds logo.png after it was removed: %v", names)
+	}
+
+	// An archive is only written whole, so it can't be watched.
+	if err := theWiki.Generate(context.Background(), false, false, true, "test"); err == nil {
+		t.Errorf("Generate with watch succeeded for an archive")
+	}
+}
@@ -0,0 +1,361 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+// DefaultPluginTimeout is how long a plugin may take to answer a request
+// before it's killed, when Wiki.PluginTimeout isn't set.
+const DefaultPluginTimeout = 30 * time.Second
+
+// pluginCloseWait is how long a plugin has to exit once its stdin is closed,
+// before it's killed.
+const pluginCloseWait = time.Second
+
+// plugin is an external command that transforms the markdown of pages that
+// match its pattern.
+type plugin struct {
+	pattern *IgnorePattern // Gitignore-style pattern for the pages it applies to
+	command string         // Run with the shell
+}
+
+// pluginRequest is what's written to a plugin for each page, as one line of
+// JSON.
+type pluginRequest struct {
+	Path        string      `json:"path"`        // Slash-separated path of the page, relative to the content dir
+	SourcePath  string      `json:"source_path"` // Full path of the page
+	Title       string      `json:"title"`
+	FrontMatter frontMatter `json:"front_matter"` // Empty if the page has none
+	Markdown    string      `json:"markdown"`     // After front matter is removed, and includes and substitutions
+}
+
+// pluginResponse is what a plugin writes back for each page, as one line of
+// JSON. It has exactly one of its fields set.
+type pluginResponse struct {
+	Markdown *string `json:"markdown"` // Markdown to pass to the next plugin, or to render
+	HTML     *string `json:"html"`     // The page's HTML body, which skips the later plugins and rendering
+	Error    *string `json:"error"`    // Why the page couldn't be transformed
+}
+
+// pluginRunner runs the plugins of a wiki, keeping each plugin's process
+// running between pages and, in watch mode, between generations. It's
+// shared by copies of the Wiki.
+type pluginRunner struct {
+	mu        sync.Mutex
+	loaded    bool                      // Whether plugins.csv has been loaded
+	config    []plugin                  // Plugins from plugins.csv, in the order they're chained
+	processes map[string]*pluginProcess // Running processes, by command
+}
+
+// loadPlugins loads the plugins of a wiki, from its plugins.csv, and stops
+// the processes of plugins that are no longer listed. It returns whether the
+// plugins changed since they were last loaded. Like hooks, plugins are only
+// run for wikis with a source directory on disk.
+func (wiki *Wiki) loadPlugins() (bool, error) {
+	if wiki.plugins == nil {
+		wiki.plugins = &pluginRunner{}
+	}
+	var plugins []plugin
+	if wiki.sourceIsDir {
+		const pluginsFileName = "plugins.csv"
+		pluginsPath := filepath.Join(wiki.SourceDir, pluginsFileName)
+		pairs, err := util.LoadStringPairs(pluginsPath)
+		if err != nil {
+			return false, fmt.Errorf("failed to load plugins from '%s': %v", pluginsPath, err)
+		}
+		for i, pair := range pairs {
+			patternText := strings.TrimSpace(pair[0])
+			command := strings.TrimSpace(pair[1])
+			pattern, err := ParseIgnorePattern(patternText)
+			if err != nil || pattern == nil || pattern.isNegation {
+				return false, fmt.Errorf("invalid plugin at line %d of '%s': invalid pattern %q", i+1, pluginsPath, patternText)
+			}
+			if command == "" {
+				return false, fmt.Errorf("invalid plugin at line %d of '%s': command is empty", i+1, pluginsPath)
+			}
+			plugins = append(plugins, plugin{pattern: pattern, command: command})
+		}
+	}
+
+	r := wiki.plugins
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changed := !slices.EqualFunc(r.config, plugins, func(a, b plugin) bool {
+		return a.pattern.original == b.pattern.original && a.command == b.command
+	})
+	r.loaded = true
+	r.config = plugins
+	for command, process := range r.processes {
+		if !slices.ContainsFunc(plugins, func(p plugin) bool { return p.command == command }) {
+			process.close()
+			delete(r.processes, command)
+		}
+	}
+	return changed, nil
+}
+
+// closePlugins stops the processes of the wiki's plugins.
+func (wiki *Wiki) closePlugins() {
+	if r := wiki.plugins; r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.closeProcesses()
+	}
+}
+
+// clear removes all plugins, so that none are run, and stops their
+// processes.
+func (r *pluginRunner) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config = nil
+	r.closeProcesses()
+}
+
+// closeProcesses stops all running processes. r.mu must be held.
+func (r *pluginRunner) closeProcesses() {
+	for command, process := range r.processes {
+		process.close()
+		delete(r.processes, command)
+	}
+}
+
+// hasPlugins returns whether any plugins apply to the page at relPath,
+// slash-separated and relative to the content dir.
+func (wiki Wiki) hasPlugins(relPath string) bool {
+	r := wiki.plugins
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, plugin := range r.config {
+		if plugin.pattern.Matches(relPath, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// runPlugins passes markdown through the plugins whose patterns match the
+// page described by request, in order. It returns the transformed markdown,
+// or the HTML body of the page if a plugin returned HTML.
+func (wiki Wiki) runPlugins(ctx context.Context, request pluginRequest) (markdown string, html *string, err error) {
+	markdown = request.Markdown
+	r := wiki.plugins
+	if r == nil {
+		return markdown, nil, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, plugin := range r.config {
+		if !plugin.pattern.Matches(request.Path, false) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+
+		request.Markdown = markdown
+		response, err := wiki.callPlugin(ctx, plugin.command, request)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", nil, ctx.Err()
+			}
+			return "", nil, fmt.Errorf("plugin '%s' failed for '%s': %v", plugin.command, request.SourcePath, err)
+		}
+		switch {
+		case response.Error != nil:
+			return "", nil, fmt.Errorf("plugin '%s' failed for '%s': %s", plugin.command, request.SourcePath, *response.Error)
+		case response.HTML != nil:
+			return "", response.HTML, nil
+		case response.Markdown != nil:
+			markdown = *response.Markdown
+		default:
+			return "", nil, fmt.Errorf("plugin '%s' returned neither markdown, html, nor error for '%s'", plugin.command, request.SourcePath)
+		}
+	}
+	return markdown, nil, nil
+}
+
+// callPlugin sends request to the process for command, starting it if it
+// isn't running, and returns its response. A process that doesn't respond
+// within the plugin timeout, or that fails, is stopped, to be started again
+// for the next request. r.mu must be held.
+func (wiki Wiki) callPlugin(ctx context.Context, command string, request pluginRequest) (*pluginResponse, error) {
+	r := wiki.plugins
+	process := r.processes[command]
+	if process == nil {
+		var err error
+		if process, err = wiki.startPlugin(command); err != nil {
+			return nil, err
+		}
+		if r.processes == nil {
+			r.processes = make(map[string]*pluginProcess)
+		}
+		r.processes[command] = process
+	}
+
+	timeout := wiki.PluginTimeout
+	if timeout <= 0 {
+		timeout = DefaultPluginTimeout
+	}
+	response, err := process.call(ctx, request, timeout)
+	if err != nil {
+		process.cancel() // Kill it now, rather than wait for it to exit
+		process.close()
+		delete(r.processes, command)
+		return nil, err
+	}
+	return response, nil
+}
+
+// pluginProcess is the running process of a plugin, which handles one
+// request at a time.
+type pluginProcess struct {
+	cmd    *exec.Cmd
+	cancel context.CancelFunc // Kills the process, and any children it started
+	stdin  io.WriteCloser
+	lines  chan []byte   // Lines read from stdout, closed when stdout is
+	done   chan struct{} // Closed when the process is being stopped
+}
+
+// startPlugin starts the process for the plugin command, with the shell in
+// the source dir. Its stderr is logged as warnings.
+func (wiki Wiki) startPlugin(command string) (*pluginProcess, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	setProcessGroup(cmd)
+	cmd.Dir = wiki.SourceDir
+	cmd.Env = append(os.Environ(),
+		"GOMARKWIKI_SOURCE_DIR="+wiki.SourceDir,
+		"GOMARKWIKI_CONTENT_DIR="+wiki.ContentDir,
+		"GOMARKWIKI_DEST_DIR="+wiki.DestDir,
+	)
+	cmd.Stderr = &pluginLogWriter{log: wiki.log, command: command}
+	cmd.WaitDelay = time.Second // Don't wait long for output from any children left running
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start: %v", err)
+	}
+	wiki.log.Verbose("Starting plugin '%s'", command)
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start: %v", err)
+	}
+
+	p := &pluginProcess{cmd: cmd, cancel: cancel, stdin: stdin, lines: make(chan []byte), done: make(chan struct{})}
+	go func() {
+		defer close(p.lines)
+		reader := bufio.NewReader(stdout)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				select {
+				case p.lines <- line:
+				case <-p.done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return p, nil
+}
+
+// call writes request to the process and reads its response, waiting at
+// most timeout.
+func (p *pluginProcess) call(ctx context.Context, request pluginRequest, timeout time.Duration) (*pluginResponse, error) {
+	if request.FrontMatter == nil {
+		request.FrontMatter = frontMatter{}
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to write request: %v", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case line, ok := <-p.lines:
+		if !ok {
+			return nil, fmt.Errorf("plugin exited without responding")
+		}
+		var response pluginResponse
+		if err := json.Unmarshal(line, &response); err != nil {
+			return nil, fmt.Errorf("invalid response: %v", err)
+		}
+		return &response, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("timed out after %s", timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// close stops the process. Its stdin is closed, which tells it to exit, and
+// it's killed if it hasn't exited after pluginCloseWait.
+func (p *pluginProcess) close() {
+	close(p.done)
+	p.stdin.Close()
+	timer := time.AfterFunc(pluginCloseWait, p.cancel)
+	p.cmd.Wait()
+	timer.Stop()
+	p.cancel()
+}
+
+// pluginLogWriter logs what a plugin writes to stderr, a line at a time.
+type pluginLogWriter struct {
+	log     util.Logger
+	command string
+	partial []byte // Start of a line that hasn't been ended yet
+}
+
+func (w *pluginLogWriter) Write(data []byte) (int, error) {
+	w.partial = append(w.partial, data...)
+	for {
+		line, rest, found := bytes.Cut(w.partial, []byte("\n"))
+		if !found {
+			break
+		}
+		if text := strings.TrimSpace(string(line)); text != "" {
+			w.log.Warning("plugin '%s': %s", w.command, text)
+		}
+		w.partial = rest
+	}
+	return len(data), nil
+}
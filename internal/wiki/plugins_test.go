@@ -0,0 +1,117 @@
+package wiki
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readDest returns the contents of the generated file relPath.
+func readDest(t *testing.T, theWiki *Wiki, relPath string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(theWiki.DestDir, relPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Plugins that read a request a line at a time. The markdown is the last
+// field of each request, so sed can edit it in place.
+const (
+	appendPlugin  = `while read -r line; do echo "$line" | sed 's/.*"markdown":"\(.*\)"}$/{"markdown":"\1 plugged"}/'; done`
+	counterPlugin = `n=0; while read -r line; do n=$((n+1)); echo "{\"markdown\":\"Call $n\"}"; done`
+	htmlPlugin    = `while read -r line; do echo '{"html":"<p class=\"raw\">raw</p>"}'; done`
+	titlePlugin   = `while read -r line; do echo "$line" | sed 's/.*"front_matter":{"title":"\([^"]*\)"}.*/{"markdown":"Title is \1"}/'; done`
+)
+
+func TestPlugins(t *testing.T) {
+	theWiki, errOut := commandTestWiki(t, map[string]string{
+		"index.md":       "Index",
+		"count/a.md":     "A",
+		"count/b.md":     "B",
+		"raw/page.md":    "Page",
+		"meta/titled.md": "---\ntitle: Front\n---\nBody",
+	}, "plugins.csv", strings.Join([]string{
+		`*.md,"` + strings.ReplaceAll(appendPlugin, `"`, `""`) + `"`,
+		`count/,"` + strings.ReplaceAll(counterPlugin, `"`, `""`) + `"`,
+		`raw/,"` + strings.ReplaceAll(htmlPlugin, `"`, `""`) + `"`,
+		`meta/,"` + strings.ReplaceAll(titlePlugin, `"`, `""`) + `"`,
+	}, "\n")+"\n")
+
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("unexpected errors: %s", errOut)
+	}
+
+	tests := []struct {
+		relPath string
+		want    string
+	}{
+		{"index.html", "<p>Index plugged</p>"},
+		{"count/a.html", "<p>Call 1</p>"}, // The process is reused between pages
+		{"count/b.html", "<p>Call 2</p>"},
+		{"raw/page.html", `<p class="raw">raw</p>`},
+		{"meta/titled.html", "<p>Title is Front</p>"},
+	}
+	for _, tt := range tests {
+		if got := readDest(t, theWiki, tt.relPath); !strings.Contains(got, tt.want) {
+			t.Errorf("%s doesn't contain %q:\n%s", tt.relPath, tt.want, got)
+		}
+	}
+	if got := readDest(t, theWiki, "meta/titled.html"); strings.Contains(got, "---") {
+		t.Errorf("front matter was rendered:\n%s", got)
+	}
+
+	if len(theWiki.plugins.processes) != 0 {
+		t.Errorf("%d plugin processes still running after Generate", len(theWiki.plugins.processes))
+	}
+}
+
+func TestPluginFailure(t *testing.T) {
+	theWiki, errOut := commandTestWiki(t, map[string]string{
+		"index.md":     "Index",
+		"slow/page.md": "Slow",
+		"bad/page.md":  "Bad",
+	}, "plugins.csv", `slow/,sleep 5
+bad/,"while read -r line; do echo '{""error"":""no good""}'; echo oops >&2; done"
+`)
+	theWiki.PluginTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Generate took %s, want the slow plugin killed after its timeout", elapsed)
+	}
+	if got := readDest(t, theWiki, "index.html"); !strings.Contains(got, "<p>Index</p>") {
+		t.Errorf("page without plugins not generated:\n%s", got)
+	}
+
+	log := errOut.String()
+	if !strings.Contains(log, "plugin 'sleep 5' failed for '"+filepath.Join(theWiki.ContentDir, "slow", "page.md")+"': timed out after 100ms") {
+		t.Errorf("plugin timeout not logged:\n%s", log)
+	}
+	if !strings.Contains(log, "failed for '"+filepath.Join(theWiki.ContentDir, "bad", "page.md")+"': no good") {
+		t.Errorf("plugin error not logged:\n%s", log)
+	}
+	if !strings.Contains(log, ": oops") {
+		t.Errorf("plugin stderr not logged:\n%s", log)
+	}
+}
+
+func TestInvalidPlugins(t *testing.T) {
+	theWiki, errOut := commandTestWiki(t, map[string]string{"index.md": "Index"}, "plugins.csv", "!*.md,cat\n")
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(errOut.String(), `invalid pattern "!*.md"`) {
+		t.Errorf("invalid plugin not reported:\n%s", errOut)
+	}
+}
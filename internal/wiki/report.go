@@ -0,0 +1,217 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+// Report is the machine-readable summary of a run, written by -report as
+// JSON. It has the report of the latest generation of each wiki.
+type Report struct {
+	Version string        `json:"version"`
+	Wikis   []*WikiReport `json:"wikis"`
+}
+
+// WikiReport summarizes one generation of a wiki. Dest paths are
+// slash-separated and relative to the dest dir, and source paths are
+// slash-separated and relative to the content dir, except for those of
+// errors, which are full paths.
+type WikiReport struct {
+	SourceDir  string            `json:"source_dir"`
+	DestDir    string            `json:"dest_dir"`
+	Cycle      int               `json:"cycle"` // 1 for the first generation, counting up with each regeneration in watch mode
+	Start      time.Time         `json:"start"`
+	DurationMs float64           `json:"duration_ms"`
+	Error      string            `json:"error,omitempty"` // Why the generation failed, if it did
+	DryRun     bool              `json:"dry_run,omitempty"`
+	Created    []string          `json:"created,omitempty"` // In a dry run, the files that would be created
+	Generated  []string          `json:"generated"`
+	Copied     []string          `json:"copied"`
+	Skipped    []string          `json:"skipped"`
+	Deleted    []string          `json:"deleted"`
+	Collisions []ReportCollision `json:"collisions"`
+	Errors     []ReportError     `json:"errors"`
+	Phases     []ReportTiming    `json:"phases"`
+	Files      []ReportTiming    `json:"files"`
+}
+
+// ReportCollision is a source file that was skipped because another source
+// file claimed its dest path.
+type ReportCollision struct {
+	Path      string `json:"path"`
+	Source    string `json:"source"`
+	ClaimedBy string `json:"claimed_by"`
+}
+
+// ReportError is an error processing a source file.
+type ReportError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ReportTiming is how long a phase or file took. Phases have a name and
+// files a path.
+type ReportTiming struct {
+	Name       string  `json:"name,omitempty"`
+	Path       string  `json:"path,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// durationMs returns d in milliseconds.
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// nonNil returns list, or an empty list if list is nil, so that it's written
+// to JSON as [] rather than null.
+func nonNil[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
+
+// newWikiReport returns the report for the generation of wiki that produced
+// result and err.
+func newWikiReport(wiki *Wiki, cycle int, result *BuildResult, err error) *WikiReport {
+	report := &WikiReport{
+		SourceDir:  wiki.SourceDir,
+		DestDir:    wiki.DestDir,
+		Cycle:      cycle,
+		Start:      result.Start,
+		DurationMs: durationMs(result.Duration),
+		Generated:  nonNil(result.Generated),
+		Copied:     nonNil(result.Copied),
+		Skipped:    nonNil(result.Skipped),
+		Deleted:    nonNil(result.Deleted),
+		DryRun:     result.DryRun,
+		Created:    result.Created,
+		Collisions: []ReportCollision{},
+		Errors:     []ReportError{},
+		Phases:     []ReportTiming{},
+		Files:      []ReportTiming{},
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	for _, collision := range result.Collisions {
+		report.Collisions = append(report.Collisions, ReportCollision(collision))
+	}
+	for _, fileErr := range result.Errors {
+		report.Errors = append(report.Errors, ReportError{Path: fileErr.Path, Error: fileErr.Err.Error()})
+	}
+	for _, phase := range result.Phases {
+		report.Phases = append(report.Phases, ReportTiming{Name: phase.Name, DurationMs: durationMs(phase.Duration)})
+	}
+	for _, file := range result.Files {
+		report.Files = append(report.Files, ReportTiming{Path: file.Name, DurationMs: durationMs(file.Duration)})
+	}
+	return report
+}
+
+// Reporter writes the report for a set of wikis to a file, rewriting it
+// after each generation of any of them.
+type Reporter struct {
+	mu      sync.Mutex
+	path    string
+	log     util.Logger
+	report  Report
+	wikis   []*Wiki               // Wikis reported on, in the order they're written
+	reports map[*Wiki]*WikiReport // Latest report for each wiki, missing before its first generation
+}
+
+// NewReporter returns a Reporter that writes the report for wikis to the file
+// at path, adding each with Add.
+func NewReporter(path string, wikis []*Wiki, version string, log util.Logger) *Reporter {
+	r := &Reporter{
+		path:    path,
+		log:     log,
+		report:  Report{Version: version},
+		reports: make(map[*Wiki]*WikiReport),
+	}
+	for _, wiki := range wikis {
+		r.Add(wiki)
+	}
+	return r
+}
+
+// Add adds wiki to the report. It sets the OnBuild of wiki, calling any
+// OnBuild that was already set.
+func (r *Reporter) Add(wiki *Wiki) {
+	r.mu.Lock()
+	r.wikis = append(r.wikis, wiki)
+	r.mu.Unlock()
+
+	onBuild := wiki.OnBuild
+	wiki.OnBuild = func(result *BuildResult, err error) {
+		r.record(wiki, result, err)
+		if onBuild != nil {
+			onBuild(result, err)
+		}
+	}
+}
+
+// Remove removes wiki from the report, and rewrites it. Generations of wiki
+// after that aren't reported.
+func (r *Reporter) Remove(wiki *Wiki) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.wikis = slices.DeleteFunc(r.wikis, func(w *Wiki) bool { return w == wiki })
+	if _, ok := r.reports[wiki]; ok {
+		delete(r.reports, wiki)
+		if err := r.write(); err != nil {
+			r.log.Error(err, "failed to write report")
+		}
+	}
+}
+
+// record records the result of a generation of wiki, and rewrites the
+// report.
+func (r *Reporter) record(wiki *Wiki, result *BuildResult, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !slices.Contains(r.wikis, wiki) {
+		return
+	}
+	cycle := 1
+	if report := r.reports[wiki]; report != nil {
+		cycle = report.Cycle + 1
+	}
+	r.reports[wiki] = newWikiReport(wiki, cycle, result, err)
+
+	if err := r.write(); err != nil {
+		r.log.Error(err, "failed to write report")
+	}
+}
+
+// write writes the report, with the wikis generated so far.
+func (r *Reporter) write() error {
+	r.report.Wikis = r.report.Wikis[:0]
+	for _, wiki := range r.wikis {
+		if report := r.reports[wiki]; report != nil {
+			r.report.Wikis = append(r.report.Wikis, report)
+		}
+	}
+
+	data, err := json.MarshalIndent(r.report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %v", err)
+	}
+	data = append(data, '\n')
+
+	// Write the report atomically, so that readers never see it partly written.
+	if err := copyToFile(context.Background(), r.log, r.path, bytes.NewReader(data), 0644); err != nil {
+		return fmt.Errorf("failed to write report '%s': %v", r.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package wiki
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// readReport reads the report written to path.
+func readReport(t *testing.T, path string) Report {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("failed to parse report: %v\n%s", err, data)
+	}
+	return report
+}
+
+func TestReport(t *testing.T) {
+	theWiki, destDir := includeTestWiki(t, map[string]string{
+		"index.md":        "# Index",
+		"Page.md":         "# Page",
+		"Page.markdown":   "# Page again",
+		"image.png":       "png",
+		"Broken.md":       "{{include: Missing.md}}",
+		"Sub/Nested.mdwn": "# Nested",
+	})
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(destDir, "stale.html"), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	NewReporter(reportPath, []*Wiki{theWiki}, "test", theWiki.log)
+
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	report := readReport(t, reportPath)
+	if report.Version != "test" || len(report.Wikis) != 1 {
+		t.Fatalf("report = %+v, want version test and one wiki", report)
+	}
+	wikiReport := report.Wikis[0]
+	if wikiReport.Cycle != 1 || wikiReport.SourceDir != theWiki.SourceDir || wikiReport.DestDir != destDir {
+		t.Errorf("cycle, source_dir, dest_dir = %d, %q, %q", wikiReport.Cycle, wikiReport.SourceDir, wikiReport.DestDir)
+	}
+	slices.Sort(wikiReport.Generated)
+	if want := []string{"Page.html", "Sub/Nested.html", "index.html"}; !slices.Equal(wikiReport.Generated, want) {
+		t.Errorf("generated = %v, want %v", wikiReport.Generated, want)
+	}
+	if !slices.Equal(wikiReport.Copied, []string{"image.png"}) {
+		t.Errorf("copied = %v, want [image.png]", wikiReport.Copied)
+	}
+	want := ReportCollision{Path: "Page.html", Source: "Page.md", ClaimedBy: "Page.markdown"}
+	if len(wikiReport.Collisions) != 1 || wikiReport.Collisions[0] != want {
+		t.Errorf("collisions = %+v, want [%+v]", wikiReport.Collisions, want)
+	}
+	if len(wikiReport.Errors) != 1 || wikiReport.Errors[0].Path != filepath.Join(theWiki.ContentDir, "Broken.md") {
+		t.Errorf("errors = %+v, want one for Broken.md", wikiReport.Errors)
+	}
+	// Clean is skipped when there are errors.
+	if len(wikiReport.Deleted) != 0 {
+		t.Errorf("deleted = %v, want none", wikiReport.Deleted)
+	}
+	var phases []string
+	for _, phase := range wikiReport.Phases {
+		phases = append(phases, phase.Name)
+	}
+	if !slices.Equal(phases, []string{"content", "css"}) {
+		t.Errorf("phases = %v, want [content css]", phases)
+	}
+	if len(wikiReport.Files) != 6 {
+		t.Errorf("files = %+v, want timings for 6 files", wikiReport.Files)
+	}
+
+	// Each regeneration rewrites the report, with the next cycle.
+	if err := os.Remove(filepath.Join(theWiki.ContentDir, "Broken.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := theWiki.Generate(context.Background(), false, true, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	wikiReport = readReport(t, reportPath).Wikis[0]
+	if wikiReport.Cycle != 2 {
+		t.Errorf("cycle = %d, want 2", wikiReport.Cycle)
+	}
+	if len(wikiReport.Generated) != 0 || len(wikiReport.Skipped) != 4 {
+		t.Errorf("generated = %v and skipped = %v, want all 4 skipped", wikiReport.Generated, wikiReport.Skipped)
+	}
+	if !slices.Equal(wikiReport.Deleted, []string{"stale.html"}) {
+		t.Errorf("deleted = %v, want [stale.html]", wikiReport.Deleted)
+	}
+	if wikiReport.Errors == nil || wikiReport.Start.After(time.Now()) {
+		t.Errorf("errors = %v, start = %v", wikiReport.Errors, wikiReport.Start)
+	}
+}
+
+func TestReporterRemove(t *testing.T) {
+	wiki1, _ := includeTestWiki(t, map[string]string{"index.md": "# One"})
+	wiki2, _ := includeTestWiki(t, map[string]string{"index.md": "# Two"})
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	r := NewReporter(reportPath, []*Wiki{wiki1}, "test", wiki1.log)
+	r.Add(wiki2)
+
+	for _, theWiki := range []*Wiki{wiki1, wiki2} {
+		if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+	}
+	if report := readReport(t, reportPath); len(report.Wikis) != 2 {
+		t.Fatalf("report has %d wikis, want 2", len(report.Wikis))
+	}
+
+	// A removed wiki is dropped from the report, and isn't added back by
+	// later generations.
+	r.Remove(wiki1)
+	if err := wiki1.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	report := readReport(t, reportPath)
+	if len(report.Wikis) != 1 || report.Wikis[0].SourceDir != wiki2.SourceDir {
+		t.Errorf("report wikis = %+v, want just %s", report.Wikis, wiki2.SourceDir)
+	}
+}
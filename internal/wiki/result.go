@@ -0,0 +1,141 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// BuildResult records what one generation of a wiki did. Paths are
+// slash-separated and relative to the dest dir.
+type BuildResult struct {
+	Generated []string    // Pages generated from markdown
+	Copied    []string    // Static files copied from the content dir
+	Skipped   []string    // Pages and static files left alone because they were up to date
+	Deleted   []string    // Files deleted from the dest dir by -clean
+	Errors    []FileError // Errors processing individual source files
+
+	// DryRun is whether nothing was written or deleted, and Generated,
+	// Copied and Deleted list what would have been. Created lists the files
+	// that would have been written that don't exist in the dest dir yet,
+	// including CSS files.
+	DryRun  bool
+	Created []string
+
+	Collisions []Collision // Source files skipped because another claimed their dest path
+
+	Start    time.Time     // When the generation started
+	Duration time.Duration // How long the generation took
+	Phases   []Timing      // How long each phase of the generation took
+	Files    []Timing      // How long each source file took, by path relative to the content dir
+}
+
+// Collision records a source file that was skipped because it would have
+// generated the same dest file as another. Paths are slash-separated.
+type Collision struct {
+	Path      string // Dest path, relative to the dest dir
+	Source    string // Source file skipped, relative to the content dir
+	ClaimedBy string // Source file that generated Path, relative to the content dir
+}
+
+// Timing records how long something named Name took.
+type Timing struct {
+	Name     string
+	Duration time.Duration
+}
+
+// FileError is an error that occurred while processing a single file.
+type FileError struct {
+	Path string // Full path of the file
+	Err  error
+}
+
+// Error returns the error message, including the path.
+func (e FileError) Error() string {
+	return fmt.Sprintf("'%s': %v", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e FileError) Unwrap() error {
+	return e.Err
+}
+
+// The methods below record what happened to a file. They do nothing when
+// called on a nil BuildResult, so that code that generates files can record
+// results unconditionally.
+
+func (r *BuildResult) addGenerated(relDestPath string) {
+	if r != nil {
+		r.Generated = append(r.Generated, filepath.ToSlash(relDestPath))
+	}
+}
+
+func (r *BuildResult) addCopied(relDestPath string) {
+	if r != nil {
+		r.Copied = append(r.Copied, filepath.ToSlash(relDestPath))
+	}
+}
+
+func (r *BuildResult) addCreated(relDestPath string) {
+	if r != nil {
+		r.Created = append(r.Created, filepath.ToSlash(relDestPath))
+	}
+}
+
+func (r *BuildResult) addSkipped(relDestPath string) {
+	if r != nil {
+		r.Skipped = append(r.Skipped, filepath.ToSlash(relDestPath))
+	}
+}
+
+func (r *BuildResult) addDeleted(relDestPath string) {
+	if r != nil {
+		r.Deleted = append(r.Deleted, filepath.ToSlash(relDestPath))
+	}
+}
+
+func (r *BuildResult) addError(path string, err error) {
+	if r != nil {
+		r.Errors = append(r.Errors, FileError{Path: path, Err: err})
+	}
+}
+
+func (r *BuildResult) addCollision(relDestPath, relSourcePath, claimedBy string) {
+	if r != nil {
+		r.Collisions = append(r.Collisions, Collision{
+			Path:      filepath.ToSlash(relDestPath),
+			Source:    filepath.ToSlash(relSourcePath),
+			ClaimedBy: filepath.ToSlash(claimedBy),
+		})
+	}
+}
+
+// HasChanges returns whether any files were, or in a dry run would have
+// been, written or deleted. CSS files, which are written each generation,
+// only count when they're created.
+func (r *BuildResult) HasChanges() bool {
+	return len(r.Generated) > 0 || len(r.Copied) > 0 || len(r.Deleted) > 0 || len(r.Created) > 0
+}
+
+// timePhase starts timing the phase name, and returns a function to call
+// when it ends.
+func (r *BuildResult) timePhase(name string) func() {
+	start := time.Now()
+	return func() {
+		if r != nil {
+			r.Phases = append(r.Phases, Timing{Name: name, Duration: time.Since(start)})
+		}
+	}
+}
+
+// timeFile starts timing the source file relSourcePath, and returns a function
+// to call when it's done.
+func (r *BuildResult) timeFile(relSourcePath string) func() {
+	start := time.Now()
+	return func() {
+		if r != nil {
+			r.Files = append(r.Files, Timing{Name: filepath.ToSlash(relSourcePath), Duration: time.Since(start)})
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// The wiki's source files are read through wiki.source, an fs.FS rooted at
+// the source dir, so that a wiki can be generated from a zip archive, an
+// embed.FS, or anything else that implements fs.FS. Elsewhere files are
+// identified by their full paths, formed by joining the source dir with the
+// file's name in wiki.source, so that messages and dependency tracking work
+// the same whatever the source. The routines here convert between the two.
+
+// sourceName returns the name in wiki.source of the file at path, which must
+// be within the source dir.
+func (wiki Wiki) sourceName(path string) (string, error) {
+	relPath, err := filepath.Rel(wiki.SourceDir, path)
+	if err != nil {
+		return "", &fs.PathError{Op: "open", Path: path, Err: err}
+	}
+	name := filepath.ToSlash(relPath)
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "open", Path: path, Err: fs.ErrInvalid}
+	}
+	return name, nil
+}
+
+// sourcePath returns the full path of the file called name in wiki.source.
+func (wiki Wiki) sourcePath(name string) string {
+	return filepath.Join(wiki.SourceDir, filepath.FromSlash(name))
+}
+
+// sourceError replaces the name in err, if it's an *fs.PathError, with path,
+// so that errors from wiki.source show the full path.
+func sourceError(err error, path string) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) && pathErr.Path != path {
+		return &fs.PathError{Op: pathErr.Op, Path: path, Err: pathErr.Err}
+	}
+	return err
+}
+
+// statSource returns info on the source file at path, following symlinks.
+func (wiki Wiki) statSource(path string) (fs.FileInfo, error) {
+	name, err := wiki.sourceName(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(wiki.source, name)
+	return info, sourceError(err, path)
+}
+
+// lstatSource returns info on the source file at path, without following
+// symlinks if wiki.source supports them.
+func (wiki Wiki) lstatSource(path string) (fs.FileInfo, error) {
+	name, err := wiki.sourceName(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Lstat(wiki.source, name)
+	return info, sourceError(err, path)
+}
+
+// openSource opens the source file at path.
+func (wiki Wiki) openSource(path string) (fs.File, error) {
+	name, err := wiki.sourceName(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := wiki.source.Open(name)
+	return file, sourceError(err, path)
+}
+
+// readSource reads the source file at path.
+func (wiki Wiki) readSource(path string) ([]byte, error) {
+	name, err := wiki.sourceName(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fs.ReadFile(wiki.source, name)
+	return data, sourceError(err, path)
+}
+
+// walkSource walks the source files in the directory at root, calling fn for
+// each with its full path, in lexical order. See fs.WalkDir.
+func (wiki Wiki) walkSource(root string, fn fs.WalkDirFunc) error {
+	rootName, err := wiki.sourceName(root)
+	if err != nil {
+		return err
+	}
+	return fs.WalkDir(wiki.source, rootName, func(name string, entry fs.DirEntry, err error) error {
+		path := wiki.sourcePath(name)
+		return fn(path, entry, sourceError(err, path))
+	})
+}
+
+// sourceDepth returns the depth of the source file at path below root.
+func sourceDepth(root, path string) int {
+	relPath, err := filepath.Rel(root, path)
+	if err != nil || relPath == "." {
+		return 0
+	}
+	return strings.Count(relPath, string(filepath.Separator)) + 1
+}
+
+// checkSourceDir returns an error if dir isn't a directory in wiki.source.
+func (wiki Wiki) checkSourceDir(dir string) error {
+	info, err := wiki.statSource(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("directory '%s' not found", dir)
+	} else if err != nil {
+		return fmt.Errorf("failed to stat '%s': %v", dir, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", dir)
+	}
+	return nil
+}
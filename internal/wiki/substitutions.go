@@ -0,0 +1,275 @@
+// Package wiki generates HTML from markdown for a given wiki.
+package wiki
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Built-in variables, available on every page without being defined in
+// substitution-strings.csv. A placeholder defined in substitution-strings.csv
+// with the same name takes precedence.
+const (
+	builtinPageTitle         = "PAGE_TITLE"         // Page title, the markdown file name without extension
+	builtinPagePath          = "PAGE_PATH"          // Path of the markdown file relative to the content dir
+	builtinBuildDate         = "BUILD_DATE"         // Date the wiki was generated
+	builtinLastModified      = "LAST_MODIFIED"      // Date the markdown file was last modified
+	builtinGomarkwikiVersion = "GOMARKWIKI_VERSION" // Version of gomarkwiki
+)
+
+// envPrefix starts placeholders such as {{env:HOME}} that expand to the value
+// of an environment variable.
+const envPrefix = "env:"
+
+// dateFormat is the format used for dates in built-in variables.
+const dateFormat = "2006-01-02"
+
+// placeholderUseRegexp matches a placeholder, such as {{SITE}} or
+// {{env:HOME}}, and substitutionTokenRegexp matches one at the start of the text.
+var (
+	placeholderUseRegexp    = regexp.MustCompile(`\{\{((?:env:)?[\p{L}\p{N}_-]+)\}\}`)
+	substitutionTokenRegexp = regexp.MustCompile(`^` + placeholderUseRegexp.String())
+)
+
+// pageContext holds the values of the built-in variables for a page.
+type pageContext struct {
+	title     string    // Value of PAGE_TITLE
+	relPath   string    // Value of PAGE_PATH
+	modTime   time.Time // Value of LAST_MODIFIED
+	buildTime time.Time // Value of BUILD_DATE
+	version   string    // Value of GOMARKWIKI_VERSION
+}
+
+// buildDate returns the time of BUILD_DATE for a build that started at
+// start. When SOURCE_DATE_EPOCH is set, or the wiki is generated to an
+// archive, it's the modification time that archives give their files, so
+// that the same source makes the same pages.
+func (wiki Wiki) buildDate(start time.Time) time.Time {
+	if modTime, ok := sourceDateEpoch(); ok {
+		return modTime
+	}
+	if _, ok := wiki.out.(*ArchiveOutput); ok {
+		return archiveModTime()
+	}
+	return start
+}
+
+// lookupPlaceholder returns the value of the placeholder name for page.
+// Returns false if the placeholder isn't defined.
+func (wiki Wiki) lookupPlaceholder(name string, page *pageContext) (string, bool) {
+	// Placeholders from the per-directory substitution files that apply to
+	// the page, deepest first, and then from substitution-strings.csv.
+	relPath := ""
+	if page != nil {
+		relPath = page.relPath
+	}
+	for _, subStrings := range wiki.scopedSubStrings(relPath) {
+		if value, ok := subStrings[name]; ok {
+			return value, true
+		}
+	}
+
+	// Environment variables, if allowed.
+	if envName, ok := strings.CutPrefix(name, envPrefix); ok {
+		if !slices.Contains(wiki.EnvAllowlist, envName) {
+			return "", false
+		}
+		return os.LookupEnv(envName)
+	}
+
+	// Built-in variables.
+	if page == nil {
+		return "", false
+	}
+	switch name {
+	case builtinPageTitle:
+		return page.title, true
+	case builtinPagePath:
+		return page.relPath, true
+	case builtinBuildDate:
+		return page.buildTime.Format(dateFormat), true
+	case builtinLastModified:
+		return page.modTime.Format(dateFormat), true
+	case builtinGomarkwikiVersion:
+		return page.version, true
+	}
+	return "", false
+}
+
+// makeSubstitutions replaces the placeholders in data with their values, and
+// returns the result along with the names of any placeholders that aren't
+// defined. Built-in variables are available when page is not nil.
+//
+// A placeholder preceded by a backslash, as in \{{SITE}}, is written out as
+// the literal {{SITE}}. Placeholders in fenced code blocks and inline code
+// are left as is, unless wiki.SubstituteInCode is set.
+func (wiki Wiki) makeSubstitutions(data []byte, page *pageContext) ([]byte, map[string]bool) {
+	undefined := make(map[string]bool)
+
+	// Quick check so that pages without placeholders are returned unchanged.
+	if !bytes.Contains(data, []byte("{{")) {
+		return data, undefined
+	}
+
+	var result bytes.Buffer
+	result.Grow(len(data))
+	forEachLineOutsideCode(data, func(line []byte, inCode bool) {
+		switch {
+		case wiki.SubstituteInCode:
+			wiki.substituteText(&result, line, page, undefined)
+		case inCode:
+			result.Write(line)
+		default:
+			forEachInlineCodeSpan(line, func(text []byte, isCode bool) {
+				if isCode {
+					result.Write(text)
+				} else {
+					wiki.substituteText(&result, text, page, undefined)
+				}
+			})
+		}
+	})
+
+	return result.Bytes(), undefined
+}
+
+// substituteText writes text to result with placeholders replaced by their
+// values. The names of placeholders that aren't defined are added to undefined.
+func (wiki Wiki) substituteText(result *bytes.Buffer, text []byte, page *pageContext, undefined map[string]bool) {
+	for len(text) > 0 {
+		idx := bytes.Index(text, []byte("{{"))
+		if idx < 0 {
+			result.Write(text)
+			return
+		}
+
+		// Escaped placeholder: drop the backslash and write the placeholder as is.
+		if idx > 0 && text[idx-1] == '\\' {
+			if match := substitutionTokenRegexp.Find(text[idx:]); match != nil {
+				result.Write(text[:idx-1])
+				result.Write(match)
+				text = text[idx+len(match):]
+				continue
+			}
+		}
+
+		result.Write(text[:idx])
+		text = text[idx:]
+		match := substitutionTokenRegexp.FindSubmatch(text)
+		if match == nil {
+			// Not a placeholder.
+			result.WriteString("{{")
+			text = text[2:]
+			continue
+		}
+
+		name := string(match[1])
+		if value, ok := wiki.lookupPlaceholder(name, page); ok {
+			result.WriteString(value)
+		} else {
+			result.Write(match[0])
+			undefined[name] = true
+		}
+		text = text[len(match[0]):]
+	}
+}
+
+// forEachInlineCodeSpan splits line into inline code spans and the text
+// between them, and calls fn for each piece in order.
+func forEachInlineCodeSpan(line []byte, fn func(text []byte, isCode bool)) {
+	for len(line) > 0 {
+		// Find the next backtick string.
+		start := bytes.IndexByte(line, '`')
+		if start < 0 {
+			fn(line, false)
+			return
+		}
+		n := 0
+		for start+n < len(line) && line[start+n] == '`' {
+			n++
+		}
+
+		// Find a closing backtick string of the same length.
+		end := -1
+		for i := start + n; i < len(line); {
+			if line[i] != '`' {
+				i++
+				continue
+			}
+			m := 0
+			for i+m < len(line) && line[i+m] == '`' {
+				m++
+			}
+			if m == n {
+				end = i + m
+				break
+			}
+			i += m
+		}
+		if end < 0 {
+			// No closing backticks, so these are literal backticks.
+			fn(line[:start+n], false)
+			line = line[start+n:]
+			continue
+		}
+
+		if start > 0 {
+			fn(line[:start], false)
+		}
+		fn(line[start:end], true)
+		line = line[end:]
+	}
+}
+
+// warnUndefinedPlaceholders prints a warning with the file and line for each
+// use of the placeholders named in undefined, in the files at paths.
+func (wiki Wiki) warnUndefinedPlaceholders(undefined map[string]bool, paths []string) {
+	if len(undefined) == 0 {
+		return
+	}
+
+	found := make(map[string]bool)
+	for _, path := range paths {
+		file, err := wiki.openSource(path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, MaxMarkdownFileSize)
+		for lineNum := 1; scanner.Scan(); lineNum++ {
+			for _, match := range placeholderUseRegexp.FindAllSubmatch(scanner.Bytes(), -1) {
+				if name := string(match[1]); undefined[name] {
+					found[name] = true
+					wiki.log.Warning("%s in '%s' at line %d", wiki.undefinedPlaceholderMessage(name), path, lineNum)
+				}
+			}
+		}
+		file.Close()
+	}
+
+	// Report any that couldn't be located in the files, such as placeholders
+	// split across lines.
+	for name := range undefined {
+		if !found[name] {
+			wiki.log.Warning("%s in '%s'", wiki.undefinedPlaceholderMessage(name), paths[0])
+		}
+	}
+}
+
+// undefinedPlaceholderMessage returns a description of why the placeholder
+// name could not be substituted.
+func (wiki Wiki) undefinedPlaceholderMessage(name string) string {
+	if envName, ok := strings.CutPrefix(name, envPrefix); ok {
+		if !slices.Contains(wiki.EnvAllowlist, envName) {
+			return fmt.Sprintf("Environment variable placeholder {{%s}} is not allowed (see -allow-env)", name)
+		}
+		return fmt.Sprintf("Environment variable placeholder {{%s}} is not set", name)
+	}
+	return fmt.Sprintf("Undefined placeholder {{%s}}", name)
+}
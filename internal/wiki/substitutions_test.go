@@ -0,0 +1,112 @@
+package wiki
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
+)
+
+func TestMakeSubstitutionsBuiltins(t *testing.T) {
+	t.Setenv("GOMARKWIKI_TEST_ALLOWED", "allowed")
+	t.Setenv("GOMARKWIKI_TEST_SECRET", "secret")
+
+	wiki := Wiki{
+		subStrings: map[string]string{
+			"SITE":       "example.com",
+			"PAGE_TITLE": "Overridden",
+		},
+		EnvAllowlist: []string{"GOMARKWIKI_TEST_ALLOWED", "GOMARKWIKI_TEST_UNSET"},
+	}
+	page := &pageContext{
+		title:     "Bar",
+		relPath:   "Foo/Bar.md",
+		modTime:   time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		buildTime: time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC),
+		version:   "1.2.3",
+	}
+
+	tests := []struct {
+		name          string
+		input         string
+		want          string
+		wantUndefined []string
+	}{
+		{"built-ins", "{{PAGE_PATH}} {{LAST_MODIFIED}} {{BUILD_DATE}} {{GOMARKWIKI_VERSION}}", "Foo/Bar.md 2024-03-01 2025-06-02 1.2.3", nil},
+		{"csv overrides built-in", "{{PAGE_TITLE}}", "Overridden", nil},
+		{"allowed env var", "{{env:GOMARKWIKI_TEST_ALLOWED}}", "allowed", nil},
+		{"env var not in allowlist", "{{env:GOMARKWIKI_TEST_SECRET}}", "{{env:GOMARKWIKI_TEST_SECRET}}", []string{"env:GOMARKWIKI_TEST_SECRET"}},
+		{"unset env var", "{{env:GOMARKWIKI_TEST_UNSET}}", "{{env:GOMARKWIKI_TEST_UNSET}}", []string{"env:GOMARKWIKI_TEST_UNSET"}},
+		{"escaped", `\{{SITE}} {{SITE}}`, "{{SITE}} example.com", nil},
+		{"undefined", "{{NOPE}} {{SITE}}", "{{NOPE}} example.com", []string{"NOPE"}},
+		{"not a placeholder", "{{ spaced }} {{SITE", "{{ spaced }} {{SITE", nil},
+		{"inline code", "`{{SITE}}` {{SITE}} ``a ` {{SITE}}``", "`{{SITE}}` example.com ``a ` {{SITE}}``", nil},
+		{"unmatched backtick", "` {{SITE}}", "` example.com", nil},
+		{"fenced code", "{{SITE}}\n```\n{{SITE}}\n```\n", "example.com\n```\n{{SITE}}\n```\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, undefined := wiki.makeSubstitutions([]byte(tt.input), page)
+			if string(got) != tt.want {
+				t.Errorf("makeSubstitutions() = %q, want %q", got, tt.want)
+			}
+			var gotUndefined []string
+			for name := range undefined {
+				gotUndefined = append(gotUndefined, name)
+			}
+			if !reflect.DeepEqual(gotUndefined, tt.wantUndefined) {
+				t.Errorf("makeSubstitutions() undefined = %v, want %v", gotUndefined, tt.wantUndefined)
+			}
+		})
+	}
+}
+
+func TestMakeSubstitutionsInCode(t *testing.T) {
+	wiki := Wiki{
+		subStrings:       map[string]string{"SITE": "example.com"},
+		SubstituteInCode: true,
+	}
+	got, _ := wiki.makeSubstitutions([]byte("`{{SITE}}`\n```\n{{SITE}}\n```\n"), nil)
+	if want := "`example.com`\n```\nexample.com\n```\n"; string(got) != want {
+		t.Errorf("makeSubstitutions() = %q, want %q", got, want)
+	}
+}
+
+func TestBuildDate(t *testing.T) {
+	start := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	if got := (Wiki{out: NewMemOutput()}).buildDate(start); !got.Equal(start) {
+		t.Errorf("buildDate() = %v, want the start time %v", got, start)
+	}
+
+	// Archives get the same date as their files.
+	archiveDate := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := (Wiki{out: NewZipOutput(io.Discard)}).buildDate(start); !got.Equal(archiveDate) {
+		t.Errorf("buildDate() for an archive = %v, want %v", got, archiveDate)
+	}
+
+	// SOURCE_DATE_EPOCH is used for any output.
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	source := fstest.MapFS{"content/index.md": {Data: []byte("Built {{BUILD_DATE}}")}}
+	out := NewMemOutput()
+	theWiki, err := NewWikiFS(source, out, util.DiscardLogger())
+	if err != nil {
+		t.Fatalf("NewWikiFS failed: %v", err)
+	}
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	index, err := out.ReadFile("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Built 2023-11-14"; !strings.Contains(string(index), want) {
+		t.Errorf("index.html doesn't contain %q:\n%s", want, index)
+	}
+}
@@ -4,11 +4,14 @@ package wiki
 import (
 	"embed"
 	"html/template"
+	"io"
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
 )
 
 var markdown goldmark.Markdown
@@ -28,8 +31,8 @@ const defaultHtmlHeaderTemplateText = `<!doctype html>
 <meta name=generator content="gomarkwiki {{.Version}}">
 <title>{{.Title}}</title>
 <link rel="icon" type="image/x-icon" href="{{.RootRelPath}}favicon.ico" />
-<link href="{{.RootRelPath}}style.css" rel="stylesheet" />
-<link href="{{.RootRelPath}}local.css" rel="stylesheet" />
+<link href="{{.Asset "style.css"}}" rel="stylesheet" />
+<link href="{{.Asset "local.css"}}" rel="stylesheet" />
 </head>
 <body>
 `
@@ -44,8 +47,8 @@ const githubHtmlHeaderTemplateText = `<!doctype html>
 <meta name=generator content="gomarkwiki {{.Version}}">
 <title>{{.Title}}</title>
 <link rel="icon" type="image/x-icon" href="{{.RootRelPath}}favicon.ico" />
-<link href="{{.RootRelPath}}github-style.css" rel="stylesheet" />
-<link href="{{.RootRelPath}}github-local.css" rel="stylesheet" />
+<link href="{{.Asset "github-style.css"}}" rel="stylesheet" />
+<link href="{{.Asset "github-local.css"}}" rel="stylesheet" />
 <style>
 	.markdown-body {
 		box-sizing: border-box;
@@ -66,27 +69,103 @@ const githubHtmlHeaderTemplateText = `<!doctype html>
 <article class="markdown-body">
 `
 
+// htmlFooter returns the end of each HTML page, for pages with GitHub styles
+// if gitHubStyle is set.
+func htmlFooter(gitHubStyle bool) string {
+	if gitHubStyle {
+		return "</article>\n</body>\n</html>"
+	}
+	return "</body>\n</html>"
+}
+
 // templateData holds the values used to instantiate HTML from the HTML header template.
+// Title is the page title, Version is the gomarkwiki version, and RootRelPath
+// is the relative path from the page to the root of the dest dir, such as
+// "../", for links to files like style.css.
 type templateData struct {
 	Title       string
 	Version     string
 	RootRelPath string
+
+	fingerprints map[string]string // Fingerprinted dest paths, by slash-separated content path
 }
 
-func init() {
-	// Create markdown converter.
-	markdown = goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
+// newTemplateData returns the templateData for a page.
+func (wiki Wiki) newTemplateData(title, version, rootRelPath string) templateData {
+	data := templateData{Title: title, Version: version, RootRelPath: rootRelPath}
+	if wiki.assets != nil {
+		data.fingerprints = wiki.assets.names
+	}
+	return data
+}
+
+// Asset returns the link from the page to the file name, given relative to
+// the root of the dest dir, using its fingerprinted name if it has one.
+func (data templateData) Asset(name string) string {
+	if fingerprinted, ok := data.fingerprints[name]; ok {
+		name = fingerprinted
+	}
+	return data.RootRelPath + name
+}
+
+// newMarkdown creates a markdown converter with the standard extensions and
+// options, plus extensions and rendererOptions.
+func newMarkdown(extensions []goldmark.Extender, rendererOptions ...renderer.Option) goldmark.Markdown {
+	if len(extensions) == 0 && len(rendererOptions) == 0 && markdown != nil {
+		return markdown
+	}
+	return goldmark.New(
+		goldmark.WithExtensions(append([]goldmark.Extender{extension.GFM}, extensions...)...),
 		goldmark.WithParserOptions(
 			parser.WithAutoHeadingID(),
 			parser.WithAttribute(),
+			parser.WithASTTransformers(util.Prioritized(linkTransformer{}, 1000)),
 		),
 		goldmark.WithRendererOptions(
-			html.WithUnsafe(),
+			append([]renderer.Option{html.WithUnsafe()}, rendererOptions...)...,
 		),
 	)
+}
+
+func init() {
+	// Create markdown converter.
+	markdown = newMarkdown(nil)
 
 	// Create HTML header templates.
 	defaultHtmlHeaderTemplate = template.Must(template.New("defaultHtml").Parse(defaultHtmlHeaderTemplateText))
 	githubHtmlHeaderTemplate = template.Must(template.New("githubHtml").Parse(githubHtmlHeaderTemplateText))
 }
+
+// headerTemplate returns the template used to generate the start of each HTML
+// page, for pages with GitHub styles if gitHubStyle is set.
+func (wiki Wiki) headerTemplate(gitHubStyle bool) *template.Template {
+	if gitHubStyle {
+		if wiki.GitHubHeaderTemplate != nil {
+			return wiki.GitHubHeaderTemplate
+		}
+		return githubHtmlHeaderTemplate
+	}
+	if wiki.HeaderTemplate != nil {
+		return wiki.HeaderTemplate
+	}
+	return defaultHtmlHeaderTemplate
+}
+
+// convert converts the markdown source to HTML, writing it to w. Links are
+// rewritten with rewriter, if it's not nil.
+func (wiki Wiki) convert(source []byte, w io.Writer, rewriter *linkRewriter) error {
+	if rewriter == nil {
+		return wiki.markdown().Convert(source, w)
+	}
+	pc := parser.NewContext()
+	pc.Set(linkRewriterKey, rewriter)
+	return wiki.markdown().Convert(source, w, parser.WithContext(pc))
+}
+
+// markdown returns the markdown converter for the wiki.
+func (wiki Wiki) markdown() goldmark.Markdown {
+	if wiki.converter != nil {
+		return wiki.converter
+	}
+	return markdown
+}
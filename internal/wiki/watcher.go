@@ -4,8 +4,10 @@ package wiki
 import (
 	"context"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -35,6 +37,17 @@ const (
 	MAX_REGEN_INTERVAL = 10 * time.Minute
 )
 
+// WatchEvent is something that happened while watching a wiki, passed to
+// Wiki.OnWatch.
+type WatchEvent int
+
+const (
+	WatchEventReceived WatchEvent = iota // A file system event was received
+	WatchPollCycle                       // A poll cycle compared snapshots, in polling mode
+	WatchStopped                         // Watching stopped, because it was cancelled or failed
+	WatchStarted                         // Watching started
+)
+
 // fileSnapshot records the name, modification time, and size for a given file or directory.
 //
 // IMPORTANT: All fields must be value types or immutable types (strings are OK,
@@ -68,6 +81,9 @@ type Watcher struct {
 	ignorePath    string
 	sourceDir     string // For error messages
 	ignoreMatcher *IgnoreMatcher
+	log           util.Logger
+	onEvent       func(WatchEvent) // Called with each event received and poll cycle, if not nil
+	useGitignore  bool             // Whether .gitignore files are configuration files, kept in snapshots even if ignored
 
 	// pollInterval, when non-zero, switches change detection from fsnotify to a
 	// polling loop. See waitForPollingChange for the polling implementation.
@@ -75,7 +91,12 @@ type Watcher struct {
 
 	// Watcher instance (reused across cycles). Nil when pollInterval > 0.
 	fsWatcher *fsnotify.Watcher
-	mu        sync.Mutex // Protects fsWatcher, snapshot, subsModTime, ignoreModTime, and ignoreMatcher
+	mu        sync.Mutex // Protects fsWatcher, snapshot, subsModTime, ignoreModTime, ignoreMatcher, and extraPaths
+
+	// extraPaths are files outside the snapshot walk that still need to be
+	// watched, such as ignored files that pages include. They're appended to
+	// each snapshot.
+	extraPaths []string
 
 	// Current state
 	snapshot         []fileSnapshot
@@ -106,6 +127,7 @@ type Watcher struct {
 type WatchResult struct {
 	Snapshot      []fileSnapshot // New snapshot after changes stabilized
 	Regen         bool           // Whether full regeneration is needed
+	SubsChanged   bool           // Whether the substitution strings file changed
 	IgnoreChanged bool           // Whether ignore.txt changed
 	Timeout       bool           // Whether the wait timed out
 }
@@ -117,7 +139,7 @@ type WatchResult struct {
 // setup entirely and uses a time.Ticker-driven snapshot comparison loop. This is
 // for filesystems where inotify does not see host-side changes (macOS-virtualized
 // bind mounts, NFS, SMB).
-func NewWatcher(parentCtx context.Context, contentDir, subsPath, ignorePath, sourceDir string, ignoreMatcher *IgnoreMatcher, pollInterval time.Duration) (*Watcher, error) {
+func NewWatcher(parentCtx context.Context, contentDir, subsPath, ignorePath, sourceDir string, ignoreMatcher *IgnoreMatcher, pollInterval time.Duration, log util.Logger) (*Watcher, error) {
 	usePolling := pollInterval > 0
 
 	var fsWatcher *fsnotify.Watcher
@@ -129,7 +151,7 @@ func NewWatcher(parentCtx context.Context, contentDir, subsPath, ignorePath, sou
 		}
 
 		// Set up recursive watching
-		if err := watchDirRecursive(parentCtx, contentDir, fsWatcher); err != nil {
+		if err := watchDirRecursive(parentCtx, log, contentDir, fsWatcher); err != nil {
 			fsWatcher.Close()
 			return nil, fmt.Errorf("failed to initialize watcher for %s: %v", contentDir, err)
 		}
@@ -193,6 +215,7 @@ func NewWatcher(parentCtx context.Context, contentDir, subsPath, ignorePath, sou
 		ignorePath:       ignorePath,
 		sourceDir:        sourceDir,
 		ignoreMatcher:    ignoreMatcher,
+		log:              log,
 		pollInterval:     pollInterval,
 		fsWatcher:        fsWatcher,
 		subsModTime:      subsModTime,
@@ -246,17 +269,16 @@ func (w *Watcher) WaitForChange() (*WatchResult, error) {
 	w.mu.Unlock()
 
 	if snapshot != nil {
-		newSnapshot, err := takeFilesSnapshot(ctx, w.contentDir, matcher)
+		newSnapshot, err := w.takeSnapshot(ctx, matcher)
 		if err != nil {
 			return nil, fmt.Errorf("failed to take snapshot for %s: %v", w.contentDir, err)
 		}
 		if !filesSnapshotsAreEqual(snapshot, newSnapshot) {
-			util.PrintVerbose("Files changed between generation cycles. Starting update.")
+			w.log.Verbose("Files changed between generation cycles. Starting update.")
 
 			// Check if substitution strings file or ignore.txt also changed
 			subsChanged := w.checkSubsFileChanged()
 			ignoreChanged := w.checkIgnoreFileChanged()
-			regen := subsChanged || ignoreChanged
 
 			// Files changed, wait for stability and return
 			stableSnapshot, err := w.waitForStability(ctx)
@@ -265,7 +287,8 @@ func (w *Watcher) WaitForChange() (*WatchResult, error) {
 			}
 			return &WatchResult{
 				Snapshot:      stableSnapshot,
-				Regen:         regen,
+				Regen:         ignoreChanged,
+				SubsChanged:   subsChanged,
 				IgnoreChanged: ignoreChanged,
 				Timeout:       false,
 			}, nil
@@ -276,17 +299,18 @@ func (w *Watcher) WaitForChange() (*WatchResult, error) {
 		ignoreChanged := w.checkIgnoreFileChanged()
 		if subsChanged || ignoreChanged {
 			if subsChanged {
-				util.PrintVerbose("Substitution strings file changed between generation cycles. Starting update.")
+				w.log.Verbose("Substitution strings file changed between generation cycles. Starting update.")
 			}
 			if ignoreChanged {
-				util.PrintVerbose("Ignore expressions file changed between generation cycles. Starting update.")
+				w.log.Verbose("Ignore expressions file changed between generation cycles. Starting update.")
 			}
 			w.mu.Lock()
 			currentSnapshot := w.snapshot
 			w.mu.Unlock()
 			return &WatchResult{
 				Snapshot:      currentSnapshot, // No content changes, so snapshot is still valid
-				Regen:         true,
+				Regen:         ignoreChanged,
+				SubsChanged:   subsChanged,
 				IgnoreChanged: ignoreChanged,
 				Timeout:       false,
 			}, nil
@@ -297,12 +321,12 @@ func (w *Watcher) WaitForChange() (*WatchResult, error) {
 	// ticker loop that compares snapshots; in fsnotify mode it blocks on the
 	// kernel event channel. Both paths feed the same downstream stability
 	// machinery below.
-	var regen, ignoreChanged bool
+	var subsChanged, ignoreChanged bool
 	var err error
 	if w.pollInterval > 0 {
-		regen, ignoreChanged, err = w.waitForPollingChange(ctx)
+		subsChanged, ignoreChanged, err = w.waitForPollingChange(ctx)
 	} else {
-		regen, ignoreChanged, err = w.waitForEvent(ctx)
+		subsChanged, ignoreChanged, err = w.waitForEvent(ctx)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to watch for change event in %s: %v", w.sourceDir, err)
@@ -310,17 +334,17 @@ func (w *Watcher) WaitForChange() (*WatchResult, error) {
 
 	// Check if context expired (timeout for periodic regeneration)
 	if ctx.Err() == context.DeadlineExceeded {
-		util.PrintDebug("Regen timer expired for %s", w.sourceDir)
+		w.log.Debug("Regen timer expired for %s", w.sourceDir)
 
 		// Take fresh snapshot so next cycle has up-to-date state.
 		// Use parent context (w.ctx) since the timeout context already expired.
 		w.mu.Lock()
 		matcher := w.ignoreMatcher
 		w.mu.Unlock()
-		freshSnapshot, err := takeFilesSnapshot(w.ctx, w.contentDir, matcher)
+		freshSnapshot, err := w.takeSnapshot(w.ctx, matcher)
 		if err != nil {
 			// Fall back to old snapshot if we can't take a fresh one
-			util.PrintDebug("Failed to take fresh snapshot on timeout, using old snapshot: %v", err)
+			w.log.Debug("Failed to take fresh snapshot on timeout, using old snapshot: %v", err)
 			w.mu.Lock()
 			snapshot := w.snapshot
 			w.mu.Unlock()
@@ -348,7 +372,8 @@ func (w *Watcher) WaitForChange() (*WatchResult, error) {
 
 	return &WatchResult{
 		Snapshot:      stableSnapshot,
-		Regen:         regen,
+		Regen:         ignoreChanged,
+		SubsChanged:   subsChanged,
 		IgnoreChanged: ignoreChanged,
 		Timeout:       false,
 	}, nil
@@ -359,7 +384,7 @@ func (w *Watcher) WaitForChange() (*WatchResult, error) {
 // State: Idle -> EventDetected
 //
 // This method blocks until:
-//   - A file system event occurs (returns regen flag and ignoreChanged flag)
+//   - A file system event occurs (returns subsChanged flag and ignoreChanged flag)
 //   - The watcher encounters an error (returns error)
 //   - The context times out (returns false, false, caller handles timeout)
 func (w *Watcher) waitForEvent(ctx context.Context) (bool, bool, error) {
@@ -403,18 +428,19 @@ func (w *Watcher) waitForEvent(ctx context.Context) (bool, bool, error) {
 				return false, false, fmt.Errorf("watcher unexpectedly closed while watching '%s'", w.contentDir)
 			}
 		}
-		util.PrintDebug("Watcher event detected for %s: %v", w.contentDir, event)
+		w.log.Debug("Watcher event detected for %s: %v", w.contentDir, event)
+		w.notify(WatchEventReceived)
 
 		// Handle new directory creation - add it to watch list
 		if event.Has(fsnotify.Create) {
 			if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
 				w.mu.Lock()
 				if w.fsWatcher != nil {
-					if err := watchDirRecursive(ctx, event.Name, w.fsWatcher); err != nil {
-						util.PrintVerbose("Failed to watch newly created directory '%s': %v", event.Name, err)
+					if err := watchDirRecursive(ctx, w.log, event.Name, w.fsWatcher); err != nil {
+						w.log.Verbose("Failed to watch newly created directory '%s': %v", event.Name, err)
 						// Continue anyway - snapshot comparison will catch changes
 					} else {
-						util.PrintDebug("Added newly created directory '%s' to watch list", event.Name)
+						w.log.Debug("Added newly created directory '%s' to watch list", event.Name)
 					}
 				}
 				w.mu.Unlock()
@@ -424,15 +450,15 @@ func (w *Watcher) waitForEvent(ctx context.Context) (bool, bool, error) {
 		// Check if substitution strings file or ignore.txt changed
 		subsChanged := eventMatchesConfigFile(event, w.subsPath)
 		if subsChanged {
-			util.PrintVerbose("Substitution strings file '%s' write seen", w.subsPath)
+			w.log.Verbose("Substitution strings file '%s' write seen", w.subsPath)
 		}
 
 		ignoreChanged := eventMatchesConfigFile(event, w.ignorePath)
 		if ignoreChanged {
-			util.PrintVerbose("Ignore expressions file '%s' write seen", w.ignorePath)
+			w.log.Verbose("Ignore expressions file '%s' write seen", w.ignorePath)
 		}
 
-		return subsChanged || ignoreChanged, ignoreChanged, nil
+		return subsChanged, ignoreChanged, nil
 
 	case err, ok := <-errorsChan:
 		if !ok {
@@ -454,7 +480,7 @@ func (w *Watcher) waitForEvent(ctx context.Context) (bool, bool, error) {
 // waitForPollingChange is the polling-mode counterpart to waitForEvent. It
 // ticks at w.pollInterval and returns the moment a snapshot comparison shows
 // any content, substitution, or ignore file has changed. Return contract
-// matches waitForEvent: (regenNeeded, ignoreChanged, error), where
+// matches waitForEvent: (subsChanged, ignoreChanged, error), where
 // (false, false, nil) means the context was cancelled or the periodic
 // regeneration timeout fired (caller distinguishes via ctx.Err()).
 func (w *Watcher) waitForPollingChange(ctx context.Context) (bool, bool, error) {
@@ -471,7 +497,7 @@ func (w *Watcher) waitForPollingChange(ctx context.Context) (bool, bool, error)
 			matcher := w.ignoreMatcher
 			w.mu.Unlock()
 
-			newSnapshot, err := takeFilesSnapshot(ctx, w.contentDir, matcher)
+			newSnapshot, err := w.takeSnapshot(ctx, matcher)
 			if err != nil {
 				// If the snapshot failed because the context was cancelled,
 				// treat it as graceful shutdown / timeout rather than an error.
@@ -481,26 +507,34 @@ func (w *Watcher) waitForPollingChange(ctx context.Context) (bool, bool, error)
 				return false, false, fmt.Errorf("polling snapshot failed for %s: %v", w.contentDir, err)
 			}
 
+			w.notify(WatchPollCycle)
 			contentChanged := baseline != nil && !filesSnapshotsAreEqual(baseline, newSnapshot)
 			subsChanged := w.checkSubsFileChanged()
 			ignoreChanged := w.checkIgnoreFileChanged()
 
 			if contentChanged || subsChanged || ignoreChanged {
 				if contentChanged {
-					util.PrintDebug("Polling tick detected content change in %s", w.contentDir)
+					w.log.Debug("Polling tick detected content change in %s", w.contentDir)
 				}
 				if subsChanged {
-					util.PrintVerbose("Polling tick detected change in substitution strings file '%s'", w.subsPath)
+					w.log.Verbose("Polling tick detected change in substitution strings file '%s'", w.subsPath)
 				}
 				if ignoreChanged {
-					util.PrintVerbose("Polling tick detected change in ignore expressions file '%s'", w.ignorePath)
+					w.log.Verbose("Polling tick detected change in ignore expressions file '%s'", w.ignorePath)
 				}
-				return subsChanged || ignoreChanged, ignoreChanged, nil
+				return subsChanged, ignoreChanged, nil
 			}
 		}
 	}
 }
 
+// notify calls onEvent with event, if it's set.
+func (w *Watcher) notify(event WatchEvent) {
+	if w.onEvent != nil {
+		w.onEvent(event)
+	}
+}
+
 func eventMatchesConfigFile(event fsnotify.Event, path string) bool {
 	if path == "" {
 		return false
@@ -525,7 +559,7 @@ func eventMatchesConfigFile(event fsnotify.Event, path string) bool {
 // regenerating during rapid file operations (e.g., bulk edits, git operations).
 // Continues until two consecutive snapshots match, indicating changes are complete.
 func (w *Watcher) waitForStability(ctx context.Context) ([]fileSnapshot, error) {
-	util.PrintDebug("Waiting for changes to finish in %s", w.contentDir)
+	w.log.Debug("Waiting for changes to finish in %s", w.contentDir)
 
 	// Use a result channel pattern for cleaner coordination
 	type result struct {
@@ -583,16 +617,16 @@ func (w *Watcher) waitForStability(ctx context.Context) ([]fileSnapshot, error)
 			// Log wait status
 			message := fmt.Sprintf("Wait for change pass %d for %s", waitPass, w.contentDir)
 			if waitPass > 1 {
-				util.PrintVerbose(message)
+				w.log.Verbose(message)
 			} else {
-				util.PrintDebug(message)
+				w.log.Debug(message)
 			}
 
 			// Take before snapshot
 			if snapshot2 != nil {
 				snapshot1 = snapshot2
 			} else {
-				snapshot1, err = takeFilesSnapshot(ctx, w.contentDir, matcher)
+				snapshot1, err = w.takeSnapshot(ctx, matcher)
 				if err != nil {
 					resultChan <- result{err: fmt.Errorf("failed to take files snapshot for %s: %v", w.contentDir, err)}
 					return
@@ -604,7 +638,7 @@ func (w *Watcher) waitForStability(ctx context.Context) ([]fileSnapshot, error)
 			// For the current values, this kicks in at waitPass >= 7.
 			shift := waitPass - 1
 			waitTime := min(CHANGE_WAIT*(1<<shift), MAX_CHANGE_WAIT)
-			util.PrintDebug("Waiting %d ms for %s", waitTime.Milliseconds(), w.contentDir)
+			w.log.Debug("Waiting %d ms for %s", waitTime.Milliseconds(), w.contentDir)
 
 			// Wait with cancellation support
 			select {
@@ -619,7 +653,7 @@ func (w *Watcher) waitForStability(ctx context.Context) ([]fileSnapshot, error)
 			}
 
 			// Take after snapshot
-			snapshot2, err = takeFilesSnapshot(ctx, w.contentDir, matcher)
+			snapshot2, err = w.takeSnapshot(ctx, matcher)
 			if err != nil {
 				resultChan <- result{err: fmt.Errorf("failed to take files snapshot for %s: %v", w.contentDir, err)}
 				return
@@ -627,7 +661,7 @@ func (w *Watcher) waitForStability(ctx context.Context) ([]fileSnapshot, error)
 		}
 
 		// Snapshots match - changes have stabilized
-		util.PrintDebug("Snapshots match for %s", w.contentDir)
+		w.log.Debug("Snapshots match for %s", w.contentDir)
 		resultChan <- result{snapshot: snapshot2}
 	}()
 
@@ -658,7 +692,7 @@ func (w *Watcher) checkSubsFileChanged() bool {
 			w.mu.Unlock()
 			return changed
 		}
-		util.PrintWarning("Failed to stat substitution strings file '%s': %v", w.subsPath, err)
+		w.log.Warning("Failed to stat substitution strings file '%s': %v", w.subsPath, err)
 		return false
 	}
 
@@ -689,7 +723,7 @@ func (w *Watcher) checkIgnoreFileChanged() bool {
 			w.mu.Unlock()
 			return changed
 		}
-		util.PrintWarning("Failed to stat ignore expressions file '%s': %v", w.ignorePath, err)
+		w.log.Warning("Failed to stat ignore expressions file '%s': %v", w.ignorePath, err)
 		return false
 	}
 
@@ -721,6 +755,52 @@ func (w *Watcher) UpdateIgnoreMatcher(matcher *IgnoreMatcher) {
 	w.mu.Unlock()
 }
 
+// SetExtraPaths sets the files outside the snapshot walk that need to be
+// watched too, and updates the current snapshot to match.
+func (w *Watcher) SetExtraPaths(paths []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if slices.Equal(w.extraPaths, paths) {
+		return
+	}
+
+	// Replace the extra entries at the end of the snapshot.
+	if w.snapshot != nil {
+		snapshot := slices.Clone(w.snapshot[:len(w.snapshot)-len(w.extraPaths)])
+		w.snapshot = append(snapshot, takeExtraPathsSnapshot(paths)...)
+	}
+	w.extraPaths = paths
+}
+
+// takeSnapshot takes a snapshot of the content directory plus any extra paths.
+func (w *Watcher) takeSnapshot(ctx context.Context, matcher *IgnoreMatcher) ([]fileSnapshot, error) {
+	snapshot, err := takeFilesSnapshot(ctx, w.log, w.contentDir, matcher, w.useGitignore)
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	extraPaths := w.extraPaths
+	w.mu.Unlock()
+	return append(snapshot, takeExtraPathsSnapshot(extraPaths)...), nil
+}
+
+// takeExtraPathsSnapshot records the modification times and sizes of paths.
+// Paths that can't be stated are recorded with a size of -1, so that their
+// creation is seen as a change.
+func takeExtraPathsSnapshot(paths []string) []fileSnapshot {
+	snapshots := make([]fileSnapshot, 0, len(paths))
+	for _, path := range paths {
+		snapshot := fileSnapshot{name: path, size: -1}
+		if info, err := os.Stat(path); err == nil {
+			snapshot.timestamp = info.ModTime().UnixNano()
+			snapshot.size = info.Size()
+			snapshot.isDir = info.IsDir()
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
 // GetSnapshot returns the current snapshot.
 func (w *Watcher) GetSnapshot() []fileSnapshot {
 	w.mu.Lock()
@@ -730,21 +810,28 @@ func (w *Watcher) GetSnapshot() []fileSnapshot {
 
 // watch watches for changes in the wiki content directory and regenerates files on the fly.
 func (wiki *Wiki) watch(ctx context.Context, clean bool, version string) error {
-	util.PrintVerbose("Watching for changes in '%s'", wiki.ContentDir)
+	wiki.log.Verbose("Watching for changes in '%s'", wiki.ContentDir)
 
 	// Create watcher with parent context
-	watcher, err := NewWatcher(ctx, wiki.ContentDir, wiki.subsPath, wiki.ignorePath, wiki.SourceDir, wiki.ignoreMatcher, wiki.PollInterval)
+	watcher, err := NewWatcher(ctx, wiki.ContentDir, wiki.subsPath, wiki.ignorePath, wiki.SourceDir, wiki.ignoreMatcher, wiki.PollInterval, util.With(wiki.log, "phase", "watch"))
 	if err != nil {
 		return fmt.Errorf("failed to create watcher: %v", err)
 	}
 	defer watcher.Close()
+	watcher.onEvent = wiki.OnWatch
+	watcher.useGitignore = wiki.UseGitignore
+	if wiki.OnWatch != nil {
+		wiki.OnWatch(WatchStarted)
+		defer wiki.OnWatch(WatchStopped)
+	}
 
 	// Take initial snapshot
-	initialSnapshot, err := takeFilesSnapshot(ctx, wiki.ContentDir, wiki.ignoreMatcher)
+	initialSnapshot, err := watcher.takeSnapshot(ctx, wiki.ignoreMatcher)
 	if err != nil {
 		return fmt.Errorf("failed to take initial snapshot: %v", err)
 	}
 	watcher.UpdateSnapshot(initialSnapshot)
+	watcher.SetExtraPaths(wiki.deps.includedFiles())
 
 	// Main watch loop
 	for {
@@ -766,17 +853,26 @@ func (wiki *Wiki) watch(ctx context.Context, clean bool, version string) error {
 		}
 
 		// Update snapshot
+		previousSnapshot := watcher.GetSnapshot()
 		watcher.UpdateSnapshot(result.Snapshot)
 		// Note: UpdateSnapshot also updates subsModTime and ignoreModTime if files changed
 
-		// Reload substitution strings if needed
+		// Reload substitution strings if needed, and mark the pages that use
+		// placeholders that were added, changed, or removed for regeneration.
+		// Other pages are left alone.
 		subsReloadFailed := false
-		if result.Regen {
-			util.PrintVerbose("Reloading substitution strings from '%s'", wiki.subsPath)
+		if result.SubsChanged {
+			wiki.log.Verbose("Reloading substitution strings from '%s'", wiki.subsPath)
+			previousSubStrings := wiki.subStrings
 			if err := wiki.loadSubstitutionStrings(); err != nil {
 				// Log error but continue - still need to check ignore expressions and regenerate
-				util.PrintError(err, "failed to reload substitution strings file, keeping previous configuration")
+				wiki.log.Error(err, "failed to reload substitution strings file, keeping previous configuration")
+				wiki.subStrings = previousSubStrings
 				subsReloadFailed = true
+			} else {
+				changed := changedPlaceholders(previousSubStrings, wiki.subStrings)
+				wiki.stalePages = wiki.pagesUsingPlaceholders(changed)
+				wiki.log.Verbose("%d placeholder(s) changed, used by %d page(s)", len(changed), len(wiki.stalePages))
 			}
 			// Mod time already updated by UpdateSnapshot above
 		}
@@ -784,10 +880,10 @@ func (wiki *Wiki) watch(ctx context.Context, clean bool, version string) error {
 		// Reload ignore expressions if needed (independent of substitution strings reload)
 		ignoreReloadFailed := false
 		if result.IgnoreChanged {
-			util.PrintVerbose("Reloading ignore expressions from '%s'", wiki.ignorePath)
+			wiki.log.Verbose("Reloading ignore expressions from '%s'", wiki.ignorePath)
 			if err := wiki.loadIgnoreExpressions(); err != nil {
 				// Log error but continue - still regenerate with previous config
-				util.PrintError(err, "failed to reload ignore expressions file, keeping previous configuration")
+				wiki.log.Error(err, "failed to reload ignore expressions file, keeping previous configuration")
 				ignoreReloadFailed = true
 			} else {
 				// Update watcher's ignore matcher with the new one
@@ -796,7 +892,7 @@ func (wiki *Wiki) watch(ctx context.Context, clean bool, version string) error {
 				// Take a fresh snapshot with the new matcher to avoid mismatch
 				// between the stored snapshot (filtered with old rules) and future
 				// snapshots (filtered with new rules)
-				freshSnapshot, err := takeFilesSnapshot(ctx, wiki.ContentDir, wiki.ignoreMatcher)
+				freshSnapshot, err := watcher.takeSnapshot(ctx, wiki.ignoreMatcher)
 				if err != nil {
 					return fmt.Errorf("failed to take fresh snapshot after ignore reload: %v", err)
 				}
@@ -805,21 +901,58 @@ func (wiki *Wiki) watch(ctx context.Context, clean bool, version string) error {
 			}
 		}
 
+		// Reload the per-directory configuration files that changed, just in
+		// the directories where they changed. These are part of the content
+		// snapshot, so changes to them are found by comparing snapshots.
+		// They're reloaded by loadIgnoreExpressions too, so that's skipped
+		// if ignore.txt was just reloaded.
+		if dirs := wiki.changedDirConfigs(previousSnapshot, result.Snapshot); len(dirs) > 0 && !result.IgnoreChanged {
+			stalePages, ignoreChanged, err := wiki.reloadDirConfigs(dirs)
+			if err != nil {
+				wiki.log.Error(err, "failed to reload directory configuration, keeping previous configuration")
+			} else {
+				if wiki.stalePages == nil {
+					wiki.stalePages = stalePages
+				} else {
+					maps.Copy(wiki.stalePages, stalePages)
+				}
+				if ignoreChanged {
+					watcher.UpdateIgnoreMatcher(wiki.ignoreMatcher)
+					freshSnapshot, err := watcher.takeSnapshot(ctx, wiki.ignoreMatcher)
+					if err != nil {
+						return fmt.Errorf("failed to take fresh snapshot after ignore reload: %v", err)
+					}
+					watcher.UpdateSnapshot(freshSnapshot)
+				}
+			}
+		}
+
 		// If both config reloads failed, skip generation to avoid confusing state
 		if subsReloadFailed && ignoreReloadFailed {
-			util.PrintWarning("Skipping generation due to config reload failures")
+			wiki.log.Warning("Skipping generation due to config reload failures")
 			continue
 		}
 
 		// Skip generation if this was just a timeout (periodic regen)
 		if result.Timeout {
-			util.PrintDebug("Periodic regeneration for %s", wiki.SourceDir)
+			wiki.log.Debug("Periodic regeneration for %s", wiki.SourceDir)
 		}
 
 		// Update wiki
-		if err = wiki.generate(ctx, result.Regen, clean, version); err != nil {
+		err = wiki.build(ctx, result.Regen, clean, version)
+		wiki.stalePages = nil
+
+		// Keep files that pages include under watch, including any that
+		// are ignored and so not part of the snapshot walk.
+		watcher.SetExtraPaths(wiki.deps.includedFiles())
+
+		if err != nil {
+			// Stop quietly if watching was cancelled during generation
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			// In watch mode, log the error but continue watching
-			util.PrintError(err, "failed to update %s wiki", wiki.SourceDir)
+			wiki.log.Error(err, "failed to update %s wiki", wiki.SourceDir)
 			// Continue the loop instead of returning
 			continue
 		}
@@ -827,7 +960,7 @@ func (wiki *Wiki) watch(ctx context.Context, clean bool, version string) error {
 }
 
 // watchDirRecursive sets up watches on the specified directory and all subdirectories recursively.
-func watchDirRecursive(ctx context.Context, path string, watcher *fsnotify.Watcher) error {
+func watchDirRecursive(ctx context.Context, log util.Logger, path string, watcher *fsnotify.Watcher) error {
 	err := watcher.Add(path)
 	if err != nil {
 		return fmt.Errorf("failed to watch directory '%s': '%s'", path, err)
@@ -850,7 +983,7 @@ func watchDirRecursive(ctx context.Context, path string, watcher *fsnotify.Watch
 
 		if err != nil {
 			// If we can't access a path during setup, warn and skip it but continue setup
-			util.PrintWarning("Failed to access '%s' during watch setup: %v", subPath, err)
+			log.Warning("Failed to access '%s' during watch setup: %v", subPath, err)
 			if info != nil && info.IsDir() {
 				return filepath.SkipDir
 			}
@@ -869,8 +1002,10 @@ func watchDirRecursive(ctx context.Context, path string, watcher *fsnotify.Watch
 }
 
 // takeFilesSnapshot records the names and  modification times of all files and directories in dir recursively.
-// Files matching the ignore patterns are excluded from the snapshot.
-func takeFilesSnapshot(ctx context.Context, dir string, ignoreMatcher *IgnoreMatcher) ([]fileSnapshot, error) {
+// Files matching the ignore patterns are excluded from the snapshot, other
+// than per-directory configuration files, and .gitignore files if
+// useGitignore is set.
+func takeFilesSnapshot(ctx context.Context, log util.Logger, dir string, ignoreMatcher *IgnoreMatcher, useGitignore bool) ([]fileSnapshot, error) {
 	var snapshots []fileSnapshot
 	baseDepth := strings.Count(dir, string(filepath.Separator))
 
@@ -889,18 +1024,21 @@ func takeFilesSnapshot(ctx context.Context, dir string, ignoreMatcher *IgnoreMat
 		}
 
 		if err != nil {
-			util.PrintWarning("Failed to access '%s' during snapshot: %v", path, err)
+			log.Warning("Failed to access '%s' during snapshot: %v", path, err)
 			if info != nil && info.IsDir() {
 				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		// Check if this file should be ignored
-		if ignoreMatcher != nil {
+		// Check if this file should be ignored. Per-directory configuration
+		// files, including .gitignore files when they're honored, are kept so
+		// that changes to them are seen.
+		isConfig := isDirConfigFile(path) || useGitignore && info.Name() == gitignoreFileName
+		if ignoreMatcher != nil && (info.IsDir() || !isConfig) {
 			relPath, err := filepath.Rel(dir, path)
 			if err == nil && ignoreMatcher.Matches(relPath, info.IsDir()) {
-				util.PrintDebug("Ignoring '%s' in snapshot", path)
+				log.Debug("Ignoring '%s' in snapshot", path)
 				if info.IsDir() {
 					return filepath.SkipDir
 				}
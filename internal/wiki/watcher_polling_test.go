@@ -35,7 +35,7 @@ func pollTestSetup(t *testing.T, pollInterval time.Duration, initialContent stri
 		t.Fatal(err)
 	}
 
-	w, err := NewWiki(sourceDir, destDir)
+	w, err := NewWiki(sourceDir, destDir, nil)
 	if err != nil {
 		os.RemoveAll(tempDir)
 		t.Fatalf("NewWiki failed: %v", err)
@@ -39,7 +39,7 @@ func TestWatcherRobustness(t *testing.T) {
 	// We expect it to NOT fail completely, or at least handle it gracefully?
 	// The current code suggests it will fail.
 
-	wiki, err := NewWiki(sourceDir, destDir)
+	wiki, err := NewWiki(sourceDir, destDir, nil)
 	if err != nil {
 		t.Fatalf("NewWiki failed: %v", err)
 	}
@@ -2,14 +2,19 @@
 package wiki
 
 import (
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
+	"html/template"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/yuin/goldmark"
+
 	"github.com/stalexan/gomarkwiki/internal/util"
 )
 
@@ -20,20 +25,146 @@ type Wiki struct {
 	ContentDir string // Content directory within source directory
 	DestDir    string // Dest directory where wiki will be generated
 
-	subStrings [][2]string // Substitution strings. Each pair is the string to look for and what to replace it with.
-	subsPath   string      // Path to substitution strings file.
+	source      fs.FS  // Source files, rooted at SourceDir
+	sourceIsDir bool   // Whether source is the directory SourceDir on disk, which can be watched
+	out         Output // Where the wiki is generated
+
+	subStrings map[string]string // Substitution strings: the value of each placeholder, by name
+	subsPath   string            // Path to substitution strings file.
+
+	dirSubStrings map[string]map[string]string // Substitution strings from per-directory files, keyed by directory relative to ContentDir
 
 	ignoreMatcher *IgnoreMatcher // Gitignore-style pattern matcher
 	ignorePath    string         // Path to ignore.txt file.
 
+	gitignoreLoaded bool // Whether the ignore patterns include those from .gitignore files
+
+	deps       *dependencyGraph // Files and placeholders each page depends on, for dependency-aware regeneration
+	stalePages map[string]bool  // Full paths of pages to regenerate on the next generate, even if up to date
+
+	buildTime time.Time // Time of the current generate, for the BUILD_DATE built-in variable
+
+	lastLayout string // Layout of the last generate, to regenerate all pages when it changes
+
+	assets *assetFingerprints // Fingerprinted names of static files, when Fingerprint is set
+	images *imageCache        // Sizes of images, when ImageWidths is set
+
+	log util.Logger // Where messages, warnings, and errors are printed
+
+	hooks   []hook        // Commands to run before and after each generation, from hooks.csv
+	plugins *pluginRunner // Commands that transform the markdown of pages, from plugins.csv
+
+	result    *BuildResult      // What the current generate has done so far
+	converter goldmark.Markdown // Markdown converter, with Extensions added
+
 	// PollInterval, when non-zero, switches watch mode from fsnotify to a
 	// polling loop. Used to support filesystems where inotify does not see
 	// host-side changes (macOS-virtualized bind mounts, NFS, SMB, etc.).
 	PollInterval time.Duration
+
+	// HookTimeout is how long each hook command may run before it's killed.
+	// DefaultHookTimeout is used if it's zero.
+	HookTimeout time.Duration
+
+	// Layout is where pages go in the dest dir: LayoutFlat, the default if
+	// it's empty, or LayoutPretty.
+	Layout string
+
+	// LinkStatic is how static files are put in the dest dir: LinkStaticCopy,
+	// the default if it's empty, or LinkStaticReflink. Files that can't be
+	// cloned are copied.
+	LinkStatic string
+
+	// Gzip makes a gzip compressed sibling, named with .gz added, be written
+	// next to each HTML, CSS, JavaScript, SVG, and JSON file in the dest dir
+	// that's at least GzipMinSize bytes, compressed at GzipLevel, or
+	// DefaultGzipLevel if it's zero.
+	Gzip        bool
+	GzipLevel   int
+	GzipMinSize int64
+
+	// Fingerprint adds a hash of their contents to the names of the style
+	// sheets, and of the CSS, JavaScript, image, and font files copied from
+	// the content dir, as in style.3f9a1c2b.css, so that they can be served
+	// with long cache lifetimes. Links to them in the header templates and in
+	// markdown are rewritten to match, and each file is also written under
+	// its original name for links that aren't.
+	Fingerprint bool
+
+	// ImageWidths are the widths, in pixels, of the downscaled variants
+	// written for each PNG, JPEG, and GIF image in the content dir that's
+	// wider and that a page shows. Images in markdown are given a srcset that
+	// lists the variants, along with width, height, and lazy loading
+	// attributes.
+	ImageWidths []int
+
+	// Minify removes comments and collapses whitespace in the HTML of
+	// generated pages and in the CSS files written to the dest dir. The
+	// content of pre, textarea, and code elements is left as is.
+	Minify bool
+
+	// DryRun makes generation go through every step, including cleaning,
+	// without writing or deleting anything in the dest dir. What would have
+	// been done is recorded in the BuildResult. Hooks aren't run in a dry
+	// run, and it can't be combined with watch mode.
+	DryRun bool
+
+	// PluginTimeout is how long a plugin may take to transform a page
+	// before it's killed. DefaultPluginTimeout is used if it's zero.
+	PluginTimeout time.Duration
+
+	// EnvAllowlist names the environment variables that {{env:NAME}}
+	// placeholders may expand to. Other environment variables are left as is.
+	EnvAllowlist []string
+
+	// SubstituteInCode enables substitutions inside fenced code blocks and
+	// inline code, which are otherwise left as is.
+	SubstituteInCode bool
+
+	// CacheDir, when not empty, is the directory where what each page depends
+	// on is saved between runs, so that pages that haven't changed aren't
+	// read again to find whether anything they include has. It's only used
+	// for wikis generated from a source dir on disk.
+	CacheDir string
+
+	// UseGitignore makes the wiki honor .gitignore files in the content dir,
+	// along with ignore.txt and .gomarkwiki-ignore files, and ignore .git
+	// directories.
+	UseGitignore bool
+
+	// HeaderTemplate and GitHubHeaderTemplate, when not nil, replace the
+	// templates used to generate the start of each HTML page, for pages with
+	// default and GitHub styles. See templateData for the values they're
+	// given.
+	HeaderTemplate       *template.Template
+	GitHubHeaderTemplate *template.Template
+
+	// Chapters lists pages, by their paths relative to the exported directory
+	// and with or without their extensions, in the order they go in an EPUB
+	// export. Pages that aren't listed follow them.
+	Chapters []string
+
+	// Extensions are goldmark extensions to use in addition to the standard ones.
+	Extensions []goldmark.Extender
+
+	// OnBuild, when not nil, is called after each generation of the wiki,
+	// including each regeneration in watch mode, with what it did and the
+	// error it returned, if any.
+	OnBuild func(result *BuildResult, err error)
+
+	// OnWatch, when not nil, is called in watch mode when watching starts,
+	// with each file system event received and each poll cycle, and when
+	// watching stops.
+	OnWatch func(event WatchEvent)
 }
 
-// NewWiki constructs a new instance of Wiki.
-func NewWiki(sourceDir, destDir string) (*Wiki, error) {
+// NewWiki constructs a new instance of Wiki. Messages are printed with log,
+// or with util.DefaultLogger if log is nil.
+func NewWiki(sourceDir, destDir string, log util.Logger) (*Wiki, error) {
+	if log == nil {
+		log = util.DefaultLogger()
+	}
+
 	// Resolve absolute paths for comparison
 	absSourceDir, err := filepath.Abs(sourceDir)
 	if err != nil {
@@ -43,6 +174,7 @@ func NewWiki(sourceDir, destDir string) (*Wiki, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve absolute path of destination directory '%s': %v", destDir, err)
 	}
+	log = util.With(log, "wiki", filepath.Base(absSourceDir), "source", absSourceDir)
 
 	// Use EvalSymlinks to handle cases where one path might be a symlink to the other
 	evalSourceDir, err := filepath.EvalSymlinks(absSourceDir)
@@ -81,14 +213,73 @@ func NewWiki(sourceDir, destDir string) (*Wiki, error) {
 		}
 	}
 
+	wiki, err := newDirWiki(absSourceDir, log)
+	if err != nil {
+		return nil, err
+	}
+	wiki.DestDir = absDestDir
+
+	// Generate to an archive if the dest is one, or else to the dest dir.
+	// Either way, the directory is created when the wiki is first built, so
+	// that a dry run doesn't create it.
+	if IsArchivePath(absDestDir) {
+		if wiki.out, err = NewArchiveFileOutput(absDestDir); err != nil {
+			return nil, err
+		}
+	} else {
+		wiki.out = NewDirOutput(absDestDir, log)
+	}
+
+	if err := wiki.loadConfig(); err != nil {
+		return nil, err
+	}
+
+	return wiki, nil
+}
+
+// NewSourceWiki constructs a new instance of Wiki for the source directory
+// sourceDir that's exported, as with ExportHTML, rather than generated, and
+// so has no dest dir. Messages are printed with log, or with
+// util.DefaultLogger if log is nil.
+func NewSourceWiki(sourceDir string, log util.Logger) (*Wiki, error) {
+	if log == nil {
+		log = util.DefaultLogger()
+	}
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve absolute path of source directory '%s': %v", sourceDir, err)
+	}
+	log = util.With(log, "wiki", filepath.Base(absSourceDir), "source", absSourceDir)
+
+	wiki, err := newDirWiki(absSourceDir, log)
+	if err != nil {
+		return nil, err
+	}
+	wiki.out = NewMemOutput()
+
+	if err := wiki.loadConfig(); err != nil {
+		return nil, err
+	}
+
+	return wiki, nil
+}
+
+// newDirWiki constructs the Wiki for the source directory absSourceDir on
+// disk, without a dest dir or config, after checking that its directories
+// exist.
+func newDirWiki(absSourceDir string, log util.Logger) (*Wiki, error) {
 	wiki := Wiki{
 		SourceDir:     absSourceDir,
 		ContentDir:    filepath.Join(absSourceDir, "content"),
-		DestDir:       absDestDir,
+		source:        os.DirFS(absSourceDir),
+		sourceIsDir:   true,
 		subStrings:    nil,
 		subsPath:      "",
 		ignoreMatcher: nil,
 		ignorePath:    "",
+		deps:          newDependencyGraph(),
+		plugins:       &pluginRunner{},
+		log:           log,
 	}
 
 	// Check that source directories exist and are directories.
@@ -103,36 +294,115 @@ func NewWiki(sourceDir, destDir string) (*Wiki, error) {
 		}
 	}
 
-	// Create destination directory if it doesn't exist.
-	if err := os.MkdirAll(wiki.DestDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create destination directory '%s': %v", wiki.DestDir, err)
+	return &wiki, nil
+}
+
+// NewWikiFS constructs a new instance of Wiki that's generated from source,
+// which is laid out like a wiki source directory, to out. Paths in messages
+// are relative to the root of source. Such a wiki can't be watched for
+// changes. Messages are printed with log, or with util.DefaultLogger if log
+// is nil.
+func NewWikiFS(source fs.FS, out Output, log util.Logger) (*Wiki, error) {
+	if log == nil {
+		log = util.DefaultLogger()
 	}
 
-	// Load substitution strings.
-	if err := wiki.loadSubstitutionStrings(); err != nil {
+	wiki := Wiki{
+		SourceDir:  ".",
+		ContentDir: "content",
+		source:     source,
+		out:        out,
+		deps:       newDependencyGraph(),
+		plugins:    &pluginRunner{},
+		log:        log,
+	}
+
+	// Check that the content directory exists and is a directory.
+	if err := wiki.checkSourceDir(wiki.ContentDir); err != nil {
 		return nil, err
 	}
 
-	// Load ignore expressions.
-	if err := wiki.loadIgnoreExpressions(); err != nil {
+	if err := wiki.loadConfig(); err != nil {
 		return nil, err
 	}
 
 	return &wiki, nil
 }
 
+// loadConfig loads the substitution strings and ignore expressions.
+func (wiki *Wiki) loadConfig() error {
+	// Load substitution strings.
+	if err := wiki.loadSubstitutionStrings(); err != nil {
+		return err
+	}
+
+	// Load ignore expressions.
+	return wiki.loadIgnoreExpressions()
+}
+
+// destPath returns the path of the dest file relDestPath, relative to the
+// dest dir, for messages.
+func (wiki Wiki) destPath(relDestPath string) string {
+	return filepath.Join(wiki.DestDir, relDestPath)
+}
+
 // Generate generates a wiki and then optionally watches for changes in the
 // wiki to regenerate files on the fly.
 func (wiki *Wiki) Generate(ctx context.Context, regen, clean, watch bool, version string) error {
-	util.PrintVerbose("Generating wiki '%s' from '%s'", wiki.DestDir, wiki.SourceDir)
+	wiki.log.Verbose("Generating wiki '%s' from '%s'", wiki.DestDir, wiki.SourceDir)
 
 	// Check for cancellation before starting
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
+	// Only a source directory on disk can be watched.
+	if watch && !wiki.sourceIsDir {
+		return fmt.Errorf("cannot watch wiki '%s': watch mode requires a source directory", wiki.SourceDir)
+	}
+
+	if wiki.Layout != "" && wiki.Layout != LayoutFlat && wiki.Layout != LayoutPretty {
+		return fmt.Errorf("unknown layout '%s' for wiki '%s'", wiki.Layout, wiki.SourceDir)
+	}
+
+	switch wiki.LinkStatic {
+	case "", LinkStaticCopy, LinkStaticReflink:
+	default:
+		return fmt.Errorf("unknown static file link mode '%s' for wiki '%s'", wiki.LinkStatic, wiki.SourceDir)
+	}
+
+	for _, width := range wiki.ImageWidths {
+		if width <= 0 {
+			return fmt.Errorf("invalid image width %d for wiki '%s'", width, wiki.SourceDir)
+		}
+	}
+
+	if wiki.GzipLevel != 0 && (wiki.GzipLevel < gzip.BestSpeed || wiki.GzipLevel > gzip.BestCompression) {
+		return fmt.Errorf("invalid gzip level %d for wiki '%s'", wiki.GzipLevel, wiki.SourceDir)
+	}
+
+	// An archive is written whole, so there's nothing to update on the fly.
+	if _, ok := wiki.out.(*ArchiveOutput); ok && watch {
+		return fmt.Errorf("cannot watch wiki '%s' when it's generated to an archive", wiki.SourceDir)
+	}
+
+	// A dry run generates once, so there's nothing to watch.
+	if watch && wiki.DryRun {
+		return fmt.Errorf("cannot watch wiki '%s' in a dry run", wiki.SourceDir)
+	}
+
+	// Reload ignore expressions if UseGitignore was changed after they were loaded.
+	if wiki.UseGitignore != wiki.gitignoreLoaded {
+		if err := wiki.loadIgnoreExpressions(); err != nil {
+			return err
+		}
+	}
+
+	// Stop any plugins left running when done.
+	defer wiki.closePlugins()
+
 	// Generate wiki.
-	if err := wiki.generate(ctx, regen, clean, version); err != nil {
+	if err := wiki.build(ctx, regen, clean, version); err != nil {
 		return fmt.Errorf("failed to generate wiki '%s': %v", wiki.SourceDir, err)
 	}
 
@@ -150,6 +420,18 @@ func (wiki *Wiki) Generate(ctx context.Context, regen, clean, watch bool, versio
 	return nil
 }
 
+// beginPhase starts the phase name of a generation, timing it and adding it
+// to messages logged, and returns a function to call when it ends.
+func (wiki *Wiki) beginPhase(name string) func() {
+	log := wiki.log
+	wiki.log = util.With(log, "phase", name)
+	endTiming := wiki.result.timePhase(name)
+	return func() {
+		endTiming()
+		wiki.log = log
+	}
+}
+
 // generate generates the wiki.
 //
 // Error handling strategy (fail-soft):
@@ -159,18 +441,99 @@ func (wiki *Wiki) Generate(ctx context.Context, regen, clean, watch bool, versio
 // - Clean protection: -clean is skipped entirely if ANY errors occur, preventing deletion of valid files
 // - Complete error logging: All file processing errors are logged, but don't fail the build
 // - Only fail on total failure: Return error only if no files were processed successfully
-func (wiki *Wiki) generate(ctx context.Context, regen, clean bool, version string) error {
+func (wiki *Wiki) generate(ctx context.Context, regen, clean bool, version string) (err error) {
 	// Check for cancellation before starting
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
 
+	start := time.Now()
+	wiki.buildTime = wiki.buildDate(start)
+	wiki.converter = newMarkdown(wiki.Extensions)
+
+	// Pages that are in the same place in both layouts, such as index pages,
+	// have different links, so regenerate everything when the layout changes.
+	if wiki.lastLayout != "" && wiki.lastLayout != wiki.layout() {
+		wiki.log.Verbose("Layout changed to %s, so regenerating all pages", wiki.layout())
+		regen = true
+	}
+	wiki.lastLayout = wiki.layout()
+
+	// Record what's done, and report it when done.
+	wiki.result = &BuildResult{Start: start}
+	defer func() {
+		wiki.result.Duration = time.Since(wiki.result.Start)
+		if wiki.OnBuild != nil {
+			wiki.OnBuild(wiki.result, err)
+		}
+	}()
+
+	// Let the output prepare for the build, and write an archive at the end
+	// of a build that succeeds, removing its spool either way.
+	if builder, ok := wiki.out.(buildOutput); ok {
+		if err := builder.beginBuild(wiki.DryRun); err != nil {
+			return err
+		}
+	}
+	if archive, ok := wiki.out.(*ArchiveOutput); ok {
+		defer func() {
+			if err == nil && !wiki.DryRun {
+				endPhase := wiki.beginPhase("archive")
+				err = archive.endBuild(ctx, true)
+				endPhase()
+			} else {
+				archive.endBuild(ctx, false)
+			}
+		}()
+	}
+
+	// In a dry run, nothing is written to or deleted from the output.
+	if wiki.DryRun {
+		wiki.result.DryRun = true
+		out := wiki.out
+		wiki.out = &dryRunOutput{out: out, result: wiki.result, log: wiki.log}
+		defer func() { wiki.out = out }()
+	}
+
+	// Write .gz siblings. In a dry run they're written to the dry run, so
+	// that the siblings that would be created are recorded too.
+	if wiki.Gzip {
+		out := wiki.out
+		wiki.out = &gzipOutput{out: out, level: wiki.gzipLevel(), minSize: wiki.GzipMinSize}
+		defer func() { wiki.out = out }()
+	}
+
+	// Find the fingerprinted names of static files before pages link to them.
+	if err := wiki.findFingerprints(ctx); err != nil {
+		return err
+	}
+	if len(wiki.ImageWidths) == 0 {
+		wiki.images = nil
+	} else if wiki.images == nil {
+		wiki.images = &imageCache{sizes: make(map[string]imageSize)}
+	}
+
+	// Load what pages depended on in earlier runs.
+	if wiki.CacheDir != "" && wiki.sourceIsDir {
+		if err := wiki.deps.load(dependenciesPath(wiki.CacheDir, wiki.SourceDir, wiki.DestDir)); err != nil {
+			wiki.log.Warning("Not using saved page dependencies: %v", err)
+		}
+	}
+
 	// Generate the part of the wiki that comes from content found in the source dir.
 	var relDestPaths map[string]bool
 	var processingErr error // Store error but don't return immediately
-	if relDestPaths, processingErr = wiki.generateFromContent(ctx, regen, version); processingErr != nil {
+	endPhase := wiki.beginPhase("content")
+	relDestPaths, processingErr = wiki.generateFromContent(ctx, regen, version)
+	endPhase()
+	if !wiki.DryRun {
+		if err := wiki.deps.save(); err != nil {
+			wiki.log.Warning("Failed to save page dependencies: %v", err)
+		}
+	}
+	if processingErr != nil {
 		// Log but continue - we still want CSS and cleanup for successfully processed files
-		util.PrintError(processingErr, "some files failed to process")
+		wiki.log.Error(processingErr, "some files failed to process")
 	}
 
 	// Check for cancellation before copying CSS files
@@ -180,8 +543,11 @@ func (wiki *Wiki) generate(ctx context.Context, regen, clean bool, version strin
 
 	// Copy css files to destDir (even with partial results).
 	// This ensures successfully processed HTML files are usable and properly styled.
-	if err := wiki.copyCssFiles(ctx, relDestPaths); err != nil {
-		util.PrintError(err, "failed to copy CSS files")
+	endPhase = wiki.beginPhase("css")
+	err = wiki.copyCssFiles(ctx, relDestPaths)
+	endPhase()
+	if err != nil {
+		wiki.log.Error(err, "failed to copy CSS files")
 		// If no files were processed and CSS also failed, this is a total failure
 		if len(relDestPaths) == 0 {
 			return errors.Join(processingErr, err)
@@ -199,18 +565,21 @@ func (wiki *Wiki) generate(ctx context.Context, regen, clean bool, version strin
 	// If there were any errors (including MaxFilesProcessed limit), relDestPaths may be incomplete,
 	// and cleaning would incorrectly delete files that failed to process due to transient errors.
 	if clean && relDestPaths != nil && processingErr == nil {
-		if err := wiki.cleanDestDir(ctx, relDestPaths); err != nil {
+		endPhase = wiki.beginPhase("clean")
+		err = wiki.cleanDestDir(ctx, relDestPaths)
+		endPhase()
+		if err != nil {
 			return fmt.Errorf("failed to clean dest dir '%s': %v", wiki.DestDir, err)
 		}
 	} else if clean && processingErr != nil {
-		util.PrintWarning("Skipping clean due to processing errors - would risk deleting valid files")
+		wiki.log.Warning("Skipping clean due to processing errors - would risk deleting valid files")
 	}
 
 	// Partial success is success: If any files were processed, return success even if some failed.
 	// Only fail if nothing was processed (total failure).
 	if len(relDestPaths) > 0 {
 		if processingErr != nil {
-			util.PrintWarning("Build completed with errors, but %d file(s) were successfully processed", len(relDestPaths))
+			wiki.log.Warning("Build completed with errors, but %d file(s) were successfully processed", len(relDestPaths))
 		}
 		return nil
 	}
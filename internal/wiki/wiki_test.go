@@ -1,7 +1,9 @@
 package wiki
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -9,6 +11,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/stalexan/gomarkwiki/internal/util"
 )
 
 const PACKAGE_DIR = "../.."
@@ -83,7 +87,7 @@ func TestA01GenerateTinyWiki(t *testing.T) {
 	testCaseDataDir := filepath.Join(testDataDir, "a01-tiny-wiki")
 	sourceDir := filepath.Join(testCaseDataDir, "source")
 	var theWiki *Wiki
-	if theWiki, err = NewWiki(sourceDir, outputDir); err != nil {
+	if theWiki, err = NewWiki(sourceDir, outputDir, nil); err != nil {
 		t.Fatalf("Error creating Wiki instance: %v", err)
 	}
 
@@ -179,7 +183,7 @@ func TestCollisionDetectionDeterministic(t *testing.T) {
 
 	// Create Wiki instance
 	var theWiki *Wiki
-	if theWiki, err = NewWiki(sourceDir, outputDir); err != nil {
+	if theWiki, err = NewWiki(sourceDir, outputDir, nil); err != nil {
 		t.Fatalf("Error creating Wiki instance: %v", err)
 	}
 
@@ -330,7 +334,7 @@ func TestCollisionDetectionNestedDirs(t *testing.T) {
 
 	// Create Wiki instance
 	var theWiki *Wiki
-	if theWiki, err = NewWiki(sourceDir, outputDir); err != nil {
+	if theWiki, err = NewWiki(sourceDir, outputDir, nil); err != nil {
 		t.Fatalf("Error creating Wiki instance: %v", err)
 	}
 
@@ -453,7 +457,7 @@ func TestIgnoreFileSkipsBlankAndCommentLines(t *testing.T) {
 		t.Fatalf("Failed to write ignore.txt: %v", err)
 	}
 
-	theWiki, err := NewWiki(sourceDir, outputDir)
+	theWiki, err := NewWiki(sourceDir, outputDir, nil)
 	if err != nil {
 		t.Fatalf("Error creating Wiki instance: %v", err)
 	}
@@ -507,7 +511,7 @@ func TestConfigPathsNotSetWhenFilesMissing(t *testing.T) {
 		t.Fatalf("Failed to copy github-style.css: %v", err)
 	}
 
-	theWiki, err := NewWiki(sourceDir, outputDir)
+	theWiki, err := NewWiki(sourceDir, outputDir, nil)
 	if err != nil {
 		t.Fatalf("Error creating Wiki instance: %v", err)
 	}
@@ -566,7 +570,7 @@ func TestConfigPathsSetWhenFilesExist(t *testing.T) {
 		t.Fatalf("Failed to write substitution file: %v", err)
 	}
 
-	theWiki, err := NewWiki(sourceDir, outputDir)
+	theWiki, err := NewWiki(sourceDir, outputDir, nil)
 	if err != nil {
 		t.Fatalf("Error creating Wiki instance: %v", err)
 	}
@@ -726,9 +730,9 @@ func TestValidatePlaceholder(t *testing.T) {
 
 func TestMakeSubstitutions(t *testing.T) {
 	wiki := Wiki{
-		subStrings: [][2]string{
-			{"{{SITE}}", "example.com"},
-			{"{{YEAR}}", "2024"},
+		subStrings: map[string]string{
+			"SITE": "example.com",
+			"YEAR": "2024",
 		},
 	}
 
@@ -745,7 +749,7 @@ func TestMakeSubstitutions(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := wiki.makeSubstitutions([]byte(tt.input))
+			got, _ := wiki.makeSubstitutions([]byte(tt.input), nil)
 			if string(got) != tt.want {
 				t.Errorf("makeSubstitutions() = %q, want %q", got, tt.want)
 			}
@@ -935,11 +939,7 @@ func TestIgnorePatternPathAnchoring(t *testing.T) {
 }
 
 func TestLoadSubstitutionStrings(t *testing.T) {
-	tmpDir, err := os.MkdirTemp(tempDir, "subs-test")
-	if err != nil {
-		t.Fatalf("Error creating test temp directory: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
+	tmpDir := t.TempDir()
 
 	t.Run("valid substitutions", func(t *testing.T) {
 		sourceDir := filepath.Join(tmpDir, "valid-source")
@@ -949,7 +949,7 @@ func TestLoadSubstitutionStrings(t *testing.T) {
 			t.Fatalf("Failed to create test file: %v", err)
 		}
 
-		wiki := &Wiki{SourceDir: sourceDir}
+		wiki := &Wiki{SourceDir: sourceDir, source: os.DirFS(sourceDir)}
 		if err := wiki.loadSubstitutionStrings(); err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -967,7 +967,7 @@ func TestLoadSubstitutionStrings(t *testing.T) {
 			t.Fatalf("Failed to create test file: %v", err)
 		}
 
-		wiki := &Wiki{SourceDir: sourceDir}
+		wiki := &Wiki{SourceDir: sourceDir, source: os.DirFS(sourceDir)}
 		err := wiki.loadSubstitutionStrings()
 		if err == nil {
 			t.Error("expected error for duplicate placeholders")
@@ -985,7 +985,7 @@ func TestLoadSubstitutionStrings(t *testing.T) {
 			t.Fatalf("Failed to create test file: %v", err)
 		}
 
-		wiki := &Wiki{SourceDir: sourceDir}
+		wiki := &Wiki{SourceDir: sourceDir, source: os.DirFS(sourceDir)}
 		err := wiki.loadSubstitutionStrings()
 		if err == nil {
 			t.Error("expected error for invalid placeholder")
@@ -1127,7 +1127,7 @@ func TestSourceIsOlder(t *testing.T) {
 		}
 		sourceInfo, _ := os.Stat(sourcePath)
 
-		if sourceIsOlder(sourceInfo, destPath) {
+		if sourceIsOlder(sourceInfo, NewDirOutput(tmpDir, util.DiscardLogger()), filepath.Base(destPath)) {
 			t.Error("expected source to be newer than dest")
 		}
 	})
@@ -1139,7 +1139,7 @@ func TestSourceIsOlder(t *testing.T) {
 		}
 		sourceInfo, _ := os.Stat(sourcePath)
 
-		if sourceIsOlder(sourceInfo, filepath.Join(tmpDir, "nonexistent.txt")) {
+		if sourceIsOlder(sourceInfo, NewDirOutput(tmpDir, util.DiscardLogger()), "nonexistent.txt") {
 			t.Error("expected false when dest doesn't exist")
 		}
 	})
@@ -1197,7 +1197,7 @@ func TestNewWikiValidation(t *testing.T) {
 	os.MkdirAll(validDest, 0755)
 
 	t.Run("valid wiki", func(t *testing.T) {
-		wiki, err := NewWiki(validSource, validDest)
+		wiki, err := NewWiki(validSource, validDest, nil)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -1207,7 +1207,7 @@ func TestNewWikiValidation(t *testing.T) {
 	})
 
 	t.Run("same source and dest", func(t *testing.T) {
-		_, err := NewWiki(validSource, validSource)
+		_, err := NewWiki(validSource, validSource, nil)
 		if err == nil {
 			t.Error("expected error for same source and dest")
 		}
@@ -1216,7 +1216,7 @@ func TestNewWikiValidation(t *testing.T) {
 	t.Run("dest under source", func(t *testing.T) {
 		nestedDest := filepath.Join(validSource, "output")
 		os.MkdirAll(nestedDest, 0755)
-		_, err := NewWiki(validSource, nestedDest)
+		_, err := NewWiki(validSource, nestedDest, nil)
 		if err == nil {
 			t.Error("expected error for dest under source")
 		}
@@ -1226,14 +1226,14 @@ func TestNewWikiValidation(t *testing.T) {
 		nestedSource := filepath.Join(validDest, "source")
 		nestedContent := filepath.Join(nestedSource, "content")
 		os.MkdirAll(nestedContent, 0755)
-		_, err := NewWiki(nestedSource, validDest)
+		_, err := NewWiki(nestedSource, validDest, nil)
 		if err == nil {
 			t.Error("expected error for source under dest")
 		}
 	})
 
 	t.Run("missing source dir", func(t *testing.T) {
-		_, err := NewWiki(filepath.Join(tmpDir, "nonexistent"), validDest)
+		_, err := NewWiki(filepath.Join(tmpDir, "nonexistent"), validDest, nil)
 		if err == nil {
 			t.Error("expected error for missing source")
 		}
@@ -1242,7 +1242,7 @@ func TestNewWikiValidation(t *testing.T) {
 	t.Run("missing content dir", func(t *testing.T) {
 		noContentSource := filepath.Join(tmpDir, "no-content")
 		os.MkdirAll(noContentSource, 0755)
-		_, err := NewWiki(noContentSource, validDest)
+		_, err := NewWiki(noContentSource, validDest, nil)
 		if err == nil {
 			t.Error("expected error for missing content dir")
 		}
@@ -1317,7 +1317,7 @@ func TestTakeFilesSnapshot(t *testing.T) {
 	os.WriteFile(filepath.Join(tmpDir, "file1.txt"), []byte("content"), 0644)
 	os.WriteFile(filepath.Join(subdir, "file2.txt"), []byte("content"), 0644)
 
-	snapshot, err := takeFilesSnapshot(context.Background(), tmpDir, nil)
+	snapshot, err := takeFilesSnapshot(context.Background(), util.DiscardLogger(), tmpDir, nil, false)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -1376,7 +1376,7 @@ func TestTakeFilesSnapshotWithIgnorePatterns(t *testing.T) {
 	}
 
 	// Take snapshot with ignore patterns
-	snapshot, err := takeFilesSnapshot(context.Background(), tmpDir, ignoreMatcher)
+	snapshot, err := takeFilesSnapshot(context.Background(), util.DiscardLogger(), tmpDir, ignoreMatcher, false)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -1437,7 +1437,7 @@ func TestWikiWithSubstitutionStrings(t *testing.T) {
 	os.WriteFile(mdPath, []byte(mdContent), 0644)
 
 	// Generate wiki
-	wiki, err := NewWiki(sourceDir, destDir)
+	wiki, err := NewWiki(sourceDir, destDir, nil)
 	if err != nil {
 		t.Fatalf("failed to create wiki: %v", err)
 	}
@@ -1476,3 +1476,35 @@ func TestWikiWithSubstitutionStrings(t *testing.T) {
 		t.Error("{{YEAR}} placeholder should have been replaced")
 	}
 }
+
+func TestGenerateLogAttributes(t *testing.T) {
+	theWiki, _ := includeTestWiki(t, map[string]string{
+		"index.md":  "# Index",
+		"Broken.md": "{{include: Missing.md}}",
+	})
+	var buf bytes.Buffer
+	theWiki, err := NewWiki(theWiki.SourceDir, theWiki.DestDir, util.NewJSONLogger(&buf, true, false))
+	if err != nil {
+		t.Fatalf("NewWiki failed: %v", err)
+	}
+	if err := theWiki.Generate(context.Background(), false, false, false, "test"); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	phases := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON %q: %v", line, err)
+		}
+		if record["wiki"] != "source" || record["source"] != theWiki.SourceDir {
+			t.Errorf("record %v is missing wiki and source", record)
+		}
+		if phase, ok := record["phase"].(string); ok {
+			phases[phase] = true
+		}
+	}
+	if !phases["content"] || !phases["css"] {
+		t.Errorf("phases logged = %v, want content and css", phases)
+	}
+}